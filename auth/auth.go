@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"zeno/domain"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionCookie holds the UI's session token
+const SessionCookie = "zeno_session"
+
+// SessionDuration is how long a login lasts
+const SessionDuration = 30 * 24 * time.Hour
+
+// KeyPrefix starts every generated key, so keys are easy to recognise
+const KeyPrefix = "zk_"
+
+// MinPasswordLength is the shortest password users can have
+const MinPasswordLength = 8
+
+var (
+	// Unauthenticated is returned when a request has no valid credentials
+	Unauthenticated = errors.New("unauthenticated")
+	// InvalidKey is returned when logging in with an unknown key
+	InvalidKey = errors.New("invalid key")
+	// InvalidPassword is returned when logging in with an unknown user or
+	// the wrong password
+	InvalidPassword = errors.New("invalid user name or password")
+)
+
+// dummyHash is compared against when logging in as an unknown user, so
+// it takes as long as logging in with the wrong password
+const dummyHash = "$2a$10$NbrUUQBpM3TMtGG85tBGVOXhnCI6Q4C1Gam8nkYaLygR0qYDpbmOS"
+
+// Store keeps API keys and sessions by the hash of their secret, and
+// users with the hash of their password
+type Store interface {
+	GetApiKeyByHash(ctx context.Context, hash string) (domain.ApiKey, error)
+	GetUserByName(ctx context.Context, name string) (domain.User, string, error)
+	SaveSession(ctx context.Context, hash string, session domain.Session) error
+	GetSession(ctx context.Context, hash string) (domain.Session, error)
+	DeleteSession(ctx context.Context, hash string) error
+}
+
+// Identity is who a request is made by. An empty UserID is the instance
+// admin, who can see and change every document.
+type Identity struct {
+	UserID string
+	Scope  domain.Scope
+}
+
+type identityKey struct{}
+
+// IdentityOf returns the identity Require stored in a request's context
+func IdentityOf(ctx context.Context) Identity {
+	identity, _ := ctx.Value(identityKey{}).(Identity)
+	return identity
+}
+
+// Authenticator checks the API keys and session cookies requests carry.
+// The master key is allowed to do everything. When authentication isn't
+// required every request is allowed.
+type Authenticator struct {
+	store     Store
+	masterKey string
+	required  bool
+}
+
+func NewAuthenticator(store Store, masterKey string, required bool) Authenticator {
+	if store == nil {
+		panic("store cannot be nil")
+	}
+	return Authenticator{
+		store:     store,
+		masterKey: masterKey,
+		required:  required,
+	}
+}
+
+// Required reports whether requests need credentials
+func (a Authenticator) Required() bool {
+	return a.required
+}
+
+// Hash returns the hash secrets are stored by
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate secret: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// NewUser returns a user with a new ID along with the hash of their
+// password
+func NewUser(name, password string) (domain.User, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return domain.User{}, "", errors.New("user name cannot be empty")
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return domain.User{}, "", err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return domain.User{}, "", err
+	}
+	return domain.User{
+		ID:        id,
+		Name:      name,
+		CreatedAt: domain.Timestamp(time.Now().Truncate(time.Second)),
+	}, hash, nil
+}
+
+// HashPassword returns the hash passwords are stored by
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("could not hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// NewKey generates an API key with the given scope, acting as the user
+// with userID if it isn't empty. The key is returned along with the hash
+// it should be stored by.
+func NewKey(name string, scope domain.Scope, userID string) (domain.ApiKey, string, string, error) {
+	id, err := randomHex(4)
+	if err != nil {
+		return domain.ApiKey{}, "", "", err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return domain.ApiKey{}, "", "", err
+	}
+	key := KeyPrefix + secret
+	return domain.ApiKey{
+		ID:        id,
+		Name:      name,
+		Scope:     scope,
+		UserID:    userID,
+		CreatedAt: domain.Timestamp(time.Now().Truncate(time.Second)),
+	}, key, Hash(key), nil
+}
+
+// checkKey returns who a key identifies and the ID of the stored key,
+// which is empty for the master key
+func (a Authenticator) checkKey(ctx context.Context, key string) (Identity, string, error) {
+	if a.masterKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.masterKey)) == 1 {
+		return Identity{Scope: domain.WriteScope}, "", nil
+	}
+	apiKey, err := a.store.GetApiKeyByHash(ctx, Hash(key))
+	if err != nil {
+		return Identity{}, "", InvalidKey
+	}
+	return Identity{UserID: apiKey.UserID, Scope: apiKey.Scope}, apiKey.ID, nil
+}
+
+// bearerKey returns the key from an "Authorization: Bearer" header
+func bearerKey(request *http.Request) string {
+	header := request.Header.Get("Authorization")
+	if len(header) > len("bearer ") && strings.EqualFold(header[:len("bearer ")], "bearer ") {
+		return strings.TrimSpace(header[len("bearer "):])
+	}
+	return ""
+}
+
+// Authenticate returns who the API key or session a request carries
+// identifies
+func (a Authenticator) Authenticate(request *http.Request) (Identity, error) {
+	if key := bearerKey(request); key != "" {
+		identity, _, err := a.checkKey(request.Context(), key)
+		if err != nil {
+			return Identity{}, Unauthenticated
+		}
+		return identity, nil
+	}
+	if cookie, err := request.Cookie(SessionCookie); err == nil && cookie.Value != "" {
+		session, getErr := a.store.GetSession(request.Context(), Hash(cookie.Value))
+		if getErr != nil {
+			return Identity{}, Unauthenticated
+		}
+		return Identity{UserID: session.UserID, Scope: session.Scope}, nil
+	}
+	return Identity{}, Unauthenticated
+}
+
+// Require only lets requests through that are allowed the scope, storing
+// who made them in their context
+func (a Authenticator) Require(scope domain.Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !a.required {
+			admin := Identity{Scope: domain.WriteScope}
+			next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), identityKey{}, admin)))
+			return
+		}
+		identity, err := a.Authenticate(request)
+		if err != nil {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="zeno"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+			if _, err := writer.Write([]byte("authentication required")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		if !identity.Scope.Allows(scope) {
+			writer.WriteHeader(http.StatusForbidden)
+			if _, err := writer.Write([]byte(fmt.Sprintf("%s scope required", scope))); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		next.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), identityKey{}, identity)))
+	})
+}
+
+// Login starts a session for a key and returns its token
+func (a Authenticator) Login(ctx context.Context, key string) (string, domain.Session, error) {
+	identity, keyID, err := a.checkKey(ctx, key)
+	if err != nil {
+		return "", domain.Session{}, err
+	}
+	return a.startSession(ctx, domain.Session{KeyID: keyID, UserID: identity.UserID, Scope: identity.Scope})
+}
+
+// LoginUser starts a session for a user and returns its token. Users can
+// do everything with their own documents.
+func (a Authenticator) LoginUser(ctx context.Context, name, password string) (string, domain.Session, error) {
+	user, hash, err := a.store.GetUserByName(ctx, name)
+	if err != nil {
+		hash = dummyHash
+	}
+	if compareErr := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); compareErr != nil || err != nil {
+		return "", domain.Session{}, InvalidPassword
+	}
+	return a.startSession(ctx, domain.Session{UserID: user.ID, Scope: domain.WriteScope})
+}
+
+func (a Authenticator) startSession(ctx context.Context, session domain.Session) (string, domain.Session, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", domain.Session{}, err
+	}
+	session.ExpiresAt = domain.Timestamp(time.Now().Add(SessionDuration).Truncate(time.Second))
+	if err := a.store.SaveSession(ctx, Hash(token), session); err != nil {
+		return "", domain.Session{}, err
+	}
+	return token, session, nil
+}
+
+// Logout ends the session with the token
+func (a Authenticator) Logout(ctx context.Context, token string) error {
+	return a.store.DeleteSession(ctx, Hash(token))
+}
+
+// SetSessionCookie stores a session token in the browser. The cookie is
+// strict so cross site requests, including GET links to endpoints that
+// change documents, don't carry it.
+func SetSessionCookie(writer http.ResponseWriter, request *http.Request, token string, expires time.Time) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   request.TLS != nil || request.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookie removes the session token from the browser
+func ClearSessionCookie(writer http.ResponseWriter) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
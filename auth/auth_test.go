@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"zeno/domain"
+)
+
+type memStore struct {
+	keys     map[string]domain.ApiKey
+	sessions map[string]domain.Session
+	users    map[string]domain.User
+	hashes   map[string]string
+}
+
+func (m memStore) GetUserByName(_ context.Context, name string) (domain.User, string, error) {
+	if user, ok := m.users[name]; ok {
+		return user, m.hashes[user.ID], nil
+	}
+	return domain.User{}, "", errors.New("not found")
+}
+
+func (m memStore) GetApiKeyByHash(_ context.Context, hash string) (domain.ApiKey, error) {
+	if key, ok := m.keys[hash]; ok {
+		return key, nil
+	}
+	return domain.ApiKey{}, errors.New("not found")
+}
+
+func (m memStore) SaveSession(_ context.Context, hash string, session domain.Session) error {
+	m.sessions[hash] = session
+	return nil
+}
+
+func (m memStore) GetSession(_ context.Context, hash string) (domain.Session, error) {
+	if session, ok := m.sessions[hash]; ok && time.Time(session.ExpiresAt).After(time.Now()) {
+		return session, nil
+	}
+	return domain.Session{}, errors.New("not found")
+}
+
+func (m memStore) DeleteSession(_ context.Context, hash string) error {
+	delete(m.sessions, hash)
+	return nil
+}
+
+func TestRequire(t *testing.T) {
+	store := memStore{
+		keys:     map[string]domain.ApiKey{},
+		sessions: map[string]domain.Session{},
+		users:    map[string]domain.User{},
+		hashes:   map[string]string{},
+	}
+	readKey, readSecret, readHash, err := NewKey("reader", domain.ReadScope, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.keys[readHash] = readKey
+	a := NewAuthenticator(store, "master", true)
+	sessionToken, _, err := a.Login(context.Background(), readSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Login(context.Background(), "wrong"); !errors.Is(err, InvalidKey) {
+		t.Errorf("Login() with wrong key error = %v, want InvalidKey", err)
+	}
+
+	user, passwordHash, err := NewUser("ada", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.users[user.Name], store.hashes[user.ID] = user, passwordHash
+	userKey, userSecret, userHash, err := NewKey("ada's", domain.WriteScope, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.keys[userHash] = userKey
+	userToken, _, err := a.LoginUser(context.Background(), "ada", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, login := range [][2]string{{"ada", "wrong password"}, {"bob", "correct horse"}} {
+		if _, _, err := a.LoginUser(context.Background(), login[0], login[1]); !errors.Is(err, InvalidPassword) {
+			t.Errorf("LoginUser(%q, %q) error = %v, want InvalidPassword", login[0], login[1], err)
+		}
+	}
+
+	var identity Identity
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = IdentityOf(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+	tests := []struct {
+		name   string
+		scope  domain.Scope
+		method string
+		bearer string
+		cookie string
+		open   bool
+		want   int
+		user   string
+	}{
+		{name: "no credentials", scope: domain.ReadScope, want: http.StatusUnauthorized},
+		{name: "not required", scope: domain.WriteScope, open: true, want: http.StatusOK},
+		{name: "options", scope: domain.WriteScope, method: http.MethodOptions, want: http.StatusUnauthorized},
+		{name: "master key", scope: domain.WriteScope, bearer: "master", want: http.StatusOK},
+		{name: "read key reads", scope: domain.ReadScope, bearer: readSecret, want: http.StatusOK},
+		{name: "read key writes", scope: domain.WriteScope, bearer: readSecret, want: http.StatusForbidden},
+		{name: "unknown key", scope: domain.ReadScope, bearer: "zk_nope", want: http.StatusUnauthorized},
+		{name: "session", scope: domain.ReadScope, cookie: sessionToken, want: http.StatusOK},
+		{name: "session scope", scope: domain.WriteScope, cookie: sessionToken, want: http.StatusForbidden},
+		{name: "unknown session", scope: domain.ReadScope, cookie: "nope", want: http.StatusUnauthorized},
+		{name: "user key", scope: domain.WriteScope, bearer: userSecret, want: http.StatusOK, user: user.ID},
+		{name: "user session", scope: domain.WriteScope, cookie: userToken, want: http.StatusOK, user: user.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			request := httptest.NewRequest(method, "/zeno/search", nil)
+			if tt.bearer != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.cookie})
+			}
+			authenticator := a
+			if tt.open {
+				authenticator = NewAuthenticator(store, "", false)
+			}
+			identity = Identity{}
+			recorder := httptest.NewRecorder()
+			authenticator.Require(tt.scope, ok).ServeHTTP(recorder, request)
+			if recorder.Code != tt.want {
+				t.Errorf("Require() status = %d, want %d", recorder.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && identity.UserID != tt.user {
+				t.Errorf("Require() identified user %q, want %q", identity.UserID, tt.user)
+			}
+		})
+	}
+
+	if err := a.Logout(context.Background(), sessionToken); err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/zeno/search", nil)
+	request.AddCookie(&http.Cookie{Name: SessionCookie, Value: sessionToken})
+	if _, err := a.Authenticate(request); !errors.Is(err, Unauthenticated) {
+		t.Errorf("Authenticate() after logout error = %v, want Unauthenticated", err)
+	}
+}
@@ -0,0 +1,240 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	manifestName = "manifest.json"
+	dbName       = "zeno.db"
+	filesPrefix  = "files/"
+	searchPrefix = "search/"
+	version      = 1
+)
+
+type DbBackuper interface {
+	Backup(ctx context.Context, path string) error
+}
+
+type Dumper interface {
+	Dump(ctx context.Context) (string, error)
+}
+
+// Sources are the parts of a zeno instance that are backed up. Search may
+// be nil when the search index is rebuilt from the db instead.
+type Sources struct {
+	DB       DbBackuper
+	Search   Dumper
+	FilesDir string
+}
+
+type manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     int       `json:"files"`
+	Search    string    `json:"search,omitempty"`
+}
+
+// Write writes a gzipped tar archive of the db, a dump of the search index
+// and the stored files to w
+func Write(ctx context.Context, w io.Writer, src Sources) error {
+	tmpDir, err := os.MkdirTemp("", "zeno-backup-")
+	if err != nil {
+		return fmt.Errorf("could not create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, dbName)
+	if backupErr := src.DB.Backup(ctx, dbPath); backupErr != nil {
+		return backupErr
+	}
+
+	var dumpPath string
+	if src.Search != nil {
+		var dumpErr error
+		if dumpPath, dumpErr = src.Search.Dump(ctx); dumpErr != nil {
+			return dumpErr
+		}
+		defer func() {
+			if err := os.Remove(dumpPath); err != nil {
+				log.Println("could not remove dump:", err)
+			}
+		}()
+	}
+
+	var fileNames []string
+	if src.FilesDir != "" {
+		entries, readErr := os.ReadDir(src.FilesDir)
+		if readErr != nil && !errors.Is(readErr, os.ErrNotExist) {
+			return fmt.Errorf("could not read files: %w", readErr)
+		}
+		for _, entry := range entries {
+			// skip uploads that are still being written
+			if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+				fileNames = append(fileNames, entry.Name())
+			}
+		}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	m := manifest{
+		Version:   version,
+		CreatedAt: time.Now().UTC(),
+		Files:     len(fileNames),
+	}
+	if dumpPath != "" {
+		m.Search = searchPrefix + filepath.Base(dumpPath)
+	}
+	manifestBytes, _ := json.MarshalIndent(m, "", "  ")
+	if err := writeEntry(tw, manifestName, int64(len(manifestBytes)), strings.NewReader(string(manifestBytes))); err != nil {
+		return err
+	}
+	if err := addFile(tw, dbName, dbPath); err != nil {
+		return err
+	}
+	if dumpPath != "" {
+		if err := addFile(tw, m.Search, dumpPath); err != nil {
+			return err
+		}
+	}
+	for _, name := range fileNames {
+		if err := addFile(tw, filesPrefix+name, filepath.Join(src.FilesDir, name)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("could not write backup: %w", err)
+	}
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, name, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("could not back up %s: %w", name, err)
+	}
+	defer f.Close()
+	info, statErr := f.Stat()
+	if statErr != nil {
+		return fmt.Errorf("could not back up %s: %w", name, statErr)
+	}
+	return writeEntry(tw, name, info.Size(), f)
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("could not write %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("could not write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Restored describes where the parts of a backup were restored to
+type Restored struct {
+	// DumpPath is the search index dump to import, empty when the
+	// backup has none
+	DumpPath string
+	Files    int
+}
+
+// Restore extracts a backup written by Write. The db is written to dbPath,
+// stored files to filesDir and the search dump to dumpDir.
+func Restore(r io.Reader, dbPath, filesDir, dumpDir string) (Restored, error) {
+	var restored Restored
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return restored, fmt.Errorf("not a zeno backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	var m manifest
+	var sawDb bool
+	for {
+		hdr, nextErr := tr.Next()
+		if nextErr == io.EOF {
+			break
+		}
+		if nextErr != nil {
+			return restored, fmt.Errorf("could not read backup: %w", nextErr)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		switch {
+		case name == manifestName:
+			if decodeErr := json.NewDecoder(tr).Decode(&m); decodeErr != nil {
+				return restored, fmt.Errorf("invalid manifest: %w", decodeErr)
+			}
+			if m.Version > version {
+				return restored, fmt.Errorf("backup version %d is newer than supported version %d", m.Version, version)
+			}
+		case name == dbName:
+			if extractErr := extract(tr, dbPath); extractErr != nil {
+				return restored, extractErr
+			}
+			sawDb = true
+		case strings.HasPrefix(name, filesPrefix):
+			target := filepath.Join(filesDir, path.Base(name))
+			if extractErr := extract(tr, target); extractErr != nil {
+				return restored, extractErr
+			}
+			restored.Files++
+		case strings.HasPrefix(name, searchPrefix):
+			restored.DumpPath = filepath.Join(dumpDir, path.Base(name))
+			if extractErr := extract(tr, restored.DumpPath); extractErr != nil {
+				return restored, extractErr
+			}
+		default:
+			log.Println("skipping unknown backup entry", hdr.Name)
+		}
+	}
+	if !sawDb {
+		return restored, errors.New("backup does not contain a db")
+	}
+	return restored, nil
+}
+
+// extract writes r to target through a temporary file, so a failed
+// restore does not leave partial files behind
+func extract(r io.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("could not restore %s: %w", target, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".restore-*")
+	if err != nil {
+		return fmt.Errorf("could not restore %s: %w", target, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, copyErr := io.Copy(tmp, r); copyErr != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("could not restore %s: %w", target, copyErr)
+	}
+	if closeErr := tmp.Close(); closeErr != nil {
+		return fmt.Errorf("could not restore %s: %w", target, closeErr)
+	}
+	if renameErr := os.Rename(tmp.Name(), target); renameErr != nil {
+		return fmt.Errorf("could not restore %s: %w", target, renameErr)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDb string
+
+func (f fakeDb) Backup(_ context.Context, path string) error {
+	return os.WriteFile(path, []byte(f), 0644)
+}
+
+type fakeDumper string
+
+func (f fakeDumper) Dump(context.Context) (string, error) {
+	return string(f), nil
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	src := t.TempDir()
+	filesDir := filepath.Join(src, "files")
+	require.NoError(t, os.Mkdir(filesDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(filesDir, "abc"), []byte("stored file"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(filesDir, ".upload-123"), []byte("partial"), 0644))
+	dumpPath := filepath.Join(src, "20240101-000000000.dump")
+	require.NoError(t, os.WriteFile(dumpPath, []byte("search dump"), 0644))
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(context.Background(), &buf, Sources{
+		DB:       fakeDb("sqlite db"),
+		Search:   fakeDumper(dumpPath),
+		FilesDir: filesDir,
+	}))
+	_, statErr := os.Stat(dumpPath)
+	assert.True(t, os.IsNotExist(statErr), "dump should be removed once archived")
+
+	dst := t.TempDir()
+	restored, err := Restore(&buf, filepath.Join(dst, "zeno.db"), filepath.Join(dst, "files"), filepath.Join(dst, "dumps"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored.Files)
+	assert.Equal(t, filepath.Join(dst, "dumps", "20240101-000000000.dump"), restored.DumpPath)
+
+	for path, want := range map[string]string{
+		filepath.Join(dst, "zeno.db"):   "sqlite db",
+		filepath.Join(dst, "files/abc"): "stored file",
+		restored.DumpPath:               "search dump",
+	} {
+		got, readErr := os.ReadFile(path)
+		require.NoError(t, readErr)
+		assert.Equal(t, want, string(got))
+	}
+	_, statErr = os.Stat(filepath.Join(dst, "files/.upload-123"))
+	assert.True(t, os.IsNotExist(statErr), "partial uploads should not be backed up")
+}
+
+func TestRestoreRejectsOtherArchives(t *testing.T) {
+	_, err := Restore(bytes.NewReader([]byte("not a backup")), "db", "files", "dumps")
+	assert.Error(t, err)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"zeno/auth"
+	"zeno/backup"
+	"zeno/db"
+	"zeno/domain"
+	"zeno/exporter"
+	"zeno/importer"
+	"zeno/indexer"
+	"zeno/scraper"
+)
+
+const usage = `usage: zeno [flags] [command]
+
+commands:
+  import [-format format] [-metadata-only] [-dry-run] export
+        import an export from a browser or read-later service
+  import-bookmarks [-metadata-only] [-dry-run] bookmarks.html
+        import a Netscape bookmark file exported by a browser
+  export [-format format] [-content] [-o file]
+        export every document, to stdout unless a file is given
+  backup [-o file]
+        back up the db, search index and stored files
+  restore [-force] backup.tar.gz
+        set up this instance from a backup, indexing its documents again
+        unless the search backend is meili, which restores its own dump
+  reindex
+        index every saved document again, e.g. after switching search backend
+  key create [-scope read|write] [-name name] [-user name]
+        create an api key, printing it once. Keys of a user act as that user
+  key list
+        list api keys
+  key revoke id
+        revoke an api key and the sessions logged in with it
+  user create name
+        create a user, reading their password from stdin
+  user password name
+        change a user's password, reading it from stdin
+  user list
+        list users
+  user delete name
+        delete a user with their keys, keeping their documents
+
+with the meili search backend, commands use the search server zeno is
+serving with, found at MEILI_HOST or http://localhost:7700`
+
+// searchStartTimeout is how long commands wait for the search server
+const searchStartTimeout = 30 * time.Second
+
+// dumpImportTimeout is how long restoring waits for the search server to
+// import a dump
+const dumpImportTimeout = 30 * time.Minute
+
+const restoreCommandName = "restore"
+
+// searchCommands are the commands that can't run without the search server
+var searchCommands = map[string]bool{
+	"import":           true,
+	"import-bookmarks": true,
+	"backup":           true,
+	"reindex":          true,
+}
+
+// waitHealthy polls check until it succeeds or timeout passes
+func waitHealthy(check func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !check() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return true
+}
+
+// RunCommand runs a one-off command given on the command line instead of
+// serving requests
+func RunCommand(args []string, s scraper.Scraper, repo db.GormRepo, backupSources backup.Sources, idx indexer.Indexer, searchBackend string) error {
+	switch args[0] {
+	case "import":
+		return importCommand(args, "", s, repo)
+	case "import-bookmarks":
+		return importCommand(args, "netscape", s, repo)
+	case "export":
+		return exportCommand(args, repo)
+	case "backup":
+		return backupCommand(args, backupSources)
+	case "reindex":
+		return reindexCommand(repo, idx)
+	case "key":
+		return keyCommand(args, repo)
+	case "user":
+		return userCommand(args, repo, os.Stdin)
+	case restoreCommandName:
+		// the backup was restored before starting up
+		if searchBackend == indexer.MeilisearchBackend {
+			log.Println("restored backup and its search dump")
+			return nil
+		}
+		// only meili's data is in the backup, other indexes are built again
+		log.Printf("restored backup, indexing its documents for the %s search backend\n", searchBackend)
+		return reindexCommand(repo, idx)
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+}
+
+func importCommand(args []string, format string, s scraper.Scraper, repo db.GormRepo) error {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	if format == "" {
+		fs.StringVar(
+			&format,
+			"format",
+			"netscape",
+			fmt.Sprintf("format of the export, one of %v", importer.Formats()),
+		)
+	}
+	metadataOnly := fs.Bool(
+		"metadata-only",
+		false,
+		"only save the documents' metadata without scraping them",
+	)
+	dryRun := fs.Bool(
+		"dry-run",
+		false,
+		"summarise the import without changing anything",
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected an export file")
+	}
+
+	parse, formatErr := importer.ParserFor(format)
+	if formatErr != nil {
+		return formatErr
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	docs, parseErr := parse(f)
+	if parseErr != nil {
+		return parseErr
+	}
+
+	if *dryRun {
+		summary := importer.Summarize(context.Background(), repo, docs)
+		out, _ := json.MarshalIndent(summary, "", "  ")
+		fmt.Println(string(out))
+		return nil
+	}
+	result := importer.Import(s, format, docs, !*metadataOnly)
+	log.Printf("queued %d documents, %d failed\n", result.Queued, len(result.Failed))
+	return nil
+}
+
+func exportCommand(args []string, repo db.GormRepo) error {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	format := fs.String(
+		"format",
+		"jsonl",
+		fmt.Sprintf("format of the export, one of %v", exporter.Formats()),
+	)
+	withContent := fs.Bool(
+		"content",
+		false,
+		"include the content of scraped documents",
+	)
+	out := fs.String(
+		"o",
+		"",
+		"file to write the export to",
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := exporter.Export(context.Background(), bw, repo, *format, *withContent); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func backupCommand(args []string, sources backup.Sources) error {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	out := fs.String(
+		"o",
+		fmt.Sprintf("zeno-backup-%s.tar.gz", time.Now().Format("20060102-150405")),
+		"file to write the backup to",
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if backupErr := backup.Write(context.Background(), f, sources); backupErr != nil {
+		_ = f.Close()
+		_ = os.Remove(*out)
+		return backupErr
+	}
+	if closeErr := f.Close(); closeErr != nil {
+		return closeErr
+	}
+	log.Println("wrote backup to", *out)
+	return nil
+}
+
+// restoreCommand extracts a backup into the paths this instance uses and
+// returns the search dump to import, if the backup has one
+func restoreCommand(args []string, dsn, meiliDataPath, blevePath, filesDir, dumpsDir string) (string, error) {
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	force := fs.Bool(
+		"force",
+		false,
+		"replace the existing db and search data",
+	)
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", err
+	}
+	if fs.NArg() != 1 {
+		return "", errors.New("expected a backup file")
+	}
+
+	dbPath := db.PathFromDsn(dsn)
+	for _, existing := range []string{dbPath, meiliDataPath, blevePath} {
+		if _, statErr := os.Stat(existing); statErr == nil && !*force {
+			return "", fmt.Errorf("%s already exists, use -force to replace it", existing)
+		}
+	}
+	// the old indexes would keep documents that aren't in the backup
+	for _, searchData := range []string{meiliDataPath, blevePath} {
+		if err := os.RemoveAll(searchData); err != nil {
+			return "", err
+		}
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	restored, restoreErr := backup.Restore(f, dbPath, filesDir, dumpsDir)
+	if restoreErr != nil {
+		return "", restoreErr
+	}
+	log.Printf("restored db to %s and %d files to %s\n", dbPath, restored.Files, filesDir)
+	return restored.DumpPath, nil
+}
+
+// reindexCommand indexes the saved documents, skipping hidden duplicates.
+// The saved search settings are applied first, so a new index has them.
+func reindexCommand(repo db.GormRepo, idx indexer.Indexer) error {
+	if applier, ok := idx.(indexer.SettingsApplier); ok {
+		settings, err := repo.GetSearchSettings(context.Background())
+		if err != nil {
+			return err
+		}
+		if err := applier.ApplySettings(settings); err != nil {
+			return err
+		}
+	}
+	indexed := 0
+	err := repo.Each(context.Background(), true, func(doc domain.ScrapedDoc) error {
+		if doc.DuplicateOf != "" {
+			return nil
+		}
+		if err := idx.Index(doc); err != nil {
+			return err
+		}
+		indexed++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("indexed %d documents\n", indexed)
+	return nil
+}
+
+func keyCommand(args []string, repo db.GormRepo) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected create, list or revoke\n%s", usage)
+	}
+	ctx := context.Background()
+	switch args[1] {
+	case "create":
+		fs := flag.NewFlagSet("key create", flag.ContinueOnError)
+		scopeStr := fs.String(
+			"scope",
+			string(domain.ReadScope),
+			fmt.Sprintf("what the key may do, %s or %s", domain.ReadScope, domain.WriteScope),
+		)
+		name := fs.String(
+			"name",
+			"",
+			"name to recognise the key by",
+		)
+		userName := fs.String(
+			"user",
+			"",
+			"user the key acts as, instead of administering every library",
+		)
+		if err := fs.Parse(args[2:]); err != nil {
+			return err
+		}
+		scope, scopeErr := domain.ParseScope(*scopeStr)
+		if scopeErr != nil {
+			return scopeErr
+		}
+		var userID string
+		if *userName != "" {
+			user, _, userErr := repo.GetUserByName(ctx, *userName)
+			if userErr != nil {
+				return fmt.Errorf("cannot find user %s: %w", *userName, userErr)
+			}
+			userID = user.ID
+		}
+		apiKey, key, hash, err := auth.NewKey(*name, scope, userID)
+		if err != nil {
+			return err
+		}
+		if saveErr := repo.SaveApiKey(ctx, apiKey, hash); saveErr != nil {
+			return saveErr
+		}
+		log.Printf("created %s key %s, it will not be shown again\n", apiKey.Scope, apiKey.ID)
+		fmt.Println(key)
+		return nil
+	case "list":
+		keys, err := repo.ListApiKeys(ctx)
+		if err != nil {
+			return err
+		}
+		users, err := repo.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		userNames := make(map[string]string, len(users))
+		for _, user := range users {
+			userNames[user.ID] = user.Name
+		}
+		for _, key := range keys {
+			userName := "-"
+			if key.UserID != "" {
+				userName = userNames[key.UserID]
+			}
+			fmt.Printf(
+				"%s\t%s\t%s\t%s\t%s\n",
+				key.ID, key.Scope, userName, time.Time(key.CreatedAt).Format(time.RFC3339), key.Name,
+			)
+		}
+		return nil
+	case "revoke":
+		if len(args) != 3 {
+			return errors.New("expected the id of the key to revoke")
+		}
+		if err := repo.DeleteApiKey(ctx, args[2]); err != nil {
+			return err
+		}
+		log.Println("revoked key", args[2])
+		return nil
+	}
+	return fmt.Errorf("unknown key command %q, expected create, list or revoke", args[1])
+}
+
+func userCommand(args []string, repo db.GormRepo, stdin io.Reader) error {
+	if len(args) < 2 {
+		return fmt.Errorf("expected create, password, list or delete\n%s", usage)
+	}
+	ctx := context.Background()
+	if args[1] == "list" {
+		users, err := repo.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			fmt.Printf("%s\t%s\t%s\n", user.ID, time.Time(user.CreatedAt).Format(time.RFC3339), user.Name)
+		}
+		return nil
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("expected the name of the user to %s", args[1])
+	}
+	name := args[2]
+	readPassword := func() (string, error) {
+		fmt.Fprint(os.Stderr, "password: ")
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", fmt.Errorf("cannot read password: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	switch args[1] {
+	case "create":
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		user, hash, err := auth.NewUser(name, password)
+		if err != nil {
+			return err
+		}
+		if saveErr := repo.SaveUser(ctx, user, hash); saveErr != nil {
+			return saveErr
+		}
+		log.Printf("created user %s with id %s\n", user.Name, user.ID)
+		return nil
+	case "password":
+		user, _, err := repo.GetUserByName(ctx, name)
+		if err != nil {
+			return fmt.Errorf("cannot find user %s: %w", name, err)
+		}
+		password, err := readPassword()
+		if err != nil {
+			return err
+		}
+		hash, err := auth.HashPassword(password)
+		if err != nil {
+			return err
+		}
+		if setErr := repo.SetPassword(ctx, user.ID, hash); setErr != nil {
+			return setErr
+		}
+		log.Printf("changed the password of %s\n", user.Name)
+		return nil
+	case "delete":
+		user, _, err := repo.GetUserByName(ctx, name)
+		if err != nil {
+			return fmt.Errorf("cannot find user %s: %w", name, err)
+		}
+		if deleteErr := repo.DeleteUser(ctx, user.ID); deleteErr != nil {
+			return deleteErr
+		}
+		log.Printf("deleted user %s\n", user.Name)
+		return nil
+	}
+	return fmt.Errorf("unknown user command %q, expected create, password, list or delete", args[1])
+}
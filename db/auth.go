@@ -0,0 +1,265 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+	"zeno/domain"
+)
+
+// ApiKey stores the hash of a key rather than the key
+type ApiKey struct {
+	ID        string `gorm:"primarykey"`
+	CreatedAt time.Time
+	Name      string
+	Hash      string `gorm:"uniqueIndex"`
+	Scope     string
+	UserID    string `gorm:"index"`
+}
+
+// Session stores the hash of a session token
+type Session struct {
+	Hash      string `gorm:"primarykey"`
+	CreatedAt time.Time
+	KeyID     string `gorm:"index"`
+	UserID    string `gorm:"index"`
+	Scope     string
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// User stores the bcrypt hash of a user's password
+type User struct {
+	ID           string `gorm:"primarykey"`
+	CreatedAt    time.Time
+	Name         string `gorm:"uniqueIndex"`
+	PasswordHash string
+}
+
+var NotFound = errors.New("not found")
+
+func apiKeyToDomain(rkey *ApiKey) domain.ApiKey {
+	return domain.ApiKey{
+		ID:        rkey.ID,
+		Name:      rkey.Name,
+		Scope:     domain.Scope(rkey.Scope),
+		UserID:    rkey.UserID,
+		CreatedAt: domain.Timestamp(rkey.CreatedAt),
+	}
+}
+
+func (s GormRepo) SaveApiKey(ctx context.Context, key domain.ApiKey, hash string) error {
+	if key.ID == "" {
+		return EmptyId
+	}
+	rkey := ApiKey{
+		ID:        key.ID,
+		CreatedAt: time.Time(key.CreatedAt),
+		Name:      key.Name,
+		Hash:      hash,
+		Scope:     string(key.Scope),
+		UserID:    key.UserID,
+	}
+	if err := s.db.WithContext(ctx).Create(&rkey).Error; err != nil {
+		return fmt.Errorf("cannot save api key: %w", err)
+	}
+	return nil
+}
+
+func (s GormRepo) GetApiKeyByHash(ctx context.Context, hash string) (domain.ApiKey, error) {
+	var rkey ApiKey
+	err := s.db.WithContext(ctx).Where("hash = ?", hash).Take(&rkey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.ApiKey{}, NotFound
+	}
+	if err != nil {
+		return domain.ApiKey{}, fmt.Errorf("cannot fetch api key: %w", err)
+	}
+	return apiKeyToDomain(&rkey), nil
+}
+
+func (s GormRepo) ListApiKeys(ctx context.Context) ([]domain.ApiKey, error) {
+	var rkeys []ApiKey
+	if err := s.db.WithContext(ctx).Order("created_at").Find(&rkeys).Error; err != nil {
+		return nil, fmt.Errorf("cannot fetch api keys: %w", err)
+	}
+	keys := make([]domain.ApiKey, 0, len(rkeys))
+	for i := range rkeys {
+		keys = append(keys, apiKeyToDomain(&rkeys[i]))
+	}
+	return keys, nil
+}
+
+func (s GormRepo) CountApiKeys(ctx context.Context) (int64, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&ApiKey{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("cannot count api keys: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteApiKey revokes a key along with the sessions logged in with it
+func (s GormRepo) DeleteApiKey(ctx context.Context, id string) error {
+	if id == "" {
+		return EmptyId
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&ApiKey{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return NotFound
+		}
+		return tx.Delete(&Session{}, "key_id = ?", id).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete api key %s: %w", id, err)
+	}
+	return nil
+}
+
+func (s GormRepo) SaveSession(ctx context.Context, hash string, session domain.Session) error {
+	rsession := Session{
+		Hash:      hash,
+		KeyID:     session.KeyID,
+		UserID:    session.UserID,
+		Scope:     string(session.Scope),
+		ExpiresAt: time.Time(session.ExpiresAt),
+	}
+	if err := s.db.WithContext(ctx).Create(&rsession).Error; err != nil {
+		return fmt.Errorf("cannot save session: %w", err)
+	}
+	return nil
+}
+
+// GetSession returns a session that has not expired yet
+func (s GormRepo) GetSession(ctx context.Context, hash string) (domain.Session, error) {
+	var rsession Session
+	err := s.db.WithContext(ctx).Where("hash = ? AND expires_at > ?", hash, time.Now()).Take(&rsession).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.Session{}, NotFound
+	}
+	if err != nil {
+		return domain.Session{}, fmt.Errorf("cannot fetch session: %w", err)
+	}
+	return domain.Session{
+		KeyID:     rsession.KeyID,
+		UserID:    rsession.UserID,
+		Scope:     domain.Scope(rsession.Scope),
+		ExpiresAt: domain.Timestamp(rsession.ExpiresAt),
+	}, nil
+}
+
+// DeleteSession removes a session along with any that have expired
+func (s GormRepo) DeleteSession(ctx context.Context, hash string) error {
+	err := s.db.WithContext(ctx).Delete(&Session{}, "hash = ? OR expires_at <= ?", hash, time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("cannot delete session: %w", err)
+	}
+	return nil
+}
+
+func userToDomain(ruser *User) domain.User {
+	return domain.User{
+		ID:        ruser.ID,
+		Name:      ruser.Name,
+		CreatedAt: domain.Timestamp(ruser.CreatedAt),
+	}
+}
+
+func (s GormRepo) SaveUser(ctx context.Context, user domain.User, passwordHash string) error {
+	if user.ID == "" {
+		return EmptyId
+	}
+	ruser := User{
+		ID:           user.ID,
+		CreatedAt:    time.Time(user.CreatedAt),
+		Name:         user.Name,
+		PasswordHash: passwordHash,
+	}
+	if err := s.db.WithContext(ctx).Create(&ruser).Error; err != nil {
+		return fmt.Errorf("cannot save user: %w", err)
+	}
+	return nil
+}
+
+// GetUserByName returns a user along with their password hash
+func (s GormRepo) GetUserByName(ctx context.Context, name string) (domain.User, string, error) {
+	var ruser User
+	err := s.db.WithContext(ctx).Where("name = ?", name).Take(&ruser).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.User{}, "", NotFound
+	}
+	if err != nil {
+		return domain.User{}, "", fmt.Errorf("cannot fetch user: %w", err)
+	}
+	return userToDomain(&ruser), ruser.PasswordHash, nil
+}
+
+func (s GormRepo) ListUsers(ctx context.Context) ([]domain.User, error) {
+	var rusers []User
+	if err := s.db.WithContext(ctx).Order("name").Find(&rusers).Error; err != nil {
+		return nil, fmt.Errorf("cannot fetch users: %w", err)
+	}
+	users := make([]domain.User, 0, len(rusers))
+	for i := range rusers {
+		users = append(users, userToDomain(&rusers[i]))
+	}
+	return users, nil
+}
+
+func (s GormRepo) CountUsers(ctx context.Context) (int64, error) {
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&User{}).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("cannot count users: %w", err)
+	}
+	return count, nil
+}
+
+// SetPassword changes a user's password and ends their sessions
+func (s GormRepo) SetPassword(ctx context.Context, id string, passwordHash string) error {
+	if id == "" {
+		return EmptyId
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", id).Update("password_hash", passwordHash)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return NotFound
+		}
+		return tx.Delete(&Session{}, "user_id = ?", id).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set password of user %s: %w", id, err)
+	}
+	return nil
+}
+
+// DeleteUser removes a user along with their keys and sessions. Their
+// documents are kept, only the admin can see them afterwards.
+func (s GormRepo) DeleteUser(ctx context.Context, id string) error {
+	if id == "" {
+		return EmptyId
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&User{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return NotFound
+		}
+		if err := tx.Delete(&ApiKey{}, "user_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Session{}, "user_id = ?", id).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete user %s: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"zeno/domain"
+)
+
+// searchSettingsID is the ID of the only row of search settings
+const searchSettingsID = 1
+
+// SearchSettings stores the search settings as JSON, so they can be
+// applied again to a new search index
+type SearchSettings struct {
+	ID        uint `gorm:"primarykey"`
+	UpdatedAt time.Time
+	Value     string
+}
+
+// GetSearchSettings returns the saved search settings, or the defaults
+// when none were saved
+func (s GormRepo) GetSearchSettings(ctx context.Context) (domain.SearchSettings, error) {
+	var rsettings []SearchSettings
+	if err := s.db.WithContext(ctx).Where("id = ?", searchSettingsID).Limit(1).Find(&rsettings).Error; err != nil {
+		return domain.SearchSettings{}, fmt.Errorf("cannot fetch search settings: %w", err)
+	}
+	settings := domain.DefaultSearchSettings()
+	if len(rsettings) == 0 {
+		return settings, nil
+	}
+	if err := json.Unmarshal([]byte(rsettings[0].Value), &settings); err != nil {
+		return domain.SearchSettings{}, fmt.Errorf("cannot read search settings: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveSearchSettings replaces the saved search settings
+func (s GormRepo) SaveSearchSettings(ctx context.Context, settings domain.SearchSettings) error {
+	value, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("cannot save search settings: %w", err)
+	}
+	rsettings := SearchSettings{ID: searchSettingsID, Value: string(value)}
+	if err := s.db.WithContext(ctx).Save(&rsettings).Error; err != nil {
+		return fmt.Errorf("cannot save search settings: %w", err)
+	}
+	return nil
+}
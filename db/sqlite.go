@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
 	"time"
 	"zeno/domain"
 )
@@ -20,21 +22,140 @@ type Document struct {
 	Scrape      bool
 	ParsedDate  time.Time
 	DocType     string
+	// Fingerprint stores the domain.SimHash bits, as sqlite cannot store
+	// uint64 values with the high bit set
+	Fingerprint int64
+	DuplicateOf string       `gorm:"index"`
+	Tags        []Tag        `gorm:"many2many:document_tags;constraint:OnDelete:CASCADE"`
+	Collections []Collection `gorm:"many2many:document_collections;constraint:OnDelete:CASCADE"`
+	Status      string       `gorm:"index"`
+	ReadAt      *time.Time
+	Progress    float64
+	Favourite   bool   `gorm:"index"`
+	Domain      string `gorm:"index"`
+	Owner       string `gorm:"index"`
+	Shared      bool
+	// DeletedAt moves documents to the trash, which queries skip unless
+	// they are unscoped
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	Annotations []Annotation
+}
+
+// Annotation is a note or highlight attached to a document
+type Annotation struct {
+	ID         string `gorm:"primarykey"`
+	DocumentID string `gorm:"index"`
+	CreatedAt  time.Time
+	Quote      string
+	Comment    string
+	Author     string
+}
+
+func annotationsOf(docID string, annotations []domain.Annotation) []Annotation {
+	rannotations := make([]Annotation, 0, len(annotations))
+	for _, a := range annotations {
+		rannotations = append(rannotations, Annotation{
+			ID:         a.ID,
+			DocumentID: docID,
+			CreatedAt:  time.Time(a.CreatedAt),
+			Quote:      a.Quote,
+			Comment:    a.Comment,
+			Author:     a.Author,
+		})
+	}
+	return rannotations
+}
+
+func domainAnnotations(rannotations []Annotation) []domain.Annotation {
+	annotations := make([]domain.Annotation, 0, len(rannotations))
+	for _, a := range rannotations {
+		annotations = append(annotations, domain.Annotation{
+			ID:        a.ID,
+			Quote:     a.Quote,
+			Comment:   a.Comment,
+			Author:    a.Author,
+			CreatedAt: domain.Timestamp(a.CreatedAt),
+		})
+	}
+	return annotations
+}
+
+type Tag struct {
+	Name string `gorm:"primarykey"`
+}
+
+// Collection is a named group of one owner's documents. Collections are
+// keyed by their owner and lower-cased name, so every user has collections
+// of their own and names differing only in case are the same collection.
+// Name keeps the case the collection was last saved with for display.
+type Collection struct {
+	Owner     string `gorm:"primarykey"`
+	LowerName string `gorm:"primarykey"`
+	Name      string
+}
+
+func collectionsOf(owner string, names []string) []Collection {
+	collections := make([]Collection, 0, len(names))
+	for _, name := range names {
+		collections = append(collections, Collection{Owner: owner, LowerName: strings.ToLower(name), Name: name})
+	}
+	return collections
+}
+
+func collectionNames(collections []Collection) []string {
+	names := make([]string, 0, len(collections))
+	for _, collection := range collections {
+		names = append(names, collection.Name)
+	}
+	return names
+}
+
+func tagsOf(names []string) []Tag {
+	tags := make([]Tag, 0, len(names))
+	for _, name := range names {
+		tags = append(tags, Tag{Name: name})
+	}
+	return tags
+}
+
+func tagNames(tags []Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
 }
 
 func scrapedDocToDocument(doc *domain.ScrapedDoc) Document {
 	return Document{
 		ID:          doc.ID,
+		CreatedAt:   time.Time(doc.CreatedAt),
 		Title:       doc.Title,
 		Description: doc.Description,
 		URL:         doc.URL,
 		Scrape:      doc.Scrape,
 		ParsedDate:  time.Time(doc.ParsedDate),
 		DocType:     string(doc.DocType),
+		Fingerprint: int64(doc.Fingerprint),
+		DuplicateOf: doc.DuplicateOf,
+		Tags:        tagsOf(doc.Tags),
+		Collections: collectionsOf(doc.Owner, doc.Collections),
+		Status:      string(doc.Status),
+		ReadAt:      (*time.Time)(doc.ReadAt),
+		Progress:    doc.Progress,
+		Favourite:   doc.Favourite,
+		Domain:      doc.Domain,
+		Owner:       doc.Owner,
+		Shared:      doc.Shared,
 	}
 }
 
 func documentToScrapedDoc(doc *Document) domain.ScrapedDoc {
+	var deletedAt *domain.Timestamp
+	if doc.DeletedAt.Valid {
+		timestamp := domain.Timestamp(doc.DeletedAt.Time)
+		deletedAt = &timestamp
+	}
 	return domain.ScrapedDoc{
 		Title:       doc.Title,
 		Description: doc.Description,
@@ -43,32 +164,129 @@ func documentToScrapedDoc(doc *Document) domain.ScrapedDoc {
 		Scrape:      doc.Scrape,
 		ParsedDate:  domain.Timestamp(doc.ParsedDate),
 		DocType:     domain.DocType(doc.DocType),
+		Fingerprint: domain.SimHash(doc.Fingerprint),
+		DuplicateOf: doc.DuplicateOf,
+		Tags:        tagNames(doc.Tags),
+		Collections: collectionNames(doc.Collections),
+		CreatedAt:   domain.Timestamp(doc.CreatedAt),
+		Status:      domain.ReadStatus(doc.Status),
+		ReadAt:      (*domain.Timestamp)(doc.ReadAt),
+		Progress:    doc.Progress,
+		Favourite:   doc.Favourite,
+		Domain:      doc.Domain,
+		Owner:       doc.Owner,
+		Shared:      doc.Shared,
+		DeletedAt:   deletedAt,
+		Annotations: domainAnnotations(doc.Annotations),
 	}
 }
 
+// DocumentContent holds the extracted content of a document apart from its
+// metadata, so listing documents does not load every page's text
+type DocumentContent struct {
+	ID      string `gorm:"primarykey"`
+	Content string
+}
+
+type StoredFile struct {
+	ID          string `gorm:"primarykey"`
+	CreatedAt   time.Time
+	Name        string
+	ContentType string
+	Size        int64
+}
+
 var EmptyId = errors.New("empty id")
 
+// batchSize is how many documents are loaded at once when iterating
+const batchSize = 100
+
 type GormRepo struct {
 	db *gorm.DB
 }
 
+// withAssociations loads the tags, collections and annotations of the
+// documents a query finds
+func withAssociations(db *gorm.DB) *gorm.DB {
+	return db.Preload("Tags").Preload("Collections").
+		Preload("Annotations", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		})
+}
+
 func (s GormRepo) Save(ctx context.Context, scrapedDoc domain.ScrapedDoc) error {
 	rdoc := scrapedDocToDocument(&scrapedDoc)
 	if rdoc.ID == "" {
 		return EmptyId
 	}
-	if err := s.db.Save(&rdoc).Error; err != nil {
+	if rdoc.CreatedAt.IsZero() {
+		// keep the original creation time when updating a document
+		s.db.Unscoped().Model(&Document{}).Select("created_at").Where("id = ?", rdoc.ID).Scan(&rdoc.CreatedAt)
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// saving a document in the trash takes it out again
+		if err := tx.Unscoped().Omit("Tags", "Collections", "Annotations").Save(&rdoc).Error; err != nil {
+			return err
+		}
+		if scrapedDoc.Content != "" {
+			content := DocumentContent{ID: rdoc.ID, Content: scrapedDoc.Content}
+			if err := tx.Save(&content).Error; err != nil {
+				return err
+			}
+		}
+		if scrapedDoc.Annotations != nil {
+			if err := replaceAnnotations(tx, rdoc.ID, scrapedDoc.Annotations); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&rdoc).Association("Tags").Replace(rdoc.Tags); err != nil {
+			return err
+		}
+		if len(rdoc.Collections) > 0 {
+			// renaming a collection in another case renames it everywhere
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "owner"}, {Name: "lower_name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"name"}),
+			}).Create(&rdoc.Collections).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&rdoc).Association("Collections").Replace(rdoc.Collections)
+	})
+	if err != nil {
 		return fmt.Errorf("cannot save document: %w", err)
 	}
 	return nil
 }
 
+// replaceAnnotations makes annotations the only annotations of the
+// document with docID
+func replaceAnnotations(tx *gorm.DB, docID string, annotations []domain.Annotation) error {
+	rannotations := annotationsOf(docID, annotations)
+	keep := make([]string, 0, len(rannotations))
+	for _, a := range rannotations {
+		keep = append(keep, a.ID)
+	}
+	stale := tx.Where("document_id = ?", docID)
+	if len(keep) > 0 {
+		stale = stale.Where("id NOT IN ?", keep)
+	}
+	if err := stale.Delete(&Annotation{}).Error; err != nil {
+		return err
+	}
+	if len(rannotations) == 0 {
+		return nil
+	}
+	return tx.Save(&rannotations).Error
+}
+
 func (s GormRepo) Get(ctx context.Context, scrapedDoc domain.ScrapedDoc) (domain.ScrapedDoc, error) {
 	var rdoc Document
 	if scrapedDoc.ID == "" {
 		return domain.ScrapedDoc{}, EmptyId
 	}
-	if err := s.db.First(&rdoc, "id = ?", scrapedDoc.ID).Error; err != nil {
+	if err := withAssociations(s.db).First(&rdoc, "id = ?", scrapedDoc.ID).Error; err != nil {
 		return domain.ScrapedDoc{}, fmt.Errorf("cannot fetch document: %w", err)
 	}
 	sd := documentToScrapedDoc(&rdoc)
@@ -77,7 +295,7 @@ func (s GormRepo) Get(ctx context.Context, scrapedDoc domain.ScrapedDoc) (domain
 
 func (s GormRepo) GetAll(ctx context.Context) ([]domain.ScrapedDoc, error) {
 	var rdocs []Document
-	if err := s.db.Find(&rdocs).Error; err != nil {
+	if err := withAssociations(s.db).Find(&rdocs).Error; err != nil {
 		return nil, fmt.Errorf("cannot fetch documents: %w", err)
 	}
 	scrapedDocs := make([]domain.ScrapedDoc, len(rdocs))
@@ -87,26 +305,375 @@ func (s GormRepo) GetAll(ctx context.Context) ([]domain.ScrapedDoc, error) {
 	return scrapedDocs, nil
 }
 
+// GetContent returns the stored content of the document with id, which
+// is empty for documents that were not scraped
+func (s GormRepo) GetContent(ctx context.Context, id string) (string, error) {
+	if id == "" {
+		return "", EmptyId
+	}
+	var contents []DocumentContent
+	if err := s.db.Where("id = ?", id).Limit(1).Find(&contents).Error; err != nil {
+		return "", fmt.Errorf("cannot fetch content: %w", err)
+	}
+	if len(contents) == 0 {
+		return "", nil
+	}
+	return contents[0].Content, nil
+}
+
+// DeleteContent forgets the stored content of the document with id, for
+// documents that should no longer be scraped
+func (s GormRepo) DeleteContent(ctx context.Context, id string) error {
+	if id == "" {
+		return EmptyId
+	}
+	if err := s.db.Delete(&DocumentContent{ID: id}).Error; err != nil {
+		return fmt.Errorf("cannot delete content: %w", err)
+	}
+	return nil
+}
+
+// Each calls fn with every document, loading them in batches. Content is
+// only loaded when withContent is set.
+func (s GormRepo) Each(ctx context.Context, withContent bool, fn func(domain.ScrapedDoc) error) error {
+	var rdocs []Document
+	var fnErr error
+	err := withAssociations(s.db.WithContext(ctx)).FindInBatches(&rdocs, batchSize, func(tx *gorm.DB, _ int) error {
+		contents := make(map[string]string)
+		if withContent {
+			ids := make([]string, len(rdocs))
+			for i := range rdocs {
+				ids[i] = rdocs[i].ID
+			}
+			var rcontents []DocumentContent
+			if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&rcontents).Error; err != nil {
+				return err
+			}
+			for _, c := range rcontents {
+				contents[c.ID] = c.Content
+			}
+		}
+		for i := range rdocs {
+			doc := documentToScrapedDoc(&rdocs[i])
+			doc.Content = contents[doc.ID]
+			if fnErr = fn(doc); fnErr != nil {
+				return fnErr
+			}
+		}
+		return nil
+	}).Error
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot fetch documents: %w", err)
+	}
+	return nil
+}
+
+// MarkDuplicates sets ids as duplicates of the document with keepId
+func (s GormRepo) MarkDuplicates(ctx context.Context, keepId string, ids []string) error {
+	if keepId == "" {
+		return EmptyId
+	}
+	if err := s.db.Model(&Document{}).
+		Where("id IN ?", ids).
+		Update("duplicate_of", keepId).Error; err != nil {
+		return fmt.Errorf("cannot mark duplicates: %w", err)
+	}
+	return nil
+}
+
 func (s GormRepo) Delete(ctx context.Context, scrapedDoc domain.ScrapedDoc) error {
 	rdoc := scrapedDocToDocument(&scrapedDoc)
 	if rdoc.ID == "" {
 		return EmptyId
 	}
-	if err := s.db.Delete(&rdoc).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&DocumentContent{ID: rdoc.ID}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Select("Tags", "Collections", "Annotations").Delete(&rdoc).Error
+	})
+	if err != nil {
 		return fmt.Errorf("cannot delete document: %w", err)
 	}
 	return nil
 }
 
+// Trash moves the documents with ids to the trash
+func (s GormRepo) Trash(ctx context.Context, ids []string) error {
+	if err := s.db.Where("id IN ?", ids).Delete(&Document{}).Error; err != nil {
+		return fmt.Errorf("cannot move documents to the trash: %w", err)
+	}
+	return nil
+}
+
+// GetTrashed returns the document with id if it is in the trash
+func (s GormRepo) GetTrashed(ctx context.Context, id string) (domain.ScrapedDoc, error) {
+	if id == "" {
+		return domain.ScrapedDoc{}, EmptyId
+	}
+	var rdoc Document
+	err := withAssociations(s.db.Unscoped()).
+		First(&rdoc, "id = ? AND deleted_at IS NOT NULL", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return domain.ScrapedDoc{}, NotFound
+	}
+	if err != nil {
+		return domain.ScrapedDoc{}, fmt.Errorf("cannot fetch document: %w", err)
+	}
+	return documentToScrapedDoc(&rdoc), nil
+}
+
+// Untrash takes the document with id out of the trash and returns it
+func (s GormRepo) Untrash(ctx context.Context, id string) (domain.ScrapedDoc, error) {
+	if id == "" {
+		return domain.ScrapedDoc{}, EmptyId
+	}
+	result := s.db.Unscoped().Model(&Document{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return domain.ScrapedDoc{}, fmt.Errorf("cannot take document out of the trash: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return domain.ScrapedDoc{}, NotFound
+	}
+	return s.Get(ctx, domain.ScrapedDoc{ID: id})
+}
+
+// ListTrash returns the documents in the trash, most recently deleted
+// first. Documents of every owner are listed when owner is empty.
+func (s GormRepo) ListTrash(ctx context.Context, owner string) ([]domain.ScrapedDoc, error) {
+	query := withAssociations(s.db.Unscoped()).Where("deleted_at IS NOT NULL")
+	if owner != "" {
+		query = query.Where("owner = ?", owner)
+	}
+	var rdocs []Document
+	if err := query.Order("deleted_at DESC").Find(&rdocs).Error; err != nil {
+		return nil, fmt.Errorf("cannot list trash: %w", err)
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(rdocs))
+	for i := range rdocs {
+		docs = append(docs, documentToScrapedDoc(&rdocs[i]))
+	}
+	return docs, nil
+}
+
+// TrashedBefore returns the IDs of the documents moved to the trash
+// before t
+func (s GormRepo) TrashedBefore(ctx context.Context, t time.Time) ([]string, error) {
+	var ids []string
+	if err := s.db.Unscoped().Model(&Document{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", t).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("cannot list trash: %w", err)
+	}
+	return ids, nil
+}
+
+// DeleteBatch permanently deletes the documents with ids along with their
+// content
+func (s GormRepo) DeleteBatch(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id IN ?", ids).Delete(&DocumentContent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM document_tags WHERE document_id IN ?", ids).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM document_collections WHERE document_id IN ?", ids).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("document_id IN ?", ids).Delete(&Annotation{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("id IN ?", ids).Delete(&Document{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("cannot delete documents: %w", err)
+	}
+	return nil
+}
+
+// DuplicatesOf returns the documents hidden as duplicates of the
+// documents with ids
+func (s GormRepo) DuplicatesOf(ctx context.Context, ids []string) ([]domain.ScrapedDoc, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var rdocs []Document
+	if err := withAssociations(s.db.WithContext(ctx)).Where("duplicate_of IN ?", ids).Find(&rdocs).Error; err != nil {
+		return nil, fmt.Errorf("cannot fetch duplicates: %w", err)
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(rdocs))
+	for i := range rdocs {
+		docs = append(docs, documentToScrapedDoc(&rdocs[i]))
+	}
+	return docs, nil
+}
+
+// FindByURL returns the documents saved from url, one for each user who
+// saved it
+func (s GormRepo) FindByURL(ctx context.Context, url string) ([]domain.ScrapedDoc, error) {
+	var rdocs []Document
+	if err := withAssociations(s.db.WithContext(ctx)).Where("url = ?", url).Find(&rdocs).Error; err != nil {
+		return nil, fmt.Errorf("cannot fetch documents: %w", err)
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(rdocs))
+	for i := range rdocs {
+		docs = append(docs, documentToScrapedDoc(&rdocs[i]))
+	}
+	return docs, nil
+}
+
+// FindByURLPrefix returns the documents whose URL starts with prefix, such
+// as the documents of the files in a directory
+func (s GormRepo) FindByURLPrefix(ctx context.Context, prefix string) ([]domain.ScrapedDoc, error) {
+	var rdocs []Document
+	// unlike LIKE, comparing the start of the URL is case sensitive and
+	// needs no escaping
+	if err := withAssociations(s.db.WithContext(ctx)).
+		Where("substr(url, 1, length(?)) = ?", prefix, prefix).Find(&rdocs).Error; err != nil {
+		return nil, fmt.Errorf("cannot fetch documents: %w", err)
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(rdocs))
+	for i := range rdocs {
+		docs = append(docs, documentToScrapedDoc(&rdocs[i]))
+	}
+	return docs, nil
+}
+
+func (s GormRepo) SaveFile(ctx context.Context, file domain.StoredFile) error {
+	if file.ID == "" {
+		return EmptyId
+	}
+	rfile := StoredFile{
+		ID:          file.ID,
+		CreatedAt:   time.Time(file.CreatedAt),
+		Name:        file.Name,
+		ContentType: file.ContentType,
+		Size:        file.Size,
+	}
+	if err := s.db.Save(&rfile).Error; err != nil {
+		return fmt.Errorf("cannot save file: %w", err)
+	}
+	return nil
+}
+
+func (s GormRepo) GetFile(ctx context.Context, id string) (domain.StoredFile, error) {
+	var rfile StoredFile
+	if id == "" {
+		return domain.StoredFile{}, EmptyId
+	}
+	if err := s.db.First(&rfile, "id = ?", id).Error; err != nil {
+		return domain.StoredFile{}, fmt.Errorf("cannot fetch file: %w", err)
+	}
+	return domain.StoredFile{
+		ID:          rfile.ID,
+		Name:        rfile.Name,
+		ContentType: rfile.ContentType,
+		Size:        rfile.Size,
+		CreatedAt:   domain.Timestamp(rfile.CreatedAt),
+	}, nil
+}
+
+// DeleteFile deletes the description of the stored file with id
+func (s GormRepo) DeleteFile(ctx context.Context, id string) error {
+	if id == "" {
+		return EmptyId
+	}
+	if err := s.db.WithContext(ctx).Delete(&StoredFile{ID: id}).Error; err != nil {
+		return fmt.Errorf("cannot delete file: %w", err)
+	}
+	return nil
+}
+
+// UnusedFiles returns the IDs of the stored files no document is made
+// from, counting those in the trash. Documents made from a file have
+// urlPrefix followed by the file's ID as their URL.
+func (s GormRepo) UnusedFiles(ctx context.Context, urlPrefix string) ([]string, error) {
+	var ids []string
+	if err := s.db.WithContext(ctx).Model(&StoredFile{}).
+		Where("NOT EXISTS (SELECT 1 FROM documents WHERE documents.url = ? || stored_files.id)", urlPrefix).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("cannot list unused files: %w", err)
+	}
+	return ids, nil
+}
+
+// DB is the underlying connection, for features that share the database
+// such as full text search
+func (s GormRepo) DB() *gorm.DB {
+	return s.db
+}
+
+// Backup writes a consistent copy of the database to path while it is in
+// use. path must not exist.
+func (s GormRepo) Backup(ctx context.Context, path string) error {
+	if err := s.db.WithContext(ctx).Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("cannot back up db: %w", err)
+	}
+	return nil
+}
+
+// PathFromDsn returns the file a sqlite dsn refers to
+func PathFromDsn(dsn string) string {
+	path := strings.TrimPrefix(dsn, "file:")
+	if i := strings.Index(path, "?"); i >= 0 {
+		path = path[:i]
+	}
+	return path
+}
+
 func NewGormRepo(dsn string) GormRepo {
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect to db")
 	}
-	if migrateErr := db.AutoMigrate(&Document{}); migrateErr != nil {
+	if migrateErr := db.AutoMigrate(&Document{}, &Tag{}, &Collection{}, &Annotation{}, &DocumentContent{}, &StoredFile{}, &ApiKey{}, &Session{}, &User{}, &SearchSettings{}); migrateErr != nil {
 		panic("failed to run migrations")
 	}
+	if backfillErr := backfillDomains(db); backfillErr != nil {
+		panic("failed to backfill document domains")
+	}
+	if shareErr := shareUnowned(db); shareErr != nil {
+		panic("failed to share documents without an owner")
+	}
 	return GormRepo{
 		db: db,
 	}
 }
+
+// backfillDomains sets the domain of documents saved before documents had
+// one
+func backfillDomains(db *gorm.DB) error {
+	var docs []Document
+	return db.Select("id", "url").
+		Where("domain = '' OR domain IS NULL").
+		FindInBatches(&docs, batchSize, func(tx *gorm.DB, _ int) error {
+			for _, doc := range docs {
+				docDomain := domain.DomainOf(doc.URL)
+				if docDomain == "" {
+					continue
+				}
+				if err := tx.Model(&Document{}).Where("id = ?", doc.ID).
+					UpdateColumn("domain", docDomain).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
+
+// shareUnowned puts documents saved before there were users in the team
+// collection
+func shareUnowned(db *gorm.DB) error {
+	return db.Model(&Document{}).
+		Where("(owner = '' OR owner IS NULL) AND (shared IS NULL OR NOT shared)").
+		UpdateColumn("shared", true).Error
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"github.com/stretchr/testify/suite"
 	"path/filepath"
 	"testing"
@@ -78,6 +79,403 @@ func (s *SqliteTestSuite) TestRepoActions() {
 	s.Require().NoError(s.repo.Delete(ctx, domain.ScrapedDoc{ID: temp}), "cannot fail deleting")
 }
 
+func (s *SqliteTestSuite) TestTags() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	s.repo = NewGormRepo(dsn)
+	ctx := context.Background()
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	testDoc := domain.ScrapedDoc{
+		ID:        "tagged",
+		URL:       "tagged.example",
+		Tags:      []string{"go", "databases"},
+		CreatedAt: domain.Timestamp(created),
+	}
+	s.Require().NoError(s.repo.Save(ctx, testDoc), "cannot fail saving")
+
+	result, getErr := s.repo.Get(ctx, testDoc)
+	s.Require().NoError(getErr, "no error getting document")
+	s.Assert().ElementsMatch([]string{"go", "databases"}, result.Tags)
+	s.Assert().True(created.Equal(time.Time(result.CreatedAt)), "expected creation time to be kept")
+
+	// test replacing tags keeps the creation time
+	testDoc.Tags = []string{"go"}
+	testDoc.CreatedAt = domain.Timestamp{}
+	s.Require().NoError(s.repo.Save(ctx, testDoc), "cannot fail saving")
+	result, getErr = s.repo.Get(ctx, testDoc)
+	s.Require().NoError(getErr, "no error getting document")
+	s.Assert().Equal([]string{"go"}, result.Tags)
+	s.Assert().True(created.Equal(time.Time(result.CreatedAt)), "expected creation time to be kept")
+
+	// test clearing tags
+	testDoc.Tags = nil
+	s.Require().NoError(s.repo.Save(ctx, testDoc), "cannot fail saving")
+	result, getErr = s.repo.Get(ctx, testDoc)
+	s.Require().NoError(getErr, "no error getting document")
+	s.Assert().Empty(result.Tags)
+
+	s.Require().NoError(s.repo.Delete(ctx, testDoc), "cannot fail deleting")
+}
+
+func (s *SqliteTestSuite) TestCollections() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Owner: "ada", Collections: []string{"Reading list"}}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "b", URL: "b.example", Owner: "bob", Collections: []string{"reading LIST"}}))
+
+	// each owner has their own collections
+	a, err := repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{"Reading list"}, a.Collections)
+	b, err := repo.Get(ctx, domain.ScrapedDoc{ID: "b"})
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{"reading LIST"}, b.Collections)
+
+	// names differing in case are the same collection, shown as last named
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "c", URL: "c.example", Owner: "ada", Collections: []string{"Reading List"}}))
+	a, err = repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{"Reading List"}, a.Collections)
+	var count int64
+	repo.db.Model(&Collection{}).Where("owner = ?", "ada").Count(&count)
+	s.Assert().Equal(int64(1), count)
+
+	// test clearing collections
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Owner: "ada"}))
+	a, err = repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Assert().Empty(a.Collections)
+}
+
+func (s *SqliteTestSuite) TestContent() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	s.repo = NewGormRepo(dsn)
+	repo := s.repo.(GormRepo)
+	ctx := context.Background()
+	for i := 0; i < batchSize+1; i++ {
+		doc := domain.ScrapedDoc{ID: fmt.Sprintf("doc-%03d", i), URL: "content.example"}
+		if i%2 == 0 {
+			doc.Content = fmt.Sprintf("content %d", i)
+		}
+		s.Require().NoError(s.repo.Save(ctx, doc), "cannot fail saving")
+	}
+
+	// test saving without content keeps the stored content
+	s.Require().NoError(s.repo.Save(ctx, domain.ScrapedDoc{ID: "doc-000", Title: "updated"}), "cannot fail saving")
+	content, getErr := repo.GetContent(ctx, "doc-000")
+	s.Require().NoError(getErr, "no error getting content")
+	s.Assert().Equal("content 0", content)
+
+	count := 0
+	s.Require().NoError(repo.Each(ctx, true, func(doc domain.ScrapedDoc) error {
+		if count%2 == 0 {
+			s.Assert().Equal(fmt.Sprintf("content %d", count), doc.Content)
+		} else {
+			s.Assert().Empty(doc.Content)
+		}
+		count++
+		return nil
+	}))
+	s.Assert().Equal(batchSize+1, count)
+
+	s.Require().NoError(repo.Each(ctx, false, func(doc domain.ScrapedDoc) error {
+		s.Assert().Empty(doc.Content)
+		return nil
+	}))
+
+	// test deleting content keeps the document
+	s.Require().NoError(s.repo.DeleteContent(ctx, "doc-002"), "cannot fail deleting content")
+	content, getErr = repo.GetContent(ctx, "doc-002")
+	s.Require().NoError(getErr, "no error getting content")
+	s.Assert().Empty(content)
+	_, getErr = s.repo.Get(ctx, domain.ScrapedDoc{ID: "doc-002"})
+	s.Assert().NoError(getErr)
+
+	// test deleting removes content
+	s.Require().NoError(s.repo.Delete(ctx, domain.ScrapedDoc{ID: "doc-000"}), "cannot fail deleting")
+	content, getErr = repo.GetContent(ctx, "doc-000")
+	s.Require().NoError(getErr, "no error getting content")
+	s.Assert().Empty(content)
+}
+
+func (s *SqliteTestSuite) TestApiKeys() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	key := domain.ApiKey{ID: "key1", Name: "reader", Scope: domain.ReadScope, CreatedAt: domain.Timestamp(time.Now())}
+	s.Require().NoError(repo.SaveApiKey(ctx, key, "hash1"), "cannot fail saving key")
+	s.Require().Error(repo.SaveApiKey(ctx, domain.ApiKey{ID: "key2"}, "hash1"), "hashes are unique")
+
+	result, getErr := repo.GetApiKeyByHash(ctx, "hash1")
+	s.Require().NoError(getErr, "no error getting key")
+	s.Assert().Equal("reader", result.Name)
+	s.Assert().Equal(domain.ReadScope, result.Scope)
+	_, getErr = repo.GetApiKeyByHash(ctx, "missing")
+	s.Assert().ErrorIs(getErr, NotFound)
+
+	count, countErr := repo.CountApiKeys(ctx)
+	s.Require().NoError(countErr)
+	s.Assert().Equal(int64(1), count)
+
+	// test expired sessions aren't returned
+	s.Require().NoError(repo.SaveSession(ctx, "session1", domain.Session{
+		KeyID: "key1", Scope: domain.ReadScope, ExpiresAt: domain.Timestamp(time.Now().Add(time.Hour)),
+	}))
+	s.Require().NoError(repo.SaveSession(ctx, "expired", domain.Session{
+		KeyID: "key1", Scope: domain.ReadScope, ExpiresAt: domain.Timestamp(time.Now().Add(-time.Hour)),
+	}))
+	session, sessionErr := repo.GetSession(ctx, "session1")
+	s.Require().NoError(sessionErr, "no error getting session")
+	s.Assert().Equal("key1", session.KeyID)
+	_, sessionErr = repo.GetSession(ctx, "expired")
+	s.Assert().ErrorIs(sessionErr, NotFound)
+
+	// test revoking a key ends its sessions
+	s.Require().NoError(repo.DeleteApiKey(ctx, "key1"), "cannot fail deleting key")
+	_, sessionErr = repo.GetSession(ctx, "session1")
+	s.Assert().ErrorIs(sessionErr, NotFound)
+	s.Assert().ErrorIs(repo.DeleteApiKey(ctx, "key1"), NotFound)
+}
+
+func (s *SqliteTestSuite) TestUsers() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	user := domain.User{ID: "user1", Name: "ada", CreatedAt: domain.Timestamp(time.Now())}
+	s.Require().NoError(repo.SaveUser(ctx, user, "hash1"), "cannot fail saving user")
+	s.Require().Error(repo.SaveUser(ctx, domain.User{ID: "user2", Name: "ada"}, "hash2"), "names are unique")
+
+	result, hash, getErr := repo.GetUserByName(ctx, "ada")
+	s.Require().NoError(getErr, "no error getting user")
+	s.Assert().Equal("user1", result.ID)
+	s.Assert().Equal("hash1", hash)
+	_, _, getErr = repo.GetUserByName(ctx, "bob")
+	s.Assert().ErrorIs(getErr, NotFound)
+
+	// test changing the password ends the user's sessions
+	s.Require().NoError(repo.SaveSession(ctx, "session1", domain.Session{
+		UserID: "user1", Scope: domain.WriteScope, ExpiresAt: domain.Timestamp(time.Now().Add(time.Hour)),
+	}))
+	s.Require().NoError(repo.SetPassword(ctx, "user1", "hash3"))
+	_, hash, _ = repo.GetUserByName(ctx, "ada")
+	s.Assert().Equal("hash3", hash)
+	_, sessionErr := repo.GetSession(ctx, "session1")
+	s.Assert().ErrorIs(sessionErr, NotFound)
+
+	// test deleting a user revokes their keys and keeps their documents
+	s.Require().NoError(repo.SaveApiKey(ctx, domain.ApiKey{ID: "key1", UserID: "user1"}, "keyhash"))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "doc1", URL: "owned.example", Owner: "user1"}))
+	s.Require().NoError(repo.DeleteUser(ctx, "user1"), "cannot fail deleting user")
+	_, keyErr := repo.GetApiKeyByHash(ctx, "keyhash")
+	s.Assert().ErrorIs(keyErr, NotFound)
+	doc, docErr := repo.Get(ctx, domain.ScrapedDoc{ID: "doc1"})
+	s.Require().NoError(docErr, "no error getting document")
+	s.Assert().Equal("user1", doc.Owner)
+	s.Assert().False(doc.Shared)
+	s.Assert().ErrorIs(repo.DeleteUser(ctx, "user1"), NotFound)
+}
+
+func (s *SqliteTestSuite) TestDeleteBatch() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{
+			ID: id, URL: id + ".example", Content: "text", Tags: []string{"go"}, Collections: []string{"Work"},
+		}))
+	}
+
+	s.Require().NoError(repo.DeleteBatch(ctx, []string{"a", "c"}), "cannot fail deleting documents")
+	docs, err := repo.GetAll(ctx)
+	s.Require().NoError(err, "no error getting documents")
+	s.Require().Len(docs, 1)
+	s.Assert().Equal("b", docs[0].ID)
+	s.Assert().Equal([]string{"go"}, docs[0].Tags)
+	content, contentErr := repo.GetContent(ctx, "a")
+	s.Require().NoError(contentErr, "no error getting content")
+	s.Assert().Empty(content, "content of deleted documents should be gone")
+	var links int64
+	repo.DB().Table("document_tags").Where("document_id IN ?", []string{"a", "c"}).Count(&links)
+	s.Assert().Zero(links, "tags of deleted documents should be unlinked")
+}
+
+func (s *SqliteTestSuite) TestTrash() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Owner: "ada", Tags: []string{"go"}}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "b", URL: "b.example", Owner: "bob"}))
+
+	s.Require().NoError(repo.Trash(ctx, []string{"a", "b"}), "cannot fail trashing documents")
+	_, getErr := repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Assert().Error(getErr, "documents in the trash should not be found")
+	docs, err := repo.GetAll(ctx)
+	s.Require().NoError(err)
+	s.Assert().Empty(docs)
+	trash, err := repo.ListTrash(ctx, "ada")
+	s.Require().NoError(err)
+	s.Require().Len(trash, 1)
+	s.Assert().Equal("a", trash[0].ID)
+	s.Assert().NotNil(trash[0].DeletedAt)
+	s.Assert().Equal([]string{"go"}, trash[0].Tags)
+	trashed, err := repo.GetTrashed(ctx, "b")
+	s.Require().NoError(err)
+	s.Assert().Equal("bob", trashed.Owner)
+
+	restored, err := repo.Untrash(ctx, "a")
+	s.Require().NoError(err, "cannot fail restoring document")
+	s.Assert().Nil(restored.DeletedAt)
+	_, err = repo.Untrash(ctx, "a")
+	s.Assert().ErrorIs(err, NotFound)
+	_, err = repo.GetTrashed(ctx, "a")
+	s.Assert().ErrorIs(err, NotFound)
+
+	// saving a document again takes it out of the trash
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "b", URL: "b.example", Owner: "bob"}))
+	_, getErr = repo.Get(ctx, domain.ScrapedDoc{ID: "b"})
+	s.Assert().NoError(getErr)
+
+	s.Require().NoError(repo.Trash(ctx, []string{"b"}))
+	ids, err := repo.TrashedBefore(ctx, time.Now().Add(time.Minute))
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{"b"}, ids)
+	ids, err = repo.TrashedBefore(ctx, time.Now().Add(-time.Minute))
+	s.Require().NoError(err)
+	s.Assert().Empty(ids)
+	s.Require().NoError(repo.DeleteBatch(ctx, []string{"b"}))
+	_, err = repo.GetTrashed(ctx, "b")
+	s.Assert().ErrorIs(err, NotFound)
+}
+
+func (s *SqliteTestSuite) TestAnnotations() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	created := domain.Timestamp(time.Unix(1700000000, 0))
+	note := domain.Annotation{ID: "n1", Comment: "worth a second read", Author: "ada", CreatedAt: created}
+	highlight := domain.Annotation{ID: "n2", Quote: "the key passage", Comment: "agreed", Author: "bob", CreatedAt: created}
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Annotations: []domain.Annotation{note, highlight}}))
+
+	doc, err := repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Require().Len(doc.Annotations, 2)
+	s.Assert().Equal("the key passage", doc.Annotations[1].Quote)
+	s.Assert().Equal("bob", doc.Annotations[1].Author)
+
+	// saving without annotations keeps them
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Title: "A"}))
+	doc, err = repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Assert().Len(doc.Annotations, 2)
+
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Annotations: []domain.Annotation{highlight}}))
+	doc, err = repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Require().Len(doc.Annotations, 1)
+	s.Assert().Equal("n2", doc.Annotations[0].ID)
+
+	s.Require().NoError(repo.DeleteBatch(ctx, []string{"a"}))
+	var count int64
+	s.Require().NoError(repo.DB().Model(&Annotation{}).Count(&count).Error)
+	s.Assert().Zero(count, "annotations should be deleted with their document")
+}
+
+func (s *SqliteTestSuite) TestReadState() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	readAt := domain.Timestamp(time.Unix(1700000000, 0))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{
+		ID: "a", URL: "a.example", Status: domain.Read, ReadAt: &readAt, Progress: 0.5, Favourite: true,
+	}))
+
+	doc, err := repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Assert().Equal(domain.Read, doc.Status)
+	s.Require().NotNil(doc.ReadAt)
+	s.Assert().Equal(readAt.String(), doc.ReadAt.String())
+	s.Assert().Equal(0.5, doc.Progress)
+	s.Assert().True(doc.Favourite)
+
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "a.example", Status: domain.Unread}))
+	doc, err = repo.Get(ctx, domain.ScrapedDoc{ID: "a"})
+	s.Require().NoError(err)
+	s.Assert().Nil(doc.ReadAt)
+	s.Assert().False(doc.Favourite)
+}
+
+func (s *SqliteTestSuite) TestSearchSettings() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	settings, err := repo.GetSearchSettings(ctx)
+	s.Require().NoError(err)
+	s.Assert().Equal(domain.DefaultSearchSettings(), settings)
+
+	settings.Synonyms = [][]string{{"k8s", "kubernetes"}}
+	settings.StopWords = []string{"the"}
+	settings.TypoTolerance.Enabled = false
+	s.Require().NoError(repo.SaveSearchSettings(ctx, settings))
+	settings.StopWords = []string{"the", "a"}
+	s.Require().NoError(repo.SaveSearchSettings(ctx, settings))
+
+	// reopening keeps the settings, so a new index gets them
+	repo = NewGormRepo(dsn)
+	saved, err := repo.GetSearchSettings(ctx)
+	s.Require().NoError(err)
+	s.Assert().Equal(settings, saved)
+}
+
+func (s *SqliteTestSuite) TestFindByURL() {
+	repo := NewGormRepo(filepath.Join(s.T().TempDir(), "test.db"))
+	ctx := context.Background()
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "/zeno/files/x", Owner: "ada"}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "b", URL: "/zeno/files/x", Owner: "bob"}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "c", URL: "/zeno/files/y", Owner: "bob"}))
+
+	docs, err := repo.FindByURL(ctx, "/zeno/files/x")
+	s.Require().NoError(err)
+	s.Assert().Len(docs, 2)
+	docs, err = repo.FindByURL(ctx, "/zeno/files/z")
+	s.Require().NoError(err)
+	s.Assert().Empty(docs)
+}
+
+func (s *SqliteTestSuite) TestFindByURLPrefix() {
+	repo := NewGormRepo(filepath.Join(s.T().TempDir(), "test.db"))
+	ctx := context.Background()
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "a", URL: "file:///notes/a.md"}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "b", URL: "file:///notes/sub/b.md"}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "c", URL: "file:///Notes/c.md"}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "d", URL: "file:///notes-old/d.md"}))
+
+	docs, err := repo.FindByURLPrefix(ctx, "file:///notes/")
+	s.Require().NoError(err)
+	var ids []string
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	s.Assert().ElementsMatch([]string{"a", "b"}, ids)
+	docs, err = repo.FindByURLPrefix(ctx, "file:///notes/%")
+	s.Require().NoError(err)
+	s.Assert().Empty(docs)
+}
+
+func (s *SqliteTestSuite) TestShareUnowned() {
+	dsn := filepath.Join(s.T().TempDir(), "test.db")
+	repo := NewGormRepo(dsn)
+	ctx := context.Background()
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "team", URL: "team.example"}))
+	s.Require().NoError(repo.Save(ctx, domain.ScrapedDoc{ID: "owned", URL: "owned.example", Owner: "user1"}))
+
+	// reopening shares documents saved before there were users
+	repo = NewGormRepo(dsn)
+	team, _ := repo.Get(ctx, domain.ScrapedDoc{ID: "team"})
+	s.Assert().True(team.Shared)
+	owned, _ := repo.Get(ctx, domain.ScrapedDoc{ID: "owned"})
+	s.Assert().False(owned.Shared)
+}
+
 func TestExampleTestSuite(t *testing.T) {
 	suite.Run(t, new(SqliteTestSuite))
 }
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Annotation is a note attached to a document. Highlights quote a passage
+// of the document and may comment on it, notes only have a comment.
+type Annotation struct {
+	ID        string    `json:"id"`
+	Quote     string    `json:"quote,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	Author    string    `json:"author,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+// NewAnnotation returns an annotation by author with a new ID. Quote and
+// comment are trimmed and at least one of them is needed.
+func NewAnnotation(author, quote, comment string) (Annotation, error) {
+	quote = strings.TrimSpace(quote)
+	comment = strings.TrimSpace(comment)
+	if quote == "" && comment == "" {
+		return Annotation{}, errors.New("an annotation needs a quote or a comment")
+	}
+	id, err := NewAnnotationID()
+	if err != nil {
+		return Annotation{}, err
+	}
+	return Annotation{
+		ID:        id,
+		Quote:     quote,
+		Comment:   comment,
+		Author:    author,
+		CreatedAt: Timestamp(time.Now()),
+	}, nil
+}
+
+// NewAnnotationID returns a random ID for an annotation
+func NewAnnotationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate annotation id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AnnotationText joins the quotes and comments of the document's
+// annotations, for search backends that index them with the content
+func (s ScrapedDoc) AnnotationText() string {
+	parts := make([]string, 0, 2*len(s.Annotations))
+	for _, a := range s.Annotations {
+		if a.Quote != "" {
+			parts = append(parts, a.Quote)
+		}
+		if a.Comment != "" {
+			parts = append(parts, a.Comment)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
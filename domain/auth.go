@@ -0,0 +1,55 @@
+package domain
+
+import "fmt"
+
+// Scope is what a credential is allowed to do
+type Scope string
+
+const (
+	// ReadScope allows searching and reading documents
+	ReadScope Scope = "read"
+	// WriteScope allows everything, including adding and deleting documents
+	WriteScope Scope = "write"
+)
+
+// Allows reports whether a credential with scope s may be used where
+// required is needed
+func (s Scope) Allows(required Scope) bool {
+	return s == WriteScope || (s == ReadScope && required == ReadScope)
+}
+
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case ReadScope, WriteScope:
+		return Scope(s), nil
+	}
+	return "", fmt.Errorf("invalid scope %q, expected %s or %s", s, ReadScope, WriteScope)
+}
+
+// ApiKey describes a key clients authenticate with. The key itself is
+// only shown when it is created. Keys of a user act as that user, keys
+// without one administer the whole instance.
+type ApiKey struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scope     Scope     `json:"scope"`
+	UserID    string    `json:"user_id,omitempty"`
+	CreatedAt Timestamp `json:"created_at"`
+}
+
+// Session is a login from the UI. KeyID is the key that was used to log
+// in, if any, and UserID the user logged in as.
+type Session struct {
+	KeyID     string    `json:"key_id,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Scope     Scope     `json:"scope"`
+	ExpiresAt Timestamp `json:"expires_at"`
+}
+
+// User has a library of documents of their own. The password hash is
+// kept by the db.
+type User struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt Timestamp `json:"created_at"`
+}
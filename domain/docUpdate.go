@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// DocUpdate changes the metadata of a saved document. Fields left nil are
+// kept as they are, empty lists clear tags and collections.
+type DocUpdate struct {
+	Title       *string     `json:"title,omitempty"`
+	Description *string     `json:"description,omitempty"`
+	Tags        *[]string   `json:"tags,omitempty"`
+	Collections *[]string   `json:"collections,omitempty"`
+	Scrape      *bool       `json:"scraped,omitempty"`
+	Shared      *bool       `json:"shared,omitempty"`
+	Status      *ReadStatus `json:"status,omitempty"`
+	Favourite   *bool       `json:"favourite,omitempty"`
+	Progress    *float64    `json:"progress,omitempty"`
+	// Annotations replace the document's annotations. They are changed
+	// through their own endpoints, so they are not read from JSON.
+	Annotations *[]Annotation `json:"-"`
+}
+
+// Empty reports whether the update changes nothing
+func (u DocUpdate) Empty() bool {
+	return u.Title == nil && u.Description == nil && u.Tags == nil && u.Collections == nil && u.Scrape == nil &&
+		u.Shared == nil && u.Status == nil && u.Favourite == nil && u.Progress == nil && u.Annotations == nil
+}
+
+// Validate checks the status and progress the update sets
+func (u DocUpdate) Validate() error {
+	if u.Status != nil {
+		if _, err := ParseReadStatus(string(*u.Status)); err != nil {
+			return err
+		}
+	}
+	if u.Progress != nil && (*u.Progress < 0 || *u.Progress > 1) {
+		return fmt.Errorf("invalid progress %v, expected a number from 0 to 1", *u.Progress)
+	}
+	return nil
+}
+
+// Apply returns the document with the update's fields changed
+func (u DocUpdate) Apply(doc ScrapedDoc) ScrapedDoc {
+	if u.Title != nil {
+		doc.Title = *u.Title
+	}
+	if u.Description != nil {
+		doc.Description = *u.Description
+	}
+	if u.Tags != nil {
+		doc.Tags = NormalizeTags(*u.Tags)
+	}
+	if u.Collections != nil {
+		doc.Collections = NormalizeCollections(*u.Collections)
+	}
+	if u.Scrape != nil {
+		doc.Scrape = *u.Scrape
+		if !doc.Scrape {
+			// documents that are not scraped have no content to match
+			doc.Content = ""
+			doc.Fingerprint = 0
+		}
+	}
+	if u.Shared != nil {
+		doc.Shared = *u.Shared
+	}
+	if u.Status != nil {
+		// reading a document again updates when it was read, marking it
+		// unread forgets it
+		if *u.Status == Read {
+			readAt := Timestamp(time.Now().Truncate(time.Second))
+			doc.ReadAt = &readAt
+		} else if *u.Status == Unread {
+			doc.ReadAt = nil
+		}
+		doc.Status = *u.Status
+	}
+	if u.Favourite != nil {
+		doc.Favourite = *u.Favourite
+	}
+	if u.Progress != nil {
+		doc.Progress = *u.Progress
+	}
+	if u.Annotations != nil {
+		doc.Annotations = *u.Annotations
+	}
+	return doc
+}
+
+// Fields returns the changed fields of the updated document by their
+// JSON names, for search servers that can update part of a document
+func (u DocUpdate) Fields(updated ScrapedDoc) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if u.Title != nil {
+		fields["title"] = updated.Title
+	}
+	if u.Description != nil {
+		fields["description"] = updated.Description
+	}
+	if u.Tags != nil {
+		fields["tags"] = updated.Tags
+	}
+	if u.Collections != nil {
+		fields["collections"] = updated.Collections
+	}
+	if u.Scrape != nil {
+		fields["scraped"] = updated.Scrape
+		if !updated.Scrape {
+			fields["content"] = updated.Content
+			fields["fingerprint"] = updated.Fingerprint
+		}
+	}
+	if u.Shared != nil {
+		fields["shared"] = updated.Shared
+	}
+	if u.Status != nil {
+		fields["status"] = updated.Status
+		fields["read_at"] = updated.ReadAt
+	}
+	if u.Favourite != nil {
+		fields["favourite"] = updated.Favourite
+	}
+	if u.Progress != nil {
+		fields["progress"] = updated.Progress
+	}
+	if u.Annotations != nil {
+		fields["annotations"] = updated.Annotations
+	}
+	return fields
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,13 +22,77 @@ func (t Timestamp) MarshalJSON() ([]byte, error) {
 	return json.Marshal(tt.Unix())
 }
 
+// UnmarshalJSON accepts unix timestamps in seconds, as written by
+// MarshalJSON, as well as RFC 3339 strings
+func (t *Timestamp) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*t = Timestamp{}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	parsed, err := ParseTimestamp(s)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s", b)
+	}
+	*t = parsed
+	return nil
+}
+
+// ParseTimestamp parses unix timestamps in seconds, RFC 3339 times and
+// dates in UTC
+func ParseTimestamp(s string) (Timestamp, error) {
+	if tt, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return Timestamp(tt), nil
+	}
+	if tt, err := time.Parse("2006-01-02", s); err == nil {
+		return Timestamp(tt), nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return Timestamp{}, fmt.Errorf("invalid timestamp %q, expected unix seconds, RFC 3339 or YYYY-MM-DD", s)
+	}
+	whole := math.Floor(secs)
+	return Timestamp(time.Unix(int64(whole), int64((secs-whole)*1e9))), nil
+}
+
 type DocType string
 
 const (
-	Html = "html"
-	Pdf  = "pdf"
+	Html     = "html"
+	Pdf      = "pdf"
+	Text     = "text"
+	Markdown = "markdown"
 )
 
+// ParseDocType checks s is one of the document types
+func ParseDocType(s string) (DocType, error) {
+	switch s {
+	case Html, Pdf, Text, Markdown:
+		return DocType(s), nil
+	}
+	return "", fmt.Errorf("invalid type %q, expected %s, %s, %s or %s", s, Html, Pdf, Text, Markdown)
+}
+
+type ReadStatus string
+
+const (
+	Unread   ReadStatus = "unread"
+	Read     ReadStatus = "read"
+	Archived ReadStatus = "archived"
+)
+
+// ParseReadStatus checks s is one of the read statuses
+func ParseReadStatus(s string) (ReadStatus, error) {
+	switch ReadStatus(s) {
+	case Unread, Read, Archived:
+		return ReadStatus(s), nil
+	}
+	return "", fmt.Errorf("invalid status %q, expected %s, %s or %s", s, Unread, Read, Archived)
+}
+
 type ScrapedDoc struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
@@ -35,6 +102,88 @@ type ScrapedDoc struct {
 	Scrape      bool      `json:"scraped"`
 	ParsedDate  Timestamp `json:"parsed_date"`
 	DocType     DocType   `json:"doc_type"`
+	Fingerprint SimHash   `json:"fingerprint"`
+	// DuplicateOf is the ID of the document this one duplicates. Documents
+	// marked as duplicates are kept in the db but hidden from search.
+	DuplicateOf string   `json:"duplicate_of,omitempty"`
+	Tags        []string `json:"tags"`
+	// Collections are named groups of documents, such as a project or a
+	// reading list
+	Collections []string   `json:"collections"`
+	CreatedAt   Timestamp  `json:"created_at"`
+	Status      ReadStatus `json:"status"`
+	// ReadAt is when the document was last marked as read
+	ReadAt *Timestamp `json:"read_at,omitempty"`
+	// Progress is how far the document has been read, from 0 to 1
+	Progress  float64 `json:"progress"`
+	Favourite bool    `json:"favourite"`
+	// Domain is the host of the URL without a leading www, for filtering
+	Domain string `json:"domain"`
+	// Owner is the ID of the user whose library the document is in.
+	// Documents without an owner belong to the team.
+	Owner string `json:"owner"`
+	// Shared documents are in the team collection everyone can search
+	Shared bool `json:"shared"`
+	// DeletedAt is when a document in the trash was deleted
+	DeletedAt *Timestamp `json:"deleted_at,omitempty"`
+	// Annotations are the notes and highlights readers attached to the
+	// document. A nil list leaves the saved annotations as they are.
+	Annotations []Annotation `json:"annotations,omitempty"`
+}
+
+// DomainOf returns the lower cased host of a URL without a leading www,
+// or an empty string for URLs without a host such as local files
+func DomainOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// VisibleTo reports whether the user with userID can read the document.
+// An empty userID is the instance admin, who can read every document.
+func (s ScrapedDoc) VisibleTo(userID string) bool {
+	return userID == "" || s.Owner == userID || s.Shared
+}
+
+// EditableBy reports whether the user with userID can change or delete
+// the document
+func (s ScrapedDoc) EditableBy(userID string) bool {
+	return userID == "" || s.Owner == userID
+}
+
+// NormalizeTags lower cases and trims tags, dropping empty and repeated ones
+func NormalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// NormalizeCollections trims collection names and collapses the spaces in
+// them, dropping empty names and names repeated in another case. Unlike
+// tags, collections keep the case they were named with.
+func NormalizeCollections(collections []string) []string {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(collections))
+	for _, collection := range collections {
+		collection = strings.Join(strings.Fields(collection), " ")
+		key := strings.ToLower(collection)
+		if collection == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, collection)
+	}
+	return normalized
 }
 
 func displayString(s string, l int) string {
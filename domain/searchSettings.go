@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SearchSettings tune how queries match documents
+type SearchSettings struct {
+	// Synonyms are groups of words and phrases that match each other, such
+	// as k8s and kubernetes
+	Synonyms [][]string `json:"synonyms"`
+	// StopWords are left out of queries
+	StopWords     []string      `json:"stop_words"`
+	TypoTolerance TypoTolerance `json:"typo_tolerance"`
+}
+
+// TypoTolerance is how words in queries match words spelt differently.
+// Words shorter than MinWordSizeForOneTypo must be spelt exactly, longer
+// ones may have one typo and words of MinWordSizeForTwoTypos letters two.
+type TypoTolerance struct {
+	Enabled                bool `json:"enabled"`
+	MinWordSizeForOneTypo  int  `json:"min_word_size_for_one_typo"`
+	MinWordSizeForTwoTypos int  `json:"min_word_size_for_two_typos"`
+	// DisableOnWords are words that must always be spelt exactly
+	DisableOnWords []string `json:"disable_on_words"`
+}
+
+// DefaultSearchSettings are the settings before any are saved, which are
+// the search server's defaults
+func DefaultSearchSettings() SearchSettings {
+	return SearchSettings{
+		Synonyms:  [][]string{},
+		StopWords: []string{},
+		TypoTolerance: TypoTolerance{
+			Enabled:                true,
+			MinWordSizeForOneTypo:  5,
+			MinWordSizeForTwoTypos: 9,
+			DisableOnWords:         []string{},
+		},
+	}
+}
+
+// Normalize lower cases and trims words, dropping empty and repeated ones
+// as well as synonym groups of fewer than two words. It returns an error
+// for typo word sizes that can't be used.
+func (s SearchSettings) Normalize() (SearchSettings, error) {
+	normalized := SearchSettings{
+		Synonyms:      make([][]string, 0, len(s.Synonyms)),
+		StopWords:     normalizeWords(s.StopWords),
+		TypoTolerance: s.TypoTolerance,
+	}
+	for _, group := range s.Synonyms {
+		if words := normalizeWords(group); len(words) > 1 {
+			normalized.Synonyms = append(normalized.Synonyms, words)
+		}
+	}
+	normalized.TypoTolerance.DisableOnWords = normalizeWords(s.TypoTolerance.DisableOnWords)
+	one, two := s.TypoTolerance.MinWordSizeForOneTypo, s.TypoTolerance.MinWordSizeForTwoTypos
+	if one < 1 || two < 1 {
+		return SearchSettings{}, errors.New("typo word sizes must be at least 1")
+	}
+	if one > two {
+		return SearchSettings{}, fmt.Errorf(
+			"the word size for one typo (%d) must not be more than the size for two typos (%d)", one, two,
+		)
+	}
+	return normalized, nil
+}
+
+// normalizeWords lower cases words and collapses the spaces in them,
+// dropping empty and repeated ones
+func normalizeWords(words []string) []string {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.ToLower(strings.Join(strings.Fields(word), " "))
+		if word == "" || seen[word] {
+			continue
+		}
+		seen[word] = true
+		normalized = append(normalized, word)
+	}
+	return normalized
+}
+
+// SynonymMap maps every word in a synonym group to the other words in its
+// groups
+func (s SearchSettings) SynonymMap() map[string][]string {
+	synonyms := make(map[string][]string)
+	for _, group := range s.Synonyms {
+		for _, word := range group {
+			for _, other := range group {
+				if other != word && !containsString(synonyms[word], other) {
+					synonyms[word] = append(synonyms[word], other)
+				}
+			}
+		}
+	}
+	return synonyms
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// shingleSize is the number of consecutive words hashed together
+// when fingerprinting content
+const shingleSize = 3
+
+// DuplicateDistance is how many bits the fingerprints of near duplicates
+// differ by at most. Pages that differ by a few words are 8 to 10 bits
+// apart, while unrelated pages are usually more than 25 apart.
+const DuplicateDistance = 10
+
+// SimHash is a 64 bit locality sensitive fingerprint of a document's
+// content. Documents with similar content have fingerprints that differ
+// in only a few bits.
+type SimHash uint64
+
+func (h SimHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+func (h SimHash) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+func (h *SimHash) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("simhash must be a hex string: %w", err)
+	}
+	if s == "" {
+		*h = 0
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid simhash %q: %w", s, err)
+	}
+	*h = SimHash(v)
+	return nil
+}
+
+// Distance returns the hamming distance between two fingerprints
+func (h SimHash) Distance(o SimHash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// SimHashOf computes the fingerprint of text from overlapping word
+// shingles. Empty text has a zero fingerprint.
+func SimHashOf(text string) SimHash {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	add := func(shingle string) {
+		hasher := fnv.New64a()
+		_, _ = hasher.Write([]byte(shingle))
+		sum := hasher.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	if len(words) < shingleSize {
+		add(strings.Join(words, " "))
+	}
+	for i := 0; i+shingleSize <= len(words); i++ {
+		add(strings.Join(words[i:i+shingleSize], " "))
+	}
+
+	var h uint64
+	for i, w := range weights {
+		if w > 0 {
+			h |= 1 << uint(i)
+		}
+	}
+	return SimHash(h)
+}
+
+// ClusterDuplicates groups documents whose fingerprints are within
+// maxDistance bits of each other. Documents without a fingerprint and
+// duplicates already hidden under another document are ignored, and only
+// clusters with more than one document are returned.
+func ClusterDuplicates(docs []ScrapedDoc, maxDistance int) [][]ScrapedDoc {
+	candidate := func(doc ScrapedDoc) bool {
+		return doc.Fingerprint != 0 && doc.DuplicateOf == ""
+	}
+	parent := make([]int, len(docs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range docs {
+		if !candidate(docs[i]) {
+			continue
+		}
+		for j := i + 1; j < len(docs); j++ {
+			if !candidate(docs[j]) {
+				continue
+			}
+			if docs[i].Fingerprint.Distance(docs[j].Fingerprint) <= maxDistance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]ScrapedDoc)
+	var roots []int
+	for i := range docs {
+		if !candidate(docs[i]) {
+			continue
+		}
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], docs[i])
+	}
+
+	var clusters [][]ScrapedDoc
+	for _, root := range roots {
+		if len(groups[root]) > 1 {
+			clusters = append(clusters, groups[root])
+		}
+	}
+	return clusters
+}
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+)
+
+const article = `The quick brown fox jumps over the lazy dog while the farmer
+watches from the porch and wonders why the dog never bothers to chase
+anything at all during the long summer afternoons in the valley`
+
+func TestSimHashDistance(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		maxDist int
+		minDist int
+	}{
+		{
+			name:    "identical",
+			a:       article,
+			b:       article,
+			maxDist: 0,
+		},
+		{
+			name:    "case and punctuation",
+			a:       article,
+			b:       strings.ToUpper(article) + "!!!",
+			maxDist: 0,
+		},
+		{
+			name:    "small edit",
+			a:       article,
+			b:       strings.Replace(article, "summer", "winter", 1),
+			maxDist: DuplicateDistance,
+		},
+		{
+			name:    "unrelated",
+			a:       article,
+			b:       "Kubernetes schedules containers onto nodes using the scheduler which considers resource requests and affinity rules",
+			maxDist: 64,
+			minDist: DuplicateDistance + 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := SimHashOf(tt.a).Distance(SimHashOf(tt.b))
+			if d > tt.maxDist || d < tt.minDist {
+				t.Errorf("Distance() = %d, want between %d and %d", d, tt.minDist, tt.maxDist)
+			}
+		})
+	}
+}
+
+func TestClusterDuplicates(t *testing.T) {
+	docs := []ScrapedDoc{
+		{ID: "a", Fingerprint: SimHashOf(article)},
+		{ID: "b", Fingerprint: SimHashOf("unrelated text about databases and indexes and query planners")},
+		{ID: "c", Fingerprint: SimHashOf(article + " print version")},
+		{ID: "d"},
+		{ID: "e"},
+		{ID: "f", Fingerprint: SimHashOf(article), DuplicateOf: "a"},
+	}
+	clusters := ClusterDuplicates(docs, DuplicateDistance)
+	if len(clusters) != 1 {
+		t.Fatalf("ClusterDuplicates() returned %d clusters, want 1", len(clusters))
+	}
+	if len(clusters[0]) != 2 || clusters[0][0].ID != "a" || clusters[0][1].ID != "c" {
+		t.Errorf("ClusterDuplicates() = %v, want a and c", clusters[0])
+	}
+}
+
+func TestSimHashJSON(t *testing.T) {
+	h := SimHash(1<<63 | 42)
+	b, err := h.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got SimHash
+	if err := got.UnmarshalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	if got != h {
+		t.Errorf("UnmarshalJSON() = %s, want %s", got, h)
+	}
+}
@@ -0,0 +1,12 @@
+package domain
+
+// StoredFile describes a file uploaded to zeno. Its ID is the sha256 hash
+// of the file's content, and documents made from it have the files path
+// followed by the hash as their URL.
+type StoredFile struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   Timestamp `json:"created_at"`
+}
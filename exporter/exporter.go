@@ -0,0 +1,226 @@
+package exporter
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"zeno/domain"
+)
+
+// Writer writes documents to an export one at a time. Close must be
+// called to finish the export.
+type Writer interface {
+	Write(doc domain.ScrapedDoc) error
+	Close() error
+}
+
+type DocIterator interface {
+	Each(ctx context.Context, withContent bool, fn func(domain.ScrapedDoc) error) error
+}
+
+// Export streams every document in docs to w
+func Export(ctx context.Context, w io.Writer, docs DocIterator, formatName string, withContent bool) error {
+	ew, err := NewWriter(formatName, w, withContent)
+	if err != nil {
+		return err
+	}
+	if eachErr := docs.Each(ctx, withContent, ew.Write); eachErr != nil {
+		return eachErr
+	}
+	return ew.Close()
+}
+
+type format struct {
+	contentType string
+	extension   string
+	newWriter   func(w io.Writer, withContent bool) (Writer, error)
+}
+
+var formats = map[string]format{
+	"jsonl": {
+		contentType: "application/x-ndjson",
+		extension:   "jsonl",
+		newWriter:   newJsonLinesWriter,
+	},
+	"csv": {
+		contentType: "text/csv",
+		extension:   "csv",
+		newWriter:   newCsvWriter,
+	},
+	"netscape": {
+		contentType: "text/html",
+		extension:   "html",
+		newWriter:   newNetscapeWriter,
+	},
+}
+
+// Formats lists the formats documents can be exported in
+func Formats() []string {
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewWriter returns a writer for the export format. Content is only
+// written when withContent is set.
+func NewWriter(formatName string, w io.Writer, withContent bool) (Writer, error) {
+	f, ok := formats[formatName]
+	if !ok {
+		return nil, fmt.Errorf("unknown export format %q, expected one of %v", formatName, Formats())
+	}
+	return f.newWriter(w, withContent)
+}
+
+// ContentType returns the mime type and file extension of an export format
+func ContentType(formatName string) (string, string, error) {
+	f, ok := formats[formatName]
+	if !ok {
+		return "", "", fmt.Errorf("unknown export format %q, expected one of %v", formatName, Formats())
+	}
+	return f.contentType, f.extension, nil
+}
+
+type jsonLinesWriter struct {
+	enc         *json.Encoder
+	withContent bool
+}
+
+// newJsonLinesWriter writes a document per line in the same format as
+// they are indexed, which can be imported back without loss
+func newJsonLinesWriter(w io.Writer, withContent bool) (Writer, error) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return jsonLinesWriter{enc: enc, withContent: withContent}, nil
+}
+
+func (j jsonLinesWriter) Write(doc domain.ScrapedDoc) error {
+	if !j.withContent {
+		doc.Content = ""
+	}
+	return j.enc.Encode(doc)
+}
+
+func (j jsonLinesWriter) Close() error {
+	return nil
+}
+
+var csvHeader = []string{
+	"id",
+	"url",
+	"title",
+	"description",
+	"tags",
+	"doc_type",
+	"status",
+	"scraped",
+	"created_at",
+	"parsed_date",
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	withContent bool
+}
+
+func newCsvWriter(w io.Writer, withContent bool) (Writer, error) {
+	cw := csv.NewWriter(w)
+	header := csvHeader
+	if withContent {
+		header = append(append([]string{}, csvHeader...), "content")
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return csvWriter{w: cw, withContent: withContent}, nil
+}
+
+func (c csvWriter) Write(doc domain.ScrapedDoc) error {
+	record := []string{
+		doc.ID,
+		doc.URL,
+		doc.Title,
+		doc.Description,
+		strings.Join(doc.Tags, ","),
+		string(doc.DocType),
+		string(doc.Status),
+		strconv.FormatBool(doc.Scrape),
+		formatTime(doc.CreatedAt),
+		formatTime(doc.ParsedDate),
+	}
+	if c.withContent {
+		record = append(record, doc.Content)
+	}
+	return c.w.Write(record)
+}
+
+func (c csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func formatTime(t domain.Timestamp) string {
+	if time.Time(t).IsZero() {
+		return ""
+	}
+	return time.Time(t).UTC().Format(time.RFC3339)
+}
+
+const netscapeHeader = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`
+
+type netscapeWriter struct {
+	w io.Writer
+}
+
+// newNetscapeWriter writes a bookmark file browsers can import. Tags are
+// written in the TAGS attribute used by Firefox and Pinboard, and content
+// is never included.
+func newNetscapeWriter(w io.Writer, _ bool) (Writer, error) {
+	if _, err := io.WriteString(w, netscapeHeader); err != nil {
+		return nil, err
+	}
+	return netscapeWriter{w: w}, nil
+}
+
+func (n netscapeWriter) Write(doc domain.ScrapedDoc) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `    <DT><A HREF="%s"`, html.EscapeString(doc.URL))
+	if created := time.Time(doc.CreatedAt); !created.IsZero() {
+		fmt.Fprintf(&sb, ` ADD_DATE="%d"`, created.Unix())
+	}
+	if len(doc.Tags) > 0 {
+		fmt.Fprintf(&sb, ` TAGS="%s"`, html.EscapeString(strings.Join(doc.Tags, ",")))
+	}
+	title := doc.Title
+	if title == "" {
+		title = doc.URL
+	}
+	fmt.Fprintf(&sb, ">%s</A>\n", html.EscapeString(title))
+	if doc.Description != "" {
+		fmt.Fprintf(&sb, "    <DD>%s\n", html.EscapeString(doc.Description))
+	}
+	_, err := io.WriteString(n.w, sb.String())
+	return err
+}
+
+func (n netscapeWriter) Close() error {
+	_, err := io.WriteString(n.w, "</DL><p>\n")
+	return err
+}
@@ -0,0 +1,117 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"zeno/domain"
+	"zeno/importer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type docList []domain.ScrapedDoc
+
+func (d docList) Each(_ context.Context, withContent bool, fn func(domain.ScrapedDoc) error) error {
+	for _, doc := range d {
+		if !withContent {
+			doc.Content = ""
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var testDocs = docList{
+	{
+		ID:          "aHR0cHM6Ly9hLmV4YW1wbGUv",
+		URL:         "https://a.example/",
+		Title:       `Quotes "and" <tags>`,
+		Description: "first, with a comma",
+		Content:     "some content",
+		Scrape:      true,
+		ParsedDate:  domain.Timestamp(time.Unix(1700000000, 0)),
+		CreatedAt:   domain.Timestamp(time.Unix(1600000000, 0)),
+		DocType:     domain.Html,
+		Fingerprint: domain.SimHashOf("some content"),
+		Tags:        []string{"go", "web"},
+		Status:      domain.Read,
+	},
+	{
+		ID:          "abc123",
+		URL:         "/zeno/files/abc123",
+		DocType:     domain.Pdf,
+		DuplicateOf: "aHR0cHM6Ly9hLmV4YW1wbGUv",
+		Tags:        []string{},
+		Status:      domain.Unread,
+	},
+}
+
+func TestJsonRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Export(context.Background(), &buf, testDocs, "jsonl", true))
+
+	docs, err := importer.ParseJsonLines(&buf)
+	require.NoError(t, err)
+	require.Len(t, docs, len(testDocs))
+	for i := range docs {
+		assert.True(t, time.Time(testDocs[i].ParsedDate).Equal(time.Time(docs[i].ParsedDate)))
+		assert.True(t, time.Time(testDocs[i].CreatedAt).Equal(time.Time(docs[i].CreatedAt)))
+		assert.Equal(t, testDocs[i].String(), docs[i].String())
+		assert.Equal(t, testDocs[i].Content, docs[i].Content)
+		assert.Equal(t, testDocs[i].Fingerprint, docs[i].Fingerprint)
+		assert.Equal(t, testDocs[i].DuplicateOf, docs[i].DuplicateOf)
+		assert.Equal(t, testDocs[i].Tags, docs[i].Tags)
+		assert.Equal(t, testDocs[i].Status, docs[i].Status)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	// formats both written and read are imported by the name they were
+	// exported with
+	for _, format := range []string{"jsonl", "netscape"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Export(context.Background(), &buf, testDocs, format, false))
+			parse, err := importer.ParserFor(format)
+			require.NoError(t, err)
+			docs, err := parse(&buf)
+			require.NoError(t, err)
+			require.Len(t, docs, len(testDocs))
+			for i := range docs {
+				assert.Equal(t, testDocs[i].URL, docs[i].URL)
+				assert.Equal(t, testDocs[i].Tags, docs[i].Tags)
+			}
+		})
+	}
+}
+
+func TestCsvExport(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Export(context.Background(), &buf, testDocs, "csv", false))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "id,url,title,description,tags,doc_type,status,scraped,created_at,parsed_date", lines[0])
+	assert.Equal(t,
+		`aHR0cHM6Ly9hLmV4YW1wbGUv,https://a.example/,"Quotes ""and"" <tags>","first, with a comma","go,web",html,read,true,2020-09-13T12:26:40Z,2023-11-14T22:13:20Z`,
+		lines[1])
+}
+
+func TestNetscapeRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Export(context.Background(), &buf, testDocs[:1], "netscape", true))
+
+	docs, err := importer.ParseNetscape(&buf)
+	require.NoError(t, err)
+	require.Len(t, docs, 1)
+	assert.Equal(t, testDocs[0].URL, docs[0].URL)
+	assert.Equal(t, testDocs[0].Title, docs[0].Title)
+	assert.Equal(t, testDocs[0].Description, docs[0].Description)
+	assert.Equal(t, testDocs[0].Tags, docs[0].Tags)
+	assert.True(t, time.Time(testDocs[0].CreatedAt).Equal(time.Time(docs[0].CreatedAt)))
+}
@@ -0,0 +1,88 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var InvalidHash = errors.New("invalid file hash")
+
+// Store keeps uploaded files on disk, addressed by the sha256 of their
+// content
+type Store struct {
+	dir string
+}
+
+func NewStore(dir string) Store {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		panic(fmt.Sprintf("failed to create file store %s: %s", dir, err))
+	}
+	return Store{
+		dir: dir,
+	}
+}
+
+// Dir returns the directory files are stored in
+func (s Store) Dir() string {
+	return s.dir
+}
+
+// Put writes the contents of r to the store and returns its hash.
+// Storing the same content twice is a no-op.
+func (s Store) Put(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create file: %w", err)
+	}
+	defer func() {
+		// no-op when the file has been renamed
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hasher := sha256.New()
+	if _, copyErr := io.Copy(io.MultiWriter(tmp, hasher), r); copyErr != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("could not write file: %w", copyErr)
+	}
+	if closeErr := tmp.Close(); closeErr != nil {
+		return "", fmt.Errorf("could not write file: %w", closeErr)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if renameErr := os.Rename(tmp.Name(), filepath.Join(s.dir, hash)); renameErr != nil {
+		return "", fmt.Errorf("could not store file: %w", renameErr)
+	}
+	return hash, nil
+}
+
+// Open opens the file with the given hash for reading
+func (s Store) Open(hash string) (*os.File, error) {
+	if !validHash(hash) {
+		return nil, InvalidHash
+	}
+	return os.Open(filepath.Join(s.dir, hash))
+}
+
+// Remove deletes the file with the given hash
+func (s Store) Remove(hash string) error {
+	if !validHash(hash) {
+		return InvalidHash
+	}
+	if err := os.Remove(filepath.Join(s.dir, hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove file %s: %w", hash, err)
+	}
+	return nil
+}
+
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
@@ -0,0 +1,44 @@
+package files
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	hash, err := s.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"; hash != want {
+		t.Errorf("Put() = %s, want %s", hash, want)
+	}
+	again, err := s.Put(strings.NewReader("hello"))
+	if err != nil || again != hash {
+		t.Errorf("Put() of same content = %s, %v, want %s", again, err, hash)
+	}
+
+	f, err := s.Open(hash)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	b, _ := io.ReadAll(f)
+	_ = f.Close()
+	if string(b) != "hello" {
+		t.Errorf("Open() read %q, want hello", b)
+	}
+
+	if _, err := s.Open("../etc/passwd"); err != InvalidHash {
+		t.Errorf("Open() with bad hash error = %v, want %v", err, InvalidHash)
+	}
+
+	if err := s.Remove(hash); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := s.Open(hash); err == nil {
+		t.Error("Open() after Remove() should fail")
+	}
+}
@@ -0,0 +1,202 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"zeno/domain"
+	"zeno/scraper"
+)
+
+type Parser func(r io.Reader) ([]domain.ScrapedDoc, error)
+
+// JsonFormat is the JSON Lines format written by the exporter. Documents
+// in it are restored as they were, and only scraped again when they were
+// exported without their content.
+const JsonFormat = "jsonl"
+
+// formatAliases are other names formats can be imported by. Exports used
+// to be imported as json.
+var formatAliases = map[string]string{
+	"json": JsonFormat,
+}
+
+var parsers = map[string]Parser{
+	JsonFormat:    ParseJsonLines,
+	"netscape":    ParseNetscape,
+	"pocket-html": ParsePocketHtml,
+	"pocket-csv":  ParsePocketCsv,
+	"pinboard":    ParsePinboard,
+	"raindrop":    ParseRaindrop,
+	"wallabag":    ParseWallabag,
+}
+
+// Formats lists the export formats that can be imported
+func Formats() []string {
+	formats := make([]string, 0, len(parsers))
+	for format := range parsers {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// ParserFor returns the parser for an export format
+func ParserFor(format string) (Parser, error) {
+	p, ok := parsers[canonicalFormat(format)]
+	if !ok {
+		return nil, fmt.Errorf("unknown import format %q, expected one of %v", format, Formats())
+	}
+	return p, nil
+}
+
+// canonicalFormat returns the name of the format an alias stands for
+func canonicalFormat(format string) string {
+	if name, ok := formatAliases[format]; ok {
+		return name
+	}
+	return format
+}
+
+type Failure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+// ParseJsonLines parses documents exported as JSON Lines
+func ParseJsonLines(r io.Reader) ([]domain.ScrapedDoc, error) {
+	var docs []domain.ScrapedDoc
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var doc domain.ScrapedDoc
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse document %d: %w", line, err)
+		}
+		docs = append(docs, doc)
+	}
+}
+
+type DocGetter interface {
+	Get(ctx context.Context, scrapedDoc domain.ScrapedDoc) (domain.ScrapedDoc, error)
+}
+
+// Summary describes what an import would do without changing anything
+type Summary struct {
+	Total       int                       `json:"total"`
+	New         int                       `json:"new"`
+	Existing    int                       `json:"existing"`
+	Unsupported []Failure                 `json:"unsupported"`
+	Statuses    map[domain.ReadStatus]int `json:"statuses"`
+	Tags        map[string]int            `json:"tags"`
+}
+
+// Summarize counts the documents an import would add or update
+func Summarize(ctx context.Context, db DocGetter, docs []domain.ScrapedDoc) Summary {
+	summary := Summary{
+		Total:       len(docs),
+		Unsupported: []Failure{},
+		Statuses:    make(map[domain.ReadStatus]int),
+		Tags:        make(map[string]int),
+	}
+	for _, doc := range docs {
+		// exported documents keep their id, their url is only checked
+		// when they are scraped again
+		id := doc.ID
+		if id == "" {
+			if err := checkUrl(doc.URL); err != nil {
+				summary.Unsupported = append(summary.Unsupported, Failure{URL: doc.URL, Error: err.Error()})
+				continue
+			}
+		}
+		var err error
+		if id == "" {
+			id, err = scraper.IdFor(doc.Owner, doc.URL)
+		}
+		if err == nil {
+			_, err = db.Get(ctx, domain.ScrapedDoc{ID: id})
+		}
+		if err == nil {
+			summary.Existing++
+		} else {
+			summary.New++
+		}
+		status := doc.Status
+		if status == "" {
+			status = domain.Unread
+		}
+		summary.Statuses[status]++
+		for _, tag := range doc.Tags {
+			summary.Tags[tag]++
+		}
+	}
+	return summary
+}
+
+func checkUrl(rawUrl string) error {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+type Result struct {
+	Queued int       `json:"queued"`
+	Failed []Failure `json:"failed"`
+}
+
+// Import adds parsed documents to zeno. Documents in JsonFormat are
+// restored, and documents from other formats are queued for scraping.
+func Import(s scraper.Scraper, format string, docs []domain.ScrapedDoc, scrape bool) Result {
+	if canonicalFormat(format) == JsonFormat {
+		return Restore(s, docs)
+	}
+	return Enqueue(s, docs, scrape)
+}
+
+// Restore saves and indexes exported documents, scraping only those
+// exported without their content
+func Restore(s scraper.Scraper, docs []domain.ScrapedDoc) Result {
+	result := Result{Failed: []Failure{}}
+	for _, doc := range docs {
+		if err := s.Restore(doc); err != nil {
+			log.Printf("could not restore %s: %s\n", doc.URL, err)
+			result.Failed = append(result.Failed, Failure{URL: doc.URL, Error: err.Error()})
+			continue
+		}
+		result.Queued++
+	}
+	return result
+}
+
+// Enqueue queues imported documents with the scraper. When scrape is
+// false only their metadata is saved, which is much faster for large
+// imports.
+func Enqueue(s scraper.Scraper, docs []domain.ScrapedDoc, scrape bool) Result {
+	result := Result{Failed: []Failure{}}
+	for _, doc := range docs {
+		err := checkUrl(doc.URL)
+		if err == nil {
+			doc.Scrape = scrape
+			err = s.Scrape(doc)
+		}
+		if err != nil {
+			log.Printf("could not import %s: %s\n", doc.URL, err)
+			result.Failed = append(result.Failed, Failure{URL: doc.URL, Error: err.Error()})
+			continue
+		}
+		result.Queued++
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"zeno/domain"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// browserRootAttrs mark the folders browsers create themselves, like the
+// bookmarks bar, which are not useful as tags
+var browserRootAttrs = []string{"personal_toolbar_folder", "unfiled_bookmarks_folder"}
+
+// ParseNetscape parses a bookmark file in the Netscape format exported by
+// Chrome, Firefox and Safari. The folders a bookmark is in become its tags.
+func ParseNetscape(r io.Reader) ([]domain.ScrapedDoc, error) {
+	z := html.NewTokenizer(r)
+
+	var docs []domain.ScrapedDoc
+	// folders holds the name of each open <DL>, empty for unnamed lists
+	var folders []string
+	var pendingFolder *string
+	var text strings.Builder
+	// inFolder, inLink and inDesc track which element text belongs to
+	var inFolder, inLink, inDesc, skipFolder bool
+
+	finishDesc := func() {
+		if inDesc && len(docs) > 0 {
+			docs[len(docs)-1].Description = strings.TrimSpace(text.String())
+		}
+		inDesc = false
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				finishDesc()
+				return mergeByUrl(docs), nil
+			}
+			return nil, fmt.Errorf("could not parse bookmarks: %w", z.Err())
+		case html.TextToken:
+			if inFolder || inLink || inDesc {
+				text.Write(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			attrs := tokenAttrs(z)
+			switch atom.Lookup(name) {
+			case atom.Dl:
+				finishDesc()
+				folder := ""
+				if pendingFolder != nil {
+					folder = *pendingFolder
+				}
+				folders = append(folders, folder)
+				pendingFolder = nil
+			case atom.Dt:
+				finishDesc()
+			case atom.H3:
+				finishDesc()
+				inFolder = true
+				skipFolder = false
+				for _, attr := range browserRootAttrs {
+					if _, ok := attrs[attr]; ok {
+						skipFolder = true
+					}
+				}
+				text.Reset()
+			case atom.A:
+				finishDesc()
+				inLink = true
+				text.Reset()
+				doc := domain.ScrapedDoc{
+					URL:       attrs["href"],
+					CreatedAt: unixAttr(attrs["add_date"]),
+					Tags:      append(folderTags(folders), splitTags(attrs["tags"], ",")...),
+				}
+				docs = append(docs, doc)
+			case atom.Dd:
+				finishDesc()
+				inDesc = true
+				text.Reset()
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Dl:
+				finishDesc()
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case atom.H3:
+				if inFolder {
+					folder := ""
+					if !skipFolder {
+						folder = strings.TrimSpace(text.String())
+					}
+					pendingFolder = &folder
+				}
+				inFolder = false
+			case atom.A:
+				if inLink && len(docs) > 0 {
+					docs[len(docs)-1].Title = strings.TrimSpace(text.String())
+				}
+				inLink = false
+			}
+		}
+	}
+}
+
+func tokenAttrs(z *html.Tokenizer) map[string]string {
+	attrs := make(map[string]string)
+	for {
+		key, val, more := z.TagAttr()
+		if len(key) > 0 {
+			attrs[strings.ToLower(string(key))] = string(val)
+		}
+		if !more {
+			return attrs
+		}
+	}
+}
+
+func folderTags(folders []string) []string {
+	var tags []string
+	for _, folder := range folders {
+		if folder != "" {
+			tags = append(tags, folder)
+		}
+	}
+	return tags
+}
+
+func splitTags(s, sep string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, sep) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// unixAttr parses a timestamp in seconds, as used by ADD_DATE. Some
+// browsers write microseconds, which are detected by their size.
+func unixAttr(s string) domain.Timestamp {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n <= 0 {
+		return domain.Timestamp{}
+	}
+	if n > 1e14 {
+		return domain.Timestamp(time.UnixMicro(n))
+	}
+	return domain.Timestamp(time.Unix(n, 0))
+}
+
+// mergeByUrl combines bookmarks of the same url, keeping the first one's
+// metadata and the tags of all of them
+func mergeByUrl(docs []domain.ScrapedDoc) []domain.ScrapedDoc {
+	index := make(map[string]int)
+	merged := make([]domain.ScrapedDoc, 0, len(docs))
+	for _, doc := range docs {
+		if doc.URL == "" {
+			continue
+		}
+		i, ok := index[doc.URL]
+		if !ok {
+			index[doc.URL] = len(merged)
+			doc.Tags = domain.NormalizeTags(doc.Tags)
+			merged = append(merged, doc)
+			continue
+		}
+		merged[i].Tags = domain.NormalizeTags(append(merged[i].Tags, doc.Tags...))
+		if merged[i].Description == "" {
+			merged[i].Description = doc.Description
+		}
+	}
+	return merged
+}
@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const chromeExport = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file. -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><H3 ADD_DATE="1600000000" PERSONAL_TOOLBAR_FOLDER="true">Bookmarks bar</H3>
+    <DL><p>
+        <DT><A HREF="https://go.dev/" ADD_DATE="1600000001">The Go Programming Language</A>
+        <DT><H3 ADD_DATE="1600000002">Databases</H3>
+        <DL><p>
+            <DT><A HREF="https://sqlite.org/fts5.html" ADD_DATE="1600000003" TAGS="search,sqlite">FTS5</A>
+            <DD>Full text search
+            <DT><H3>Postgres</H3>
+            <DL><p>
+                <DT><A HREF="https://www.postgresql.org/docs/">Docs</A>
+            </DL><p>
+        </DL><p>
+        <DT><A HREF="javascript:alert(1)">bookmarklet</A>
+    </DL><p>
+    <DT><H3>Reading</H3>
+    <DL><p>
+        <DT><A HREF="https://go.dev/" ADD_DATE="1700000000">Go again</A>
+    </DL><p>
+</DL><p>
+`
+
+func TestParseNetscape(t *testing.T) {
+	docs, err := ParseNetscape(strings.NewReader(chromeExport))
+	require.NoError(t, err)
+	require.Len(t, docs, 4)
+
+	goDoc := docs[0]
+	assert.Equal(t, "https://go.dev/", goDoc.URL)
+	assert.Equal(t, "The Go Programming Language", goDoc.Title)
+	assert.Equal(t, []string{"reading"}, goDoc.Tags, "duplicate bookmarks should merge tags")
+	assert.True(t, time.Unix(1600000001, 0).Equal(time.Time(goDoc.CreatedAt)))
+
+	fts := docs[1]
+	assert.Equal(t, "FTS5", fts.Title)
+	assert.Equal(t, "Full text search", fts.Description)
+	assert.Equal(t, []string{"databases", "search", "sqlite"}, fts.Tags)
+
+	assert.Equal(t, []string{"databases", "postgres"}, docs[2].Tags)
+	assert.True(t, time.Time(docs[2].CreatedAt).IsZero())
+
+	assert.Equal(t, "javascript:alert(1)", docs[3].URL)
+	assert.Empty(t, docs[3].Tags)
+}
@@ -0,0 +1,259 @@
+package importer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"zeno/domain"
+	"zeno/scraper"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// ParsePocketHtml parses the ril_export.html file from Pocket, where
+// bookmarks are listed under "Unread" and "Read Archive" headings
+func ParsePocketHtml(r io.Reader) ([]domain.ScrapedDoc, error) {
+	z := html.NewTokenizer(r)
+	var docs []domain.ScrapedDoc
+	var text strings.Builder
+	var inHeading, inLink bool
+	status := domain.Unread
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return mergeByUrl(docs), nil
+			}
+			return nil, fmt.Errorf("could not parse pocket export: %w", z.Err())
+		case html.TextToken:
+			if inHeading || inLink {
+				text.Write(z.Text())
+			}
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.H1:
+				inHeading = true
+				text.Reset()
+			case atom.A:
+				attrs := tokenAttrs(z)
+				inLink = true
+				text.Reset()
+				docs = append(docs, domain.ScrapedDoc{
+					URL:       attrs["href"],
+					CreatedAt: unixAttr(attrs["time_added"]),
+					Tags:      splitTags(attrs["tags"], ","),
+					Status:    status,
+				})
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.H1:
+				inHeading = false
+				if strings.Contains(strings.ToLower(text.String()), "archive") {
+					status = domain.Archived
+				} else {
+					status = domain.Unread
+				}
+			case atom.A:
+				if inLink && len(docs) > 0 {
+					docs[len(docs)-1].Title = strings.TrimSpace(text.String())
+				}
+				inLink = false
+			}
+		}
+	}
+}
+
+// ParsePocketCsv parses the part_*.csv files from Pocket's export
+func ParsePocketCsv(r io.Reader) ([]domain.ScrapedDoc, error) {
+	var docs []domain.ScrapedDoc
+	err := readCsv(r, func(row map[string]string) {
+		doc := domain.ScrapedDoc{
+			URL:       row["url"],
+			Title:     row["title"],
+			CreatedAt: unixAttr(row["time_added"]),
+			Tags:      splitTags(row["tags"], "|"),
+			Status:    domain.Unread,
+		}
+		if row["status"] == "archive" {
+			doc.Status = domain.Archived
+		}
+		docs = append(docs, doc)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse pocket export: %w", err)
+	}
+	return mergeByUrl(docs), nil
+}
+
+type pinboardPost struct {
+	Href        string `json:"href"`
+	Description string `json:"description"`
+	Extended    string `json:"extended"`
+	Time        string `json:"time"`
+	ToRead      string `json:"toread"`
+	Tags        string `json:"tags"`
+}
+
+// ParsePinboard parses the JSON export from Pinboard
+func ParsePinboard(r io.Reader) ([]domain.ScrapedDoc, error) {
+	var posts []pinboardPost
+	if err := json.NewDecoder(r).Decode(&posts); err != nil {
+		return nil, fmt.Errorf("could not parse pinboard export: %w", err)
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(posts))
+	for _, post := range posts {
+		doc := domain.ScrapedDoc{
+			URL:         post.Href,
+			Title:       post.Description,
+			Description: post.Extended,
+			CreatedAt:   parseTime(post.Time),
+			Tags:        strings.Fields(post.Tags),
+			Status:      domain.Read,
+		}
+		if post.ToRead == "yes" {
+			doc.Status = domain.Unread
+		}
+		docs = append(docs, doc)
+	}
+	return mergeByUrl(docs), nil
+}
+
+// ParseRaindrop parses the CSV export from Raindrop, using the folder
+// as a tag alongside the bookmark's own tags
+func ParseRaindrop(r io.Reader) ([]domain.ScrapedDoc, error) {
+	var docs []domain.ScrapedDoc
+	err := readCsv(r, func(row map[string]string) {
+		description := row["note"]
+		if description == "" {
+			description = row["excerpt"]
+		}
+		tags := splitTags(row["tags"], ",")
+		if folder := row["folder"]; folder != "" && folder != "Unsorted" {
+			tags = append([]string{folder}, tags...)
+		}
+		docs = append(docs, domain.ScrapedDoc{
+			URL:         row["url"],
+			Title:       row["title"],
+			Description: description,
+			CreatedAt:   parseTime(row["created"]),
+			Tags:        tags,
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not parse raindrop export: %w", err)
+	}
+	return mergeByUrl(docs), nil
+}
+
+// flexBool accepts both JSON booleans and the 0/1 integers older
+// wallabag versions export
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	v, err := strconv.ParseBool(strings.Trim(string(data), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	*b = flexBool(v)
+	return nil
+}
+
+type wallabagEntry struct {
+	Title      string   `json:"title"`
+	Url        string   `json:"url"`
+	Content    string   `json:"content"`
+	CreatedAt  string   `json:"created_at"`
+	IsArchived flexBool `json:"is_archived"`
+	Tags       []string `json:"tags"`
+}
+
+// ParseWallabag parses the JSON export from wallabag. The content saved
+// by wallabag is kept, so entries can be imported without scraping.
+func ParseWallabag(r io.Reader) ([]domain.ScrapedDoc, error) {
+	var entries []wallabagEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("could not parse wallabag export: %w", err)
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(entries))
+	for _, entry := range entries {
+		doc := domain.ScrapedDoc{
+			URL:       entry.Url,
+			Title:     entry.Title,
+			CreatedAt: parseTime(entry.CreatedAt),
+			Tags:      entry.Tags,
+			Status:    domain.Unread,
+		}
+		if entry.IsArchived {
+			doc.Status = domain.Archived
+		}
+		if entry.Content != "" {
+			content, err := scraper.ExtractText([]byte(entry.Content))
+			if err == nil {
+				doc.Content = content
+			}
+		}
+		docs = append(docs, doc)
+	}
+	return mergeByUrl(docs), nil
+}
+
+// readCsv calls fn with every row of a CSV file with a header, keyed by
+// the lower cased column names
+func readCsv(r io.Reader, fn func(row map[string]string)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return errors.New("empty file")
+	}
+	if err != nil {
+		return err
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff")))
+	}
+	for {
+		record, readErr := cr.Read()
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+		row := make(map[string]string, len(header))
+		for i, field := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(field)
+			}
+		}
+		fn(row)
+	}
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses the timestamp formats used by the exports, returning
+// a zero timestamp when none match
+func parseTime(s string) domain.Timestamp {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return domain.Timestamp(t)
+		}
+	}
+	return unixAttr(s)
+}
@@ -0,0 +1,135 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"zeno/domain"
+	"zeno/scraper"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServices(t *testing.T) {
+	tests := []struct {
+		format string
+		export string
+		want   []domain.ScrapedDoc
+	}{
+		{
+			format: "pocket-html",
+			export: `<!DOCTYPE html><html><body>
+<h1>Unread</h1>
+<ul><li><a href="https://a.example/" time_added="1600000000" tags="go,web">A</a></li></ul>
+<h1>Read Archive</h1>
+<ul><li><a href="https://b.example/" time_added="1600000001" tags="">B</a></li></ul>
+</body></html>`,
+			want: []domain.ScrapedDoc{
+				{URL: "https://a.example/", Title: "A", Tags: []string{"go", "web"}, Status: domain.Unread,
+					CreatedAt: domain.Timestamp(time.Unix(1600000000, 0))},
+				{URL: "https://b.example/", Title: "B", Tags: []string{}, Status: domain.Archived,
+					CreatedAt: domain.Timestamp(time.Unix(1600000001, 0))},
+			},
+		},
+		{
+			format: "pocket-csv",
+			export: "title,url,time_added,tags,status\n" +
+				"A,https://a.example/,1600000000,go|web,unread\n" +
+				"B,https://b.example/,1600000001,,archive\n",
+			want: []domain.ScrapedDoc{
+				{URL: "https://a.example/", Title: "A", Tags: []string{"go", "web"}, Status: domain.Unread,
+					CreatedAt: domain.Timestamp(time.Unix(1600000000, 0))},
+				{URL: "https://b.example/", Title: "B", Tags: []string{}, Status: domain.Archived,
+					CreatedAt: domain.Timestamp(time.Unix(1600000001, 0))},
+			},
+		},
+		{
+			format: "pinboard",
+			export: `[{"href":"https://a.example/","description":"A","extended":"about a",
+"time":"2020-09-13T12:26:40Z","toread":"yes","tags":"go web"},
+{"href":"https://b.example/","description":"B","extended":"","time":"2020-09-13T12:26:41Z","toread":"no","tags":""}]`,
+			want: []domain.ScrapedDoc{
+				{URL: "https://a.example/", Title: "A", Description: "about a", Tags: []string{"go", "web"},
+					Status: domain.Unread, CreatedAt: domain.Timestamp(time.Unix(1600000000, 0))},
+				{URL: "https://b.example/", Title: "B", Tags: []string{}, Status: domain.Read,
+					CreatedAt: domain.Timestamp(time.Unix(1600000001, 0))},
+			},
+		},
+		{
+			format: "raindrop",
+			export: "id,title,note,excerpt,url,folder,tags,created,cover,highlights,favorite\n" +
+				`1,A,my note,excerpt,https://a.example/,Programming,"go, web",2020-09-13T12:26:40.000Z,,,false` + "\n" +
+				`2,B,,the excerpt,https://b.example/,Unsorted,,2020-09-13T12:26:41.000Z,,,true` + "\n",
+			want: []domain.ScrapedDoc{
+				{URL: "https://a.example/", Title: "A", Description: "my note",
+					Tags: []string{"programming", "go", "web"}, CreatedAt: domain.Timestamp(time.Unix(1600000000, 0))},
+				{URL: "https://b.example/", Title: "B", Description: "the excerpt", Tags: []string{},
+					CreatedAt: domain.Timestamp(time.Unix(1600000001, 0))},
+			},
+		},
+		{
+			// exports were imported as json before the name matched the exporter's
+			format: "json",
+			export: `{"url":"https://a.example/","title":"A","tags":["go"],"created_at":1600000000,"status":"read"}` + "\n",
+			want: []domain.ScrapedDoc{
+				{URL: "https://a.example/", Title: "A", Tags: []string{"go"}, Status: domain.Read,
+					CreatedAt: domain.Timestamp(time.Unix(1600000000, 0))},
+			},
+		},
+		{
+			format: "wallabag",
+			export: `[{"title":"A","url":"https://a.example/","content":"<p>saved text</p>",
+"created_at":"2020-09-13T14:26:40+0200","is_archived":0,"tags":["go"]},
+{"title":"B","url":"https://b.example/","content":"","created_at":"2020-09-13T12:26:41+00:00","is_archived":true,"tags":[]}]`,
+			want: []domain.ScrapedDoc{
+				{URL: "https://a.example/", Title: "A", Content: "saved text ", Tags: []string{"go"},
+					Status: domain.Unread, CreatedAt: domain.Timestamp(time.Unix(1600000000, 0))},
+				{URL: "https://b.example/", Title: "B", Tags: []string{}, Status: domain.Archived,
+					CreatedAt: domain.Timestamp(time.Unix(1600000001, 0))},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			parse, err := ParserFor(tt.format)
+			require.NoError(t, err)
+			docs, err := parse(strings.NewReader(tt.export))
+			require.NoError(t, err)
+			require.Len(t, docs, len(tt.want))
+			for i := range docs {
+				assert.True(t, time.Time(tt.want[i].CreatedAt).Equal(time.Time(docs[i].CreatedAt)),
+					"created at %s, want %s", time.Time(docs[i].CreatedAt), time.Time(tt.want[i].CreatedAt))
+				docs[i].CreatedAt = tt.want[i].CreatedAt
+				assert.Equal(t, tt.want[i], docs[i])
+			}
+		})
+	}
+}
+
+type existingDocs map[string]bool
+
+func (e existingDocs) Get(_ context.Context, doc domain.ScrapedDoc) (domain.ScrapedDoc, error) {
+	if e[doc.ID] {
+		return doc, nil
+	}
+	return domain.ScrapedDoc{}, errors.New("not found")
+}
+
+func TestSummarize(t *testing.T) {
+	id, _ := scraper.IdFromUrl("https://a.example/")
+	docs := []domain.ScrapedDoc{
+		{URL: "https://a.example/", Tags: []string{"go"}, Status: domain.Archived},
+		{URL: "https://b.example/", Tags: []string{"go", "web"}},
+		{URL: "place:sort=8"},
+	}
+	summary := Summarize(context.Background(), existingDocs{id: true}, docs)
+	assert.Equal(t, 3, summary.Total)
+	assert.Equal(t, 1, summary.New)
+	assert.Equal(t, 1, summary.Existing)
+	assert.Len(t, summary.Unsupported, 1)
+	assert.Equal(t, map[domain.ReadStatus]int{domain.Archived: 1, domain.Unread: 1}, summary.Statuses)
+	assert.Equal(t, map[string]int{"go": 2, "web": 1}, summary.Tags)
+}
@@ -0,0 +1,383 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/highlight/format/html"
+	"github.com/blevesearch/bleve/v2/search/query"
+	stdhtml "html"
+	"log"
+	"strings"
+	"time"
+	"zeno/domain"
+)
+
+// field boosts, so title matches rank above description, url and content
+// matches
+const (
+	titleBoost       = 3
+	descriptionBoost = 2
+	urlBoost         = 1.5
+	contentBoost     = 1
+)
+
+// fragmentSeparator joins highlighted fragments of the same field
+const fragmentSeparator = " … "
+
+// BleveIndexer indexes documents in an embedded bleve index, so search
+// runs in process without a separate server
+type BleveIndexer struct {
+	index  bleve.Index
+	tuning *queryTuning
+}
+
+// NewBleveIndexer opens the index at path, creating it if it does not
+// exist. An empty path keeps the index in memory.
+func NewBleveIndexer(path string) (BleveIndexer, error) {
+	if path == "" {
+		index, err := bleve.NewMemOnly(bleveMapping())
+		if err != nil {
+			return BleveIndexer{}, fmt.Errorf("could not create search index: %w", err)
+		}
+		return BleveIndexer{index: index, tuning: newQueryTuning()}, nil
+	}
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		log.Println("creating search index at", path)
+		index, err = bleve.New(path, bleveMapping())
+	}
+	if err != nil {
+		return BleveIndexer{}, fmt.Errorf("could not open search index: %w", err)
+	}
+	return BleveIndexer{index: index, tuning: newQueryTuning()}, nil
+}
+
+// bleveMapping analyzes text fields as english and stores them for
+// highlighting. The document without its content is stored unindexed so
+// hits don't need another lookup.
+func bleveMapping() mapping.IndexMapping {
+	textField := func(analyzer string) *mapping.FieldMapping {
+		field := bleve.NewTextFieldMapping()
+		field.Analyzer = analyzer
+		field.Store = true
+		field.IncludeTermVectors = true
+		return field
+	}
+	keywordField := func() *mapping.FieldMapping {
+		field := bleve.NewTextFieldMapping()
+		field.Analyzer = keyword.Name
+		field.IncludeInAll = false
+		return field
+	}
+	storedField := bleve.NewTextFieldMapping()
+	storedField.Index = false
+	storedField.IncludeInAll = false
+	storedField.DocValues = false
+
+	docMapping := bleve.NewDocumentStaticMapping()
+	docMapping.AddFieldMappingsAt("title", textField(en.AnalyzerName))
+	docMapping.AddFieldMappingsAt("description", textField(en.AnalyzerName))
+	docMapping.AddFieldMappingsAt("content", textField(en.AnalyzerName))
+	docMapping.AddFieldMappingsAt("url", textField(standard.Name))
+	docMapping.AddFieldMappingsAt("parsed_date", bleve.NewDateTimeFieldMapping())
+	docMapping.AddFieldMappingsAt("created_at", bleve.NewDateTimeFieldMapping())
+	for _, field := range FacetFields {
+		docMapping.AddFieldMappingsAt(field, keywordField())
+	}
+	docMapping.AddFieldMappingsAt(OwnerField, keywordField())
+	sharedField := bleve.NewBooleanFieldMapping()
+	sharedField.IncludeInAll = false
+	docMapping.AddFieldMappingsAt(SharedField, sharedField)
+	for _, field := range []string{FavouriteField, ScrapedField} {
+		boolField := bleve.NewBooleanFieldMapping()
+		boolField.IncludeInAll = false
+		docMapping.AddFieldMappingsAt(field, boolField)
+	}
+	docMapping.AddFieldMappingsAt("doc", storedField)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = docMapping
+	return indexMapping
+}
+
+func (b BleveIndexer) Index(doc domain.ScrapedDoc) error {
+	content := searchableContent(doc)
+	doc.Content = ""
+	stored, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("could not index scraped doc: %w", err)
+	}
+	err = b.index.Index(doc.ID, map[string]interface{}{
+		"title":          doc.Title,
+		"description":    doc.Description,
+		"content":        content,
+		"url":            doc.URL,
+		"parsed_date":    time.Time(doc.ParsedDate),
+		"created_at":     time.Time(doc.CreatedAt),
+		DocTypeField:     string(doc.DocType),
+		DomainField:      doc.Domain,
+		TagsField:        doc.Tags,
+		CollectionsField: doc.Collections,
+		StatusField:      string(doc.Status),
+		OwnerField:       doc.Owner,
+		SharedField:      doc.Shared,
+		FavouriteField:   doc.Favourite,
+		ScrapedField:     doc.Scrape,
+		"doc":            string(stored),
+	})
+	if err != nil {
+		return fmt.Errorf("could not index scraped doc: %w", err)
+	}
+	log.Printf("indexed %s\n", doc.URL)
+	return nil
+}
+
+func (b BleveIndexer) Delete(doc domain.ScrapedDoc) error {
+	if err := b.index.Delete(doc.ID); err != nil {
+		return fmt.Errorf("could not delete scraped doc: %w", err)
+	}
+	log.Printf("deleted %s\n", doc.ID)
+	return nil
+}
+
+func (b BleveIndexer) DeleteBatch(ids []string) error {
+	batch := b.index.NewBatch()
+	for _, id := range ids {
+		batch.Delete(id)
+	}
+	if err := b.index.Batch(batch); err != nil {
+		return fmt.Errorf("could not delete scraped docs: %w", err)
+	}
+	log.Printf("deleted %d docs\n", len(ids))
+	return nil
+}
+
+// Close flushes and closes the index
+func (b BleveIndexer) Close() error {
+	return b.index.Close()
+}
+
+// ApplySettings uses the synonyms, stop words and typo tolerance in queries
+func (b BleveIndexer) ApplySettings(settings domain.SearchSettings) error {
+	b.tuning.set(settings)
+	return nil
+}
+
+func (b BleveIndexer) Search(ctx context.Context, req SearchRequest) (SearchResult, error) {
+	req, parsed, err := req.parse()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	words := queryWords(parsed.Text)
+	matching := len(words) > 0 || len(parsed.Phrases) > 0
+
+	var q query.Query = bleve.NewMatchAllQuery()
+	if matching {
+		q = b.query(words, parsed.Phrases)
+	}
+	if filters := bleveFilters(req.Filters); len(filters) > 0 {
+		q = bleve.NewConjunctionQuery(append([]query.Query{q}, filters...)...)
+	}
+	searchReq := bleve.NewSearchRequestOptions(q, req.Limit, req.Offset, false)
+	searchReq.Fields = []string{"doc"}
+	if matching {
+		searchReq.Highlight = bleve.NewHighlightWithStyle(html.Name)
+		searchReq.Highlight.Fields = []string{"title", "description", "content", "url"}
+	}
+	if field, desc := req.sortBy(); field != "" {
+		if desc {
+			field = "-" + field
+		}
+		searchReq.SortBy([]string{field, "-_score"})
+	} else if !matching {
+		// without terms, list the most recently scraped documents
+		searchReq.SortBy([]string{"-parsed_date"})
+	}
+	for _, facet := range req.Facets {
+		searchReq.AddFacet(facet, bleve.NewFacetRequest(facet, maxFacetValues))
+	}
+
+	resp, err := b.index.SearchInContext(ctx, searchReq)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("could not search: %w", err)
+	}
+
+	result := SearchResult{
+		Hits:   make([]Hit, 0, len(resp.Hits)),
+		Total:  int64(resp.Total),
+		Offset: req.Offset,
+		Limit:  req.Limit,
+		Query:  req.Query,
+	}
+	for name, facet := range resp.Facets {
+		if result.Facets == nil {
+			result.Facets = make(map[string]map[string]int64)
+		}
+		result.Facets[name] = make(map[string]int64)
+		for _, term := range facet.Terms.Terms() {
+			result.Facets[name][term.Term] = int64(term.Count)
+		}
+	}
+	for _, match := range resp.Hits {
+		stored, _ := match.Fields["doc"].(string)
+		var doc domain.ScrapedDoc
+		if err := json.Unmarshal([]byte(stored), &doc); err != nil {
+			return SearchResult{}, fmt.Errorf("could not read hit %s: %w", match.ID, err)
+		}
+		fields := make(map[string]string)
+		for field, fragments := range match.Fragments {
+			fields[field] = retag(strings.Join(fragments, fragmentSeparator), req.HighlightPreTag, req.HighlightPostTag)
+		}
+		result.Hits = append(result.Hits, Hit{
+			ScrapedDoc: doc,
+			Highlights: highlighted(fields, req.HighlightPreTag),
+		})
+	}
+	return result, nil
+}
+
+// query matches documents with all the words and phrases in any field,
+// weighted by field. The last word also matches as a prefix while it is
+// being typed. Stop words are skipped, as they are not indexed and would
+// match nothing, and so are the stop words in the search settings. Words
+// match their synonyms and, when they are long enough, words with typos.
+func (b BleveIndexer) query(words, phrases []string) query.Query {
+	analyzer := b.index.Mapping().AnalyzerNamed(en.AnalyzerName)
+	fieldBoosts := map[string]float64{
+		"title":       titleBoost,
+		"description": descriptionBoost,
+		"url":         urlBoost,
+		"content":     contentBoost,
+	}
+	conjuncts := make([]query.Query, 0, len(words))
+	for i, word := range words {
+		last := i == len(words)-1
+		stopWord := len(analyzer.Analyze([]byte(word))) == 0 || b.tuning.isStopWord(word)
+		if stopWord && !last {
+			continue
+		}
+		var disjuncts []query.Query
+		for field, boost := range fieldBoosts {
+			if !stopWord {
+				// synonyms match like the word itself
+				for _, alternative := range b.tuning.alternatives(word) {
+					var match query.Query
+					if strings.Contains(alternative, " ") {
+						phrase := bleve.NewMatchPhraseQuery(alternative)
+						phrase.SetField(field)
+						phrase.SetBoost(boost)
+						match = phrase
+					} else {
+						term := bleve.NewMatchQuery(alternative)
+						term.SetField(field)
+						term.SetBoost(boost)
+						term.SetFuzziness(b.tuning.fuzziness(alternative))
+						match = term
+					}
+					disjuncts = append(disjuncts, match)
+				}
+			}
+			if last {
+				prefix := bleve.NewPrefixQuery(strings.ToLower(word))
+				prefix.SetField(field)
+				prefix.SetBoost(boost)
+				disjuncts = append(disjuncts, prefix)
+			}
+		}
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(disjuncts...))
+	}
+	for _, phrase := range phrases {
+		disjuncts := make([]query.Query, 0, len(fieldBoosts))
+		for field, boost := range fieldBoosts {
+			match := bleve.NewMatchPhraseQuery(phrase)
+			match.SetField(field)
+			match.SetBoost(boost)
+			disjuncts = append(disjuncts, match)
+		}
+		conjuncts = append(conjuncts, bleve.NewDisjunctionQuery(disjuncts...))
+	}
+	return bleve.NewConjunctionQuery(conjuncts...)
+}
+
+// bleveFilters returns queries that matching documents must also match
+func bleveFilters(f Filters) []query.Query {
+	var filters []query.Query
+	anyOf := func(field string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		terms := make([]query.Query, 0, len(values))
+		for _, value := range values {
+			term := bleve.NewTermQuery(value)
+			term.SetField(field)
+			terms = append(terms, term)
+		}
+		filters = append(filters, bleve.NewDisjunctionQuery(terms...))
+	}
+	anyOf(DocTypeField, f.docTypes())
+	anyOf(DomainField, f.Domains)
+	anyOf(CollectionsField, f.Collections)
+	anyOf(StatusField, f.statuses())
+	for _, tag := range f.Tags {
+		term := bleve.NewTermQuery(tag)
+		term.SetField(TagsField)
+		filters = append(filters, term)
+	}
+	if f.After != nil || f.Before != nil {
+		var start, end time.Time
+		if f.After != nil {
+			start = time.Time(*f.After)
+		}
+		if f.Before != nil {
+			end = time.Time(*f.Before)
+		}
+		inclusive, exclusive := true, false
+		dates := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &exclusive)
+		dates.SetField("created_at")
+		filters = append(filters, dates)
+	}
+	if f.Favourite != nil {
+		favourite := bleve.NewBoolFieldQuery(*f.Favourite)
+		favourite.SetField(FavouriteField)
+		filters = append(filters, favourite)
+	}
+	if f.Scraped != nil {
+		scraped := bleve.NewBoolFieldQuery(*f.Scraped)
+		scraped.SetField(ScrapedField)
+		filters = append(filters, scraped)
+	}
+	if f.Owner != "" {
+		owner := bleve.NewTermQuery(f.Owner)
+		owner.SetField(OwnerField)
+		shared := bleve.NewBoolFieldQuery(true)
+		shared.SetField(SharedField)
+		filters = append(filters, bleve.NewDisjunctionQuery(owner, shared))
+	}
+	return filters
+}
+
+// retag replaces the html highlighter's mark tags with the requested tags
+// and unescapes the text around them, as highlights are plain text
+func retag(fragment, preTag, postTag string) string {
+	var sb strings.Builder
+	for i, part := range strings.Split(fragment, "<mark>") {
+		if i > 0 {
+			sb.WriteString(preTag)
+		}
+		marked := strings.SplitN(part, "</mark>", 2)
+		if len(marked) == 2 {
+			sb.WriteString(stdhtml.UnescapeString(marked[0]))
+			sb.WriteString(postTag)
+			part = marked[1]
+		}
+		sb.WriteString(stdhtml.UnescapeString(part))
+	}
+	return sb.String()
+}
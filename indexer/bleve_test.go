@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"zeno/domain"
+)
+
+func TestBleveIndexer(t *testing.T) {
+	b, err := NewBleveIndexer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	day := func(d int) domain.Timestamp {
+		return domain.Timestamp(time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC))
+	}
+	docs := []domain.ScrapedDoc{
+		{ID: "1", Title: "Gardening tips", Content: "Water the tomatoes every morning", URL: "https://a.example/garden", ParsedDate: day(1)},
+		{ID: "2", Title: "Tomato soup", Description: "A simple recipe", Content: "Blend roasted tomatoes & <b>", URL: "https://b.example/soup", ParsedDate: day(2)},
+		{ID: "3", Title: "Go generics", Content: "Type parameters in Go", URL: "https://c.example/go", ParsedDate: day(3)},
+	}
+	for _, doc := range docs {
+		if err := b.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		ids   []string
+	}{
+		{name: "empty lists recent", query: "", ids: []string{"3", "2", "1"}},
+		{name: "title ranks first", query: "tomatoes", ids: []string{"2", "1"}},
+		{name: "prefix", query: "gen", ids: []string{"3"}},
+		{name: "all words", query: "roasted tomatoes", ids: []string{"2"}},
+		{name: "stop words", query: "the soup", ids: []string{"2"}},
+		{name: "punctuation", query: `"go" (`, ids: []string{"3"}},
+		{name: "no match", query: "kubernetes", ids: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := b.Search(context.Background(), SearchRequest{Query: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, hit := range result.Hits {
+				ids = append(ids, hit.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.ids)
+			}
+			if result.Total != int64(len(tt.ids)) {
+				t.Errorf("Search(%q) total = %d, want %d", tt.query, result.Total, len(tt.ids))
+			}
+		})
+	}
+
+	result, err := b.Search(context.Background(), SearchRequest{Query: "roasted", HighlightPreTag: "[", HighlightPostTag: "]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Hits[0].Highlights["content"]; got != "Blend [roasted] tomatoes & <b>" {
+		t.Errorf("content highlight = %q", got)
+	}
+	if _, ok := result.Hits[0].Highlights["title"]; ok {
+		t.Error("title should not be highlighted without a match")
+	}
+
+	if err := b.Delete(docs[1]); err != nil {
+		t.Fatal(err)
+	}
+	result, err = b.Search(context.Background(), SearchRequest{Query: "soup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits) != 0 {
+		t.Errorf("deleted doc still found: %v", result.Hits)
+	}
+}
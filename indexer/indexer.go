@@ -1,11 +1,16 @@
 package indexer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/meilisearch/meilisearch-go"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"syscall"
 	"time"
 	"zeno/domain"
@@ -15,11 +20,42 @@ const IndexName = "sites"
 const SearchUrl = "http://localhost:7700"
 const ZenoKeyEnv = "ZENO_KEY"
 
+// MeiliHostEnv sets the url of the search server zeno talks to
+const MeiliHostEnv = "MEILI_HOST"
+
+// MeiliHost returns the url of the search server zeno talks to, SearchUrl
+// unless MeiliHostEnv is set
+func MeiliHost() string {
+	if host := os.Getenv(MeiliHostEnv); host != "" {
+		return host
+	}
+	return SearchUrl
+}
+
+// settingsTaskTimeout is how long to wait for the search server to apply
+// new settings, which reindexes every document
+const settingsTaskTimeout = 10 * time.Minute
+
+// settingsTaskInterval is how often to check if settings have been applied
+const settingsTaskInterval = 500 * time.Millisecond
+
 type Indexer interface {
 	Index(doc domain.ScrapedDoc) error
 	Delete(doc domain.ScrapedDoc) error
 }
 
+// Updater is implemented by indexers that can change some fields of an
+// indexed document without indexing all of it again
+type Updater interface {
+	Update(id string, fields map[string]interface{}) error
+}
+
+// BatchDeleter is implemented by indexers that can delete many documents
+// at once
+type BatchDeleter interface {
+	DeleteBatch(ids []string) error
+}
+
 type MeilisearchIndexer struct {
 	index *meilisearch.Index
 }
@@ -33,6 +69,21 @@ func (m MeilisearchIndexer) Index(doc domain.ScrapedDoc) error {
 	return nil
 }
 
+// Update only sends the changed fields, the search server merges them
+// into the indexed document
+func (m MeilisearchIndexer) Update(id string, fields map[string]interface{}) error {
+	doc := map[string]interface{}{"id": id}
+	for field, value := range fields {
+		doc[field] = value
+	}
+	task, err := m.index.UpdateDocuments([]map[string]interface{}{doc})
+	if err != nil {
+		return fmt.Errorf("could not update scraped doc: %w", err)
+	}
+	log.Printf("updating %s with task UID %d\n", id, task.TaskUID)
+	return nil
+}
+
 func (m MeilisearchIndexer) Delete(doc domain.ScrapedDoc) error {
 	task, err := m.index.DeleteDocument(doc.ID)
 	if err != nil {
@@ -42,6 +93,246 @@ func (m MeilisearchIndexer) Delete(doc domain.ScrapedDoc) error {
 	return nil
 }
 
+func (m MeilisearchIndexer) DeleteBatch(ids []string) error {
+	task, err := m.index.DeleteDocuments(ids)
+	if err != nil {
+		return fmt.Errorf("could not delete scraped docs: %w", err)
+	}
+	log.Printf("delete %d docs with task UID %d\n", len(ids), task.TaskUID)
+	return nil
+}
+
+// SettingsApplier is implemented by indexers that apply search settings
+// such as synonyms to the queries they run
+type SettingsApplier interface {
+	ApplySettings(settings domain.SearchSettings) error
+}
+
+// SearchableFields are the fields queries match, most important first
+var SearchableFields = []string{"title", "description", "content", "url", "annotations"}
+
+// MaxTotalHits is how many hits the search server pages through. Its
+// default of 1000 would stop bulk actions, which page through every match,
+// at the first thousand documents.
+const MaxTotalHits = 1000000
+
+// IndexSettings are the search server settings zeno manages. Filterable
+// fields are the ones searches, facets and search tokens use.
+func IndexSettings(settings domain.SearchSettings) meilisearch.Settings {
+	filterable := append([]string{"created_at", OwnerField, SharedField, FavouriteField, ScrapedField}, FacetFields...)
+	typos := &meilisearch.TypoTolerance{
+		Enabled: true,
+		MinWordSizeForTypos: meilisearch.MinWordSizeForTypos{
+			OneTypo:  int64(settings.TypoTolerance.MinWordSizeForOneTypo),
+			TwoTypos: int64(settings.TypoTolerance.MinWordSizeForTwoTypos),
+		},
+		DisableOnWords:      append([]string{}, settings.TypoTolerance.DisableOnWords...),
+		DisableOnAttributes: []string{},
+	}
+	if !settings.TypoTolerance.Enabled {
+		// the client can't send enabled as false, so typos are turned off
+		// on every searchable field instead
+		typos.DisableOnAttributes = append([]string{}, SearchableFields...)
+	}
+	return meilisearch.Settings{
+		SearchableAttributes: append([]string{}, SearchableFields...),
+		FilterableAttributes: filterable,
+		SortableAttributes:   append([]string{}, SortFields...),
+		Synonyms:             settings.SynonymMap(),
+		StopWords:            append([]string{}, settings.StopWords...),
+		TypoTolerance:        typos,
+		Pagination:           &meilisearch.Pagination{MaxTotalHits: MaxTotalHits},
+	}
+}
+
+// settingsChanges returns the settings in want that differ from current,
+// or nil when the index already has them. The order of searchable fields
+// matters, the order of other lists does not.
+func settingsChanges(current, want meilisearch.Settings) *meilisearch.Settings {
+	var changes meilisearch.Settings
+	changed := false
+	if !equalStrings(current.SearchableAttributes, want.SearchableAttributes) {
+		changes.SearchableAttributes = want.SearchableAttributes
+		changed = true
+	}
+	if !sameStrings(current.FilterableAttributes, want.FilterableAttributes) {
+		changes.FilterableAttributes = want.FilterableAttributes
+		changed = true
+	}
+	if !sameStrings(current.SortableAttributes, want.SortableAttributes) {
+		changes.SortableAttributes = want.SortableAttributes
+		changed = true
+	}
+	if !sameSynonyms(current.Synonyms, want.Synonyms) {
+		changes.Synonyms = want.Synonyms
+		changed = true
+	}
+	if !sameStrings(current.StopWords, want.StopWords) {
+		changes.StopWords = want.StopWords
+		changed = true
+	}
+	if !sameTypoTolerance(current.TypoTolerance, want.TypoTolerance) {
+		changes.TypoTolerance = want.TypoTolerance
+		changed = true
+	}
+	if current.Pagination == nil || *current.Pagination != *want.Pagination {
+		changes.Pagination = want.Pagination
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return &changes
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameStrings reports whether a and b hold the same strings in any order
+func sameStrings(a, b []string) bool {
+	sortedA, sortedB := append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	return equalStrings(sortedA, sortedB)
+}
+
+func sameSynonyms(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for word, synonyms := range a {
+		if !sameStrings(synonyms, b[word]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameTypoTolerance(a, b *meilisearch.TypoTolerance) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Enabled == b.Enabled && a.MinWordSizeForTypos == b.MinWordSizeForTypos &&
+		sameStrings(a.DisableOnWords, b.DisableOnWords) && sameStrings(a.DisableOnAttributes, b.DisableOnAttributes)
+}
+
+// ConfigureIndex applies IndexSettings to the index, creating it if
+// needed. Settings the index already has are not sent again, as changing
+// them makes the search server index every document again.
+func (m MeilisearchIndexer) ConfigureIndex(settings domain.SearchSettings) error {
+	tasks, err := m.updateSettings(settings)
+	if err != nil {
+		return err
+	}
+	return m.waitForTasks(tasks)
+}
+
+// ApplySettings configures the index with the search settings. Changing
+// settings reindexes every document, so it doesn't wait for that and only
+// logs when it fails.
+func (m MeilisearchIndexer) ApplySettings(settings domain.SearchSettings) error {
+	tasks, err := m.updateSettings(settings)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := m.waitForTasks(tasks); err != nil {
+			log.Println("could not apply search settings:", err)
+		}
+	}()
+	return nil
+}
+
+// updateSettings sends the settings that changed to the search server and
+// returns the UIDs of the tasks applying them
+func (m MeilisearchIndexer) updateSettings(settings domain.SearchSettings) ([]int64, error) {
+	var current meilisearch.Settings
+	currentSettings, err := m.index.GetSettings()
+	switch {
+	case err == nil:
+		current = *currentSettings
+	case !isIndexNotFound(err):
+		return nil, fmt.Errorf("could not get index settings: %w", err)
+	}
+	// a missing index has no settings yet, updating them creates it
+	changes := settingsChanges(current, IndexSettings(settings))
+	if changes == nil {
+		log.Println("search index settings are up to date")
+		return nil, nil
+	}
+	var tasks []int64
+	// the client leaves empty lists out of settings, which would keep the
+	// old ones, so they are set on their own or reset first
+	if changes.Synonyms != nil {
+		task, err := m.index.UpdateSynonyms(&changes.Synonyms)
+		if err != nil {
+			return nil, fmt.Errorf("could not update synonyms: %w", err)
+		}
+		tasks = append(tasks, task.TaskUID)
+		changes.Synonyms = nil
+	}
+	if changes.StopWords != nil {
+		task, err := m.index.UpdateStopWords(&changes.StopWords)
+		if err != nil {
+			return nil, fmt.Errorf("could not update stop words: %w", err)
+		}
+		tasks = append(tasks, task.TaskUID)
+		changes.StopWords = nil
+	}
+	if changes.TypoTolerance != nil {
+		task, err := m.index.ResetTypoTolerance()
+		if err != nil {
+			return nil, fmt.Errorf("could not reset typo tolerance: %w", err)
+		}
+		tasks = append(tasks, task.TaskUID)
+	}
+	if reflect.DeepEqual(*changes, meilisearch.Settings{}) {
+		return tasks, nil
+	}
+	task, err := m.index.UpdateSettings(changes)
+	if err != nil {
+		return nil, fmt.Errorf("could not update index settings: %w", err)
+	}
+	log.Printf("updating index settings with task UID %d\n", task.TaskUID)
+	return append(tasks, task.TaskUID), nil
+}
+
+// waitForTasks waits until the search server has processed the tasks,
+// returning an error when one of them failed
+func (m MeilisearchIndexer) waitForTasks(tasks []int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), settingsTaskTimeout)
+	defer cancel()
+	for _, uid := range tasks {
+		task, err := m.index.WaitForTask(uid, meilisearch.WaitParams{Context: ctx, Interval: settingsTaskInterval})
+		if err != nil {
+			return fmt.Errorf("could not wait for settings task %d: %w", uid, err)
+		}
+		if task.Status != meilisearch.TaskStatusSucceeded {
+			return fmt.Errorf("settings task %d %s: %s", uid, task.Status, task.Error.Message)
+		}
+	}
+	if len(tasks) > 0 {
+		log.Println("updated search index settings")
+	}
+	return nil
+}
+
+// isIndexNotFound reports whether err is the search server saying the
+// index doesn't exist yet
+func isIndexNotFound(err error) bool {
+	var meiliErr *meilisearch.Error
+	return errors.As(err, &meiliErr) && meiliErr.MeilisearchApiError.Code == "index_not_found"
+}
+
 func NewMeilisearchIndexer(index *meilisearch.Index) MeilisearchIndexer {
 	if index == nil {
 		panic("index field cannot be nil")
@@ -51,24 +342,88 @@ func NewMeilisearchIndexer(index *meilisearch.Index) MeilisearchIndexer {
 	}
 }
 
-func MakeMeilisearchIndex(host, apiKey string) (*meilisearch.Index, func() bool) {
-	c := meilisearch.NewClient(meilisearch.ClientConfig{
+func MakeMeilisearchClient(host, apiKey string) *meilisearch.Client {
+	return meilisearch.NewClient(meilisearch.ClientConfig{
 		Host:    host,
 		APIKey:  apiKey,
 		Timeout: 100 * time.Millisecond,
 	})
+}
+
+func MakeMeilisearchIndex(host, apiKey string) (*meilisearch.Index, func() bool) {
+	c := MakeMeilisearchClient(host, apiKey)
 	return c.Index(IndexName), c.IsHealthy
 }
 
+// MeilisearchDumper creates dumps of the search server's data, which can
+// be imported into a new search server
+type MeilisearchDumper struct {
+	client  *meilisearch.Client
+	dumpDir string
+}
+
+func NewMeilisearchDumper(client *meilisearch.Client, dumpDir string) MeilisearchDumper {
+	if client == nil {
+		panic("client field cannot be nil")
+	}
+	return MeilisearchDumper{
+		client:  client,
+		dumpDir: dumpDir,
+	}
+}
+
+// Dump creates a dump and returns the path of the dump file once it has
+// been written
+func (m MeilisearchDumper) Dump(ctx context.Context) (string, error) {
+	started := time.Now()
+	task, err := m.client.CreateDump()
+	if err != nil {
+		return "", fmt.Errorf("could not create dump: %w", err)
+	}
+	log.Printf("creating dump with task UID %d\n", task.TaskUID)
+	done, waitErr := m.client.WaitForTask(task.TaskUID, meilisearch.WaitParams{
+		Context:  ctx,
+		Interval: 250 * time.Millisecond,
+	})
+	if waitErr != nil {
+		return "", fmt.Errorf("could not wait for dump: %w", waitErr)
+	}
+	if done.Status != meilisearch.TaskStatusSucceeded {
+		return "", fmt.Errorf("dump task %d %s: %s", task.TaskUID, done.Status, done.Error.Message)
+	}
+
+	// the task does not report the dump's name, so find the newest dump
+	entries, readErr := os.ReadDir(m.dumpDir)
+	if readErr != nil {
+		return "", fmt.Errorf("could not read dump dir: %w", readErr)
+	}
+	var newest string
+	var newestTime time.Time
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if infoErr != nil || filepath.Ext(entry.Name()) != ".dump" {
+			continue
+		}
+		if info.ModTime().After(newestTime) {
+			newest, newestTime = entry.Name(), info.ModTime()
+		}
+	}
+	if newest == "" || newestTime.Before(started.Add(-time.Second)) {
+		return "", fmt.Errorf("could not find dump in %s", m.dumpDir)
+	}
+	return filepath.Join(m.dumpDir, newest), nil
+}
+
 type SearchProcessManager struct {
 	cmd     *exec.Cmd
 	check   func() bool
 	stopSig chan os.Signal
 }
 
-func NewSearchProcessManager(cmdPath, dbPath, addr, apiKey string, check func() bool, sigChan chan os.Signal) SearchProcessManager {
+func NewSearchProcessManager(cmdPath, dbPath, dumpDir, addr, apiKey string, check func() bool, sigChan chan os.Signal) SearchProcessManager {
 	cmd := exec.Command(cmdPath,
 		"--db-path", dbPath,
+		"--dumps-dir", dumpDir,
 		"--http-addr", addr)
 	if apiKey != "" {
 		log.Println("using production env for search")
@@ -84,6 +439,12 @@ func NewSearchProcessManager(cmdPath, dbPath, addr, apiKey string, check func()
 	}
 }
 
+// ImportDump makes the search server import a dump when it starts. The
+// search server's db path must not exist yet.
+func (s *SearchProcessManager) ImportDump(dumpPath string) {
+	s.cmd.Args = append(s.cmd.Args, "--import-dump", dumpPath)
+}
+
 func (s *SearchProcessManager) Start() error {
 	s.cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	s.cmd.Stdout = os.Stdout
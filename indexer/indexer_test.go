@@ -0,0 +1,215 @@
+package indexer
+
+import (
+	"encoding/json"
+	"github.com/meilisearch/meilisearch-go"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"zeno/domain"
+)
+
+func TestConfigureIndex(t *testing.T) {
+	defaults := domain.DefaultSearchSettings()
+	want := IndexSettings(defaults)
+	reordered := IndexSettings(defaults)
+	reordered.FilterableAttributes = append([]string{}, want.FilterableAttributes...)
+	last := len(reordered.FilterableAttributes) - 1
+	reordered.FilterableAttributes[0], reordered.FilterableAttributes[last] =
+		reordered.FilterableAttributes[last], reordered.FilterableAttributes[0]
+	tuned := defaults
+	tuned.Synonyms = [][]string{{"k8s", "kubernetes"}}
+	tuned.StopWords = []string{"the"}
+	tuned.TypoTolerance.Enabled = false
+	withTypos := func(typos *meilisearch.TypoTolerance) *meilisearch.Settings {
+		return &meilisearch.Settings{TypoTolerance: typos}
+	}
+
+	tests := []struct {
+		name          string
+		settings      domain.SearchSettings
+		current       *meilisearch.Settings
+		want          *meilisearch.Settings
+		wantSynonyms  map[string][]string
+		wantStopWords []string
+		wantTypoReset bool
+	}{
+		{name: "missing index", settings: defaults, want: &meilisearch.Settings{
+			SearchableAttributes: want.SearchableAttributes,
+			FilterableAttributes: want.FilterableAttributes,
+			SortableAttributes:   want.SortableAttributes,
+			TypoTolerance:        want.TypoTolerance,
+			Pagination:           want.Pagination,
+		}, wantTypoReset: true},
+		{name: "up to date", settings: defaults, current: &want},
+		{name: "filterable in another order", settings: defaults, current: &reordered},
+		{name: "default searchable", settings: defaults, current: &meilisearch.Settings{
+			SearchableAttributes: []string{"*"},
+			FilterableAttributes: want.FilterableAttributes,
+			SortableAttributes:   want.SortableAttributes,
+			TypoTolerance:        want.TypoTolerance,
+			Pagination:           want.Pagination,
+		}, want: &meilisearch.Settings{SearchableAttributes: want.SearchableAttributes}},
+		{name: "searchable in another order", settings: defaults, current: &meilisearch.Settings{
+			SearchableAttributes: []string{"content", "title", "description", "url", "annotations"},
+			FilterableAttributes: want.FilterableAttributes,
+			SortableAttributes:   []string{"parsed_date"},
+			TypoTolerance:        want.TypoTolerance,
+			Pagination:           want.Pagination,
+		}, want: &meilisearch.Settings{
+			SearchableAttributes: want.SearchableAttributes,
+			SortableAttributes:   want.SortableAttributes,
+		}},
+		{name: "default pagination", settings: defaults, current: func() *meilisearch.Settings {
+			current := IndexSettings(defaults)
+			current.Pagination = &meilisearch.Pagination{MaxTotalHits: 1000}
+			return &current
+		}(), want: &meilisearch.Settings{Pagination: want.Pagination}},
+		{name: "search settings changed", settings: tuned, current: &want,
+			want: withTypos(&meilisearch.TypoTolerance{
+				Enabled:             true,
+				MinWordSizeForTypos: want.TypoTolerance.MinWordSizeForTypos,
+				DisableOnWords:      []string{},
+				DisableOnAttributes: SearchableFields,
+			}),
+			wantSynonyms:  map[string][]string{"k8s": {"kubernetes"}, "kubernetes": {"k8s"}},
+			wantStopWords: []string{"the"},
+			wantTypoReset: true,
+		},
+		{name: "search settings cleared", settings: defaults, current: func() *meilisearch.Settings {
+			current := IndexSettings(tuned)
+			return &current
+		}(), want: withTypos(want.TypoTolerance),
+			wantSynonyms:  map[string][]string{},
+			wantStopWords: []string{},
+			wantTypoReset: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updated *meilisearch.Settings
+			var synonyms map[string][]string
+			var stopWords []string
+			typoReset := false
+			accepted := func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusAccepted)
+				_, _ = w.Write([]byte(`{"taskUid":1,"indexUid":"sites","status":"enqueued","type":"settingsUpdate"}`))
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/indexes/sites/settings":
+					if tt.current == nil {
+						w.WriteHeader(http.StatusNotFound)
+						_, _ = w.Write([]byte(`{"message":"Index sites not found.","code":"index_not_found","type":"invalid_request","link":""}`))
+						return
+					}
+					_ = json.NewEncoder(w).Encode(tt.current)
+				case r.Method == http.MethodPatch && r.URL.Path == "/indexes/sites/settings":
+					updated = &meilisearch.Settings{}
+					if err := json.NewDecoder(r.Body).Decode(updated); err != nil {
+						t.Error(err)
+					}
+					accepted(w)
+				case r.Method == http.MethodPut && r.URL.Path == "/indexes/sites/settings/synonyms":
+					if err := json.NewDecoder(r.Body).Decode(&synonyms); err != nil {
+						t.Error(err)
+					}
+					accepted(w)
+				case r.Method == http.MethodPut && r.URL.Path == "/indexes/sites/settings/stop-words":
+					if err := json.NewDecoder(r.Body).Decode(&stopWords); err != nil {
+						t.Error(err)
+					}
+					accepted(w)
+				case r.Method == http.MethodDelete && r.URL.Path == "/indexes/sites/settings/typo-tolerance":
+					typoReset = true
+					accepted(w)
+				case r.Method == http.MethodGet && r.URL.Path == "/tasks/1":
+					_, _ = w.Write([]byte(`{"uid":1,"indexUid":"sites","status":"succeeded","type":"settingsUpdate"}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			}))
+			defer server.Close()
+
+			index, _ := MakeMeilisearchIndex(server.URL, "")
+			if err := NewMeilisearchIndexer(index).ConfigureIndex(tt.settings); err != nil {
+				t.Fatal(err)
+			}
+			// compare what the server would read, as empty lists are left out
+			if !reflect.DeepEqual(updated, sent(t, tt.want)) {
+				t.Errorf("ConfigureIndex() sent %+v, want %+v", updated, tt.want)
+			}
+			if !reflect.DeepEqual(synonyms, tt.wantSynonyms) {
+				t.Errorf("ConfigureIndex() sent synonyms %v, want %v", synonyms, tt.wantSynonyms)
+			}
+			if !reflect.DeepEqual(stopWords, tt.wantStopWords) {
+				t.Errorf("ConfigureIndex() sent stop words %v, want %v", stopWords, tt.wantStopWords)
+			}
+			if typoReset != tt.wantTypoReset {
+				t.Errorf("ConfigureIndex() reset typo tolerance = %v, want %v", typoReset, tt.wantTypoReset)
+			}
+		})
+	}
+}
+
+func TestConfigureIndexErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings func(w http.ResponseWriter)
+		task     string
+		updated  bool
+	}{
+		{name: "bad key", settings: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"The provided API key is invalid.","code":"invalid_api_key","type":"auth","link":""}`))
+		}},
+		{name: "task failed", settings: func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"Index sites not found.","code":"index_not_found","type":"invalid_request","link":""}`))
+		}, task: `{"uid":1,"indexUid":"sites","status":"failed","type":"settingsUpdate","error":{"message":"no space left on device"}}`, updated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := false
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/indexes/sites/settings":
+					tt.settings(w)
+				case r.Method == http.MethodGet && r.URL.Path == "/tasks/1":
+					_, _ = w.Write([]byte(tt.task))
+				default:
+					// only a missing index has all its settings sent
+					updated = true
+					w.WriteHeader(http.StatusAccepted)
+					_, _ = w.Write([]byte(`{"taskUid":1,"indexUid":"sites","status":"enqueued","type":"settingsUpdate"}`))
+				}
+			}))
+			defer server.Close()
+
+			index, _ := MakeMeilisearchIndex(server.URL, "")
+			if err := NewMeilisearchIndexer(index).ConfigureIndex(domain.DefaultSearchSettings()); err == nil {
+				t.Error("ConfigureIndex() should fail")
+			}
+			if updated != tt.updated {
+				t.Errorf("ConfigureIndex() updated settings = %v, want %v", updated, tt.updated)
+			}
+		})
+	}
+}
+
+// sent returns the settings as the search server receives them
+func sent(t *testing.T, settings *meilisearch.Settings) *meilisearch.Settings {
+	if settings == nil {
+		return nil
+	}
+	body, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received meilisearch.Settings
+	if err := json.Unmarshal(body, &received); err != nil {
+		t.Fatal(err)
+	}
+	return &received
+}
@@ -0,0 +1,203 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"zeno/domain"
+)
+
+// QueryOperators are the operators search box queries can use, such as
+// site:example.com or is:unread
+var QueryOperators = []string{"site", "type", "tag", "collection", "before", "after", "is"}
+
+// ParsedQuery is a search box query split into the words to search for,
+// the quoted phrases documents must contain and the filters its operators
+// set
+type ParsedQuery struct {
+	Text    string
+	Phrases []string
+	Filters Filters
+}
+
+// ParseQuery reads the operators and quoted phrases in a search box query.
+// Words with a colon that don't start with an operator, such as URLs, are
+// searched for as they are.
+func ParseQuery(q string) (ParsedQuery, error) {
+	var parsed ParsedQuery
+	var words []string
+	runes := []rune(q)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		if runes[i] == '"' {
+			phrase, next, err := quoted(runes, i)
+			if err != nil {
+				return ParsedQuery{}, err
+			}
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				parsed.Phrases = append(parsed.Phrases, phrase)
+			}
+			i = next
+			continue
+		}
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != ':' && runes[i] != '"' {
+			i++
+		}
+		name := strings.ToLower(string(runes[start:i]))
+		if i >= len(runes) || runes[i] != ':' || !contains(QueryOperators, name) {
+			// not an operator, search for the whole word
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+				i++
+			}
+			words = append(words, string(runes[start:i]))
+			continue
+		}
+		i++
+		var value string
+		if i < len(runes) && runes[i] == '"' {
+			var err error
+			if value, i, err = quoted(runes, i); err != nil {
+				return ParsedQuery{}, err
+			}
+		} else {
+			valueStart := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) {
+				i++
+			}
+			value = string(runes[valueStart:i])
+		}
+		if err := parsed.Filters.apply(name, strings.TrimSpace(value)); err != nil {
+			return ParsedQuery{}, err
+		}
+	}
+	parsed.Text = strings.Join(words, " ")
+	return parsed, nil
+}
+
+// quoted returns the text between the quote at runes[start] and the next
+// one, and the position after the closing quote
+func quoted(runes []rune, start int) (string, int, error) {
+	for end := start + 1; end < len(runes); end++ {
+		if runes[end] == '"' {
+			return string(runes[start+1 : end]), end + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("%w: quote at character %d is never closed", InvalidSearch, start+1)
+}
+
+// apply sets the filter for the operator name
+func (f *Filters) apply(name, value string) error {
+	if value == "" {
+		return fmt.Errorf("%w: %s: needs a value, such as %s", InvalidSearch, name, operatorExample(name))
+	}
+	if !quotable(value) {
+		// the value ends up quoted in search server filters
+		return fmt.Errorf("%w: %s: values can't contain backslashes or both kinds of quotes", InvalidSearch, name)
+	}
+	switch name {
+	case "site":
+		site := value
+		if strings.Contains(site, "://") {
+			site = domain.DomainOf(site)
+		}
+		f.Domains = append(f.Domains, strings.TrimPrefix(strings.ToLower(site), "www."))
+	case "type":
+		docType, err := domain.ParseDocType(strings.ToLower(value))
+		if err != nil {
+			return fmt.Errorf("%w: %s", InvalidSearch, err)
+		}
+		f.DocTypes = append(f.DocTypes, docType)
+	case "tag":
+		f.Tags = append(f.Tags, domain.NormalizeTags([]string{value})...)
+	case "collection":
+		f.Collections = append(f.Collections, domain.NormalizeCollections([]string{value})...)
+	case "before", "after":
+		t, err := domain.ParseTimestamp(value)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %s", InvalidSearch, name, err)
+		}
+		if name == "before" {
+			f.Before = &t
+		} else {
+			f.After = &t
+		}
+	case "is":
+		yes := true
+		switch strings.ToLower(value) {
+		case "favourite", "favorite":
+			f.Favourite = &yes
+		case "scraped":
+			f.Scraped = &yes
+		default:
+			status, err := domain.ParseReadStatus(strings.ToLower(value))
+			if err != nil {
+				return fmt.Errorf("%w: unknown is:%s, expected unread, read, archived, favourite or scraped",
+					InvalidSearch, value)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	return nil
+}
+
+func operatorExample(name string) string {
+	switch name {
+	case "site":
+		return "site:example.com"
+	case "type":
+		return "type:pdf"
+	case "before", "after":
+		return name + ":2024-01-01"
+	case "is":
+		return "is:unread"
+	}
+	return name + ":go"
+}
+
+// merge adds the filters other sets. Dates set in other replace ours.
+func (f Filters) merge(other Filters) Filters {
+	f.DocTypes = append(append([]domain.DocType{}, f.DocTypes...), other.DocTypes...)
+	f.Domains = append(append([]string{}, f.Domains...), other.Domains...)
+	f.Tags = append(append([]string{}, f.Tags...), other.Tags...)
+	f.Collections = append(append([]string{}, f.Collections...), other.Collections...)
+	f.Statuses = append(append([]domain.ReadStatus{}, f.Statuses...), other.Statuses...)
+	if other.After != nil {
+		f.After = other.After
+	}
+	if other.Before != nil {
+		f.Before = other.Before
+	}
+	if other.Favourite != nil {
+		f.Favourite = other.Favourite
+	}
+	if other.Scraped != nil {
+		f.Scraped = other.Scraped
+	}
+	return f
+}
+
+// MeiliQuery turns a search box query into the query and filter expression
+// the search server understands. Phrases stay quoted, as the search server
+// matches them as phrases.
+func MeiliQuery(q string) (string, string, error) {
+	parsed, err := ParseQuery(q)
+	if err != nil {
+		return "", "", err
+	}
+	return parsed.meiliQuery(), meiliFilter(parsed.Filters), nil
+}
+
+func (p ParsedQuery) meiliQuery() string {
+	terms := make([]string, 0, len(p.Phrases)+1)
+	if p.Text != "" {
+		terms = append(terms, p.Text)
+	}
+	for _, phrase := range p.Phrases {
+		terms = append(terms, `"`+strings.ReplaceAll(phrase, `"`, "")+`"`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"zeno/domain"
+)
+
+func TestParseQuery(t *testing.T) {
+	date := func(s string) *domain.Timestamp {
+		tt, _ := time.Parse("2006-01-02", s)
+		timestamp := domain.Timestamp(tt)
+		return &timestamp
+	}
+	yes := true
+	tests := []struct {
+		name  string
+		query string
+		want  ParsedQuery
+	}{
+		{name: "plain", query: "go generics", want: ParsedQuery{Text: "go generics"}},
+		{name: "operators", query: "site:www.Example.com type:PDF tag:Go is:unread generics",
+			want: ParsedQuery{Text: "generics", Filters: Filters{
+				Domains:  []string{"example.com"},
+				DocTypes: []domain.DocType{domain.Pdf},
+				Tags:     []string{"go"},
+				Statuses: []domain.ReadStatus{domain.Unread},
+			}}},
+		{name: "dates", query: "after:2023-01-01 before:2024-01-01",
+			want: ParsedQuery{Filters: Filters{After: date("2023-01-01"), Before: date("2024-01-01")}}},
+		{name: "phrases", query: `"error handling" in go "  "`,
+			want: ParsedQuery{Text: "in go", Phrases: []string{"error handling"}}},
+		{name: "quoted value", query: `collection:"reading  list" is:favourite`,
+			want: ParsedQuery{Filters: Filters{Collections: []string{"reading list"}, Favourite: &yes}}},
+		{name: "site url", query: "site:https://www.example.com/a",
+			want: ParsedQuery{Filters: Filters{Domains: []string{"example.com"}}}},
+		{name: "other colons", query: "https://example.com/a key:value",
+			want: ParsedQuery{Text: "https://example.com/a key:value"}},
+		{name: "apostrophes", query: `tag:"it's" site:o'reilly.com`,
+			want: ParsedQuery{Filters: Filters{Domains: []string{"o'reilly.com"}, Tags: []string{"it's"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{`"unclosed`, "type:docx", "before:yesterday", "is:starred", "site:", `tag:"go`,
+		`collection:a"'OR(shared=false)OR'`, `site:a.example\`} {
+		t.Run(query, func(t *testing.T) {
+			if _, err := ParseQuery(query); !errors.Is(err, InvalidSearch) {
+				t.Errorf("ParseQuery(%q) error = %v, want an invalid search", query, err)
+			}
+		})
+	}
+}
+
+func TestMeiliQuery(t *testing.T) {
+	q, filter, err := MeiliQuery(`"error handling" go site:example.com is:read`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `go "error handling"`; q != want {
+		t.Errorf("MeiliQuery() query = %s, want %s", q, want)
+	}
+	if want := `(domain = "example.com") AND (status = "read")`; filter != want {
+		t.Errorf("MeiliQuery() filter = %s, want %s", filter, want)
+	}
+
+	if _, filter, err := MeiliQuery(`tag:"it's"`); err != nil || filter != `tags = "it's"` {
+		t.Errorf("MeiliQuery() filter = %s, %v, want the tag in double quotes", filter, err)
+	}
+}
@@ -0,0 +1,515 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/meilisearch/meilisearch-go"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"zeno/domain"
+)
+
+// search backends zeno can run with
+const (
+	MeilisearchBackend = "meilisearch"
+	SqliteBackend      = "sqlite"
+	BleveBackend       = "bleve"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	defaultPreTag      = "<mark>"
+	defaultPostTag     = "</mark>"
+	// snippetWords is roughly how many words of content a snippet has
+	snippetWords = 32
+)
+
+// Searcher finds indexed documents matching a query
+type Searcher interface {
+	Search(ctx context.Context, req SearchRequest) (SearchResult, error)
+}
+
+// Backend indexes documents and searches them
+type Backend interface {
+	Indexer
+	Searcher
+}
+
+// fields documents can be filtered and faceted on
+const (
+	DocTypeField     = "doc_type"
+	DomainField      = "domain"
+	TagsField        = "tags"
+	CollectionsField = "collections"
+	StatusField      = "status"
+	OwnerField       = "owner"
+	SharedField      = "shared"
+	FavouriteField   = "favourite"
+	ScrapedField     = "scraped"
+)
+
+// FacetFields are the fields SearchRequest.Facets can count values of
+var FacetFields = []string{DocTypeField, DomainField, TagsField, CollectionsField, StatusField}
+
+// listFields are the facet fields holding a list of values
+var listFields = []string{TagsField, CollectionsField}
+
+// SortRelevance orders hits by how well they match the query, or by most
+// recently scraped without a query
+const SortRelevance = "relevance"
+
+// SortFields are the fields SearchRequest.Sort can order hits by
+var SortFields = []string{"parsed_date", "created_at"}
+
+// maxFacetValues is how many of the most common values are counted per
+// facet
+const maxFacetValues = 100
+
+// InvalidSearch is returned for search requests that can't be run
+var InvalidSearch = errors.New("invalid search")
+
+// Filters narrow a search down. Fields with several values match any of
+// them, except for Tags where documents must have all of them. After and
+// Before bound when documents were saved.
+type Filters struct {
+	DocTypes    []domain.DocType    `json:"doc_type,omitempty"`
+	Domains     []string            `json:"domain,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Collections []string            `json:"collections,omitempty"`
+	Statuses    []domain.ReadStatus `json:"status,omitempty"`
+	After       *domain.Timestamp   `json:"after,omitempty"`
+	Before      *domain.Timestamp   `json:"before,omitempty"`
+	// Favourite limits results to favourites, or to other documents when
+	// false
+	Favourite *bool `json:"favourite,omitempty"`
+	// Scraped limits results to documents zeno fetches itself, or to
+	// pushed and uploaded ones when false
+	Scraped *bool `json:"scraped,omitempty"`
+	// Owner limits results to the documents of the user with this ID and
+	// shared ones. It is set from who is searching, never from requests.
+	Owner string `json:"-"`
+}
+
+func (f Filters) docTypes() []string {
+	docTypes := make([]string, 0, len(f.DocTypes))
+	for _, docType := range f.DocTypes {
+		docTypes = append(docTypes, string(docType))
+	}
+	return docTypes
+}
+
+// Empty reports whether the filters match every document a user can see
+func (f Filters) Empty() bool {
+	return len(f.DocTypes) == 0 && len(f.Domains) == 0 && len(f.Tags) == 0 && len(f.Collections) == 0 &&
+		len(f.Statuses) == 0 && f.After == nil && f.Before == nil && f.Favourite == nil &&
+		f.Scraped == nil
+}
+
+func (f Filters) statuses() []string {
+	statuses := make([]string, 0, len(f.Statuses))
+	for _, status := range f.Statuses {
+		statuses = append(statuses, string(status))
+	}
+	return statuses
+}
+
+// SearchRequest is a query against the index. Sort is SortRelevance or a
+// field in SortFields followed by ":asc" or ":desc". Facets are fields in
+// FacetFields to count the values of across all matching documents.
+// HighlightPreTag and HighlightPostTag surround matched terms in
+// highlights.
+type SearchRequest struct {
+	Query            string   `json:"query"`
+	Filters          Filters  `json:"filters"`
+	Sort             string   `json:"sort,omitempty"`
+	Facets           []string `json:"facets,omitempty"`
+	Offset           int      `json:"offset"`
+	Limit            int      `json:"limit"`
+	HighlightPreTag  string   `json:"highlight_pre_tag,omitempty"`
+	HighlightPostTag string   `json:"highlight_post_tag,omitempty"`
+}
+
+// Validate checks the request only uses supported sorts, facets, types
+// and statuses
+func (r SearchRequest) Validate() error {
+	if r.Offset < 0 || r.Limit < 0 {
+		return fmt.Errorf("%w: offset and limit must not be negative", InvalidSearch)
+	}
+	if r.Sort != "" && r.Sort != SortRelevance {
+		field, order, _ := strings.Cut(r.Sort, ":")
+		if !contains(SortFields, field) || (order != "asc" && order != "desc") {
+			return fmt.Errorf(
+				"%w: sort must be %s or one of %v followed by :asc or :desc",
+				InvalidSearch, SortRelevance, SortFields,
+			)
+		}
+	}
+	for _, facet := range r.Facets {
+		if !contains(FacetFields, facet) {
+			return fmt.Errorf("%w: unknown facet %q, expected one of %v", InvalidSearch, facet, FacetFields)
+		}
+	}
+	for _, docType := range r.Filters.DocTypes {
+		if _, err := domain.ParseDocType(string(docType)); err != nil {
+			return fmt.Errorf("%w: %s", InvalidSearch, err)
+		}
+	}
+	for _, status := range r.Filters.Statuses {
+		if _, err := domain.ParseReadStatus(string(status)); err != nil {
+			return fmt.Errorf("%w: %s", InvalidSearch, err)
+		}
+	}
+	for _, values := range [][]string{r.Filters.Domains, r.Filters.Tags, r.Filters.Collections} {
+		for _, value := range values {
+			if !quotable(value) {
+				return fmt.Errorf("%w: %q has both kinds of quote or a backslash", InvalidSearch, value)
+			}
+		}
+	}
+	if r.Filters.After != nil && r.Filters.Before != nil &&
+		!time.Time(*r.Filters.After).Before(time.Time(*r.Filters.Before)) {
+		return fmt.Errorf("%w: after must be before before", InvalidSearch)
+	}
+	return nil
+}
+
+// parse validates the request and reads the operators and phrases in its
+// query, adding the filters they set to the request's
+func (r SearchRequest) parse() (SearchRequest, ParsedQuery, error) {
+	if err := r.Validate(); err != nil {
+		return SearchRequest{}, ParsedQuery{}, err
+	}
+	parsed, err := ParseQuery(r.Query)
+	if err != nil {
+		return SearchRequest{}, ParsedQuery{}, err
+	}
+	r.Filters = r.Filters.merge(parsed.Filters)
+	if err := r.Validate(); err != nil {
+		return SearchRequest{}, ParsedQuery{}, err
+	}
+	return r.normalize(), parsed, nil
+}
+
+// sortBy returns the field and direction hits are sorted by, or an empty
+// field to sort by relevance
+func (r SearchRequest) sortBy() (field string, desc bool) {
+	if r.Sort == "" || r.Sort == SortRelevance {
+		return "", false
+	}
+	field, order, _ := strings.Cut(r.Sort, ":")
+	return field, order == "desc"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize fills in defaults and bounds the page size
+func (r SearchRequest) normalize() SearchRequest {
+	r.Query = strings.TrimSpace(r.Query)
+	if r.Offset < 0 {
+		r.Offset = 0
+	}
+	if r.Limit <= 0 {
+		r.Limit = DefaultSearchLimit
+	}
+	if r.Limit > MaxSearchLimit {
+		r.Limit = MaxSearchLimit
+	}
+	if r.HighlightPreTag == "" && r.HighlightPostTag == "" {
+		r.HighlightPreTag, r.HighlightPostTag = defaultPreTag, defaultPostTag
+	}
+	return r
+}
+
+// Hit is a matching document without its content. Highlights maps field
+// names to fragments of the field with the matched terms tagged, and only
+// has the fields that matched.
+type Hit struct {
+	domain.ScrapedDoc
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// SearchResult is a page of hits. Facets maps each requested facet to the
+// number of matching documents with each value.
+type SearchResult struct {
+	Hits   []Hit                       `json:"hits"`
+	Total  int64                       `json:"total"`
+	Offset int                         `json:"offset"`
+	Limit  int                         `json:"limit"`
+	Query  string                      `json:"query"`
+	Facets map[string]map[string]int64 `json:"facets,omitempty"`
+}
+
+// SearchIDs returns the IDs of every document matching the request,
+// paging through the hits until a page comes back short. The total isn't
+// used, as the search server only estimates it. Facets, offset and limit
+// are ignored.
+func SearchIDs(ctx context.Context, searcher Searcher, req SearchRequest) ([]string, error) {
+	req.Facets, req.Offset, req.Limit = nil, 0, MaxSearchLimit
+	var ids []string
+	for {
+		result, err := searcher.Search(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range result.Hits {
+			ids = append(ids, hit.ID)
+		}
+		req.Offset += len(result.Hits)
+		if len(result.Hits) < req.Limit {
+			return ids, nil
+		}
+	}
+}
+
+// highlighted adds the fields whose values contain a tagged match
+func highlighted(fields map[string]string, preTag string) map[string]string {
+	highlights := make(map[string]string)
+	for field, value := range fields {
+		if strings.Contains(value, preTag) {
+			highlights[field] = value
+		}
+	}
+	if len(highlights) == 0 {
+		return nil
+	}
+	return highlights
+}
+
+// searchableContent is the content of a document followed by the text of
+// its annotations, so backends with a fixed set of text fields find notes
+func searchableContent(doc domain.ScrapedDoc) string {
+	notes := doc.AnnotationText()
+	if notes == "" {
+		return doc.Content
+	}
+	return doc.Content + "\n" + notes
+}
+
+// hitAttributes are the document fields returned with each hit
+var hitAttributes = []string{
+	"id", "title", "description", "url", "scraped", "parsed_date",
+	"doc_type", "fingerprint", "duplicate_of", "tags", "collections",
+	"created_at", "status", "read_at", "progress", "favourite", "domain",
+	"owner", "shared", "annotations",
+}
+
+func (m MeilisearchIndexer) Search(ctx context.Context, req SearchRequest) (SearchResult, error) {
+	req, parsed, err := req.parse()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	searchReq := &meilisearch.SearchRequest{
+		Offset:                int64(req.Offset),
+		Limit:                 int64(req.Limit),
+		AttributesToRetrieve:  hitAttributes,
+		AttributesToHighlight: []string{"title", "description", "url"},
+		AttributesToCrop:      []string{"content"},
+		CropLength:            snippetWords,
+		HighlightPreTag:       req.HighlightPreTag,
+		HighlightPostTag:      req.HighlightPostTag,
+		Facets:                req.Facets,
+	}
+	if filter := meiliFilter(req.Filters); filter != "" {
+		searchReq.Filter = filter
+	}
+	if field, desc := req.sortBy(); field != "" {
+		order := "asc"
+		if desc {
+			order = "desc"
+		}
+		searchReq.Sort = []string{field + ":" + order}
+	}
+	q := parsed.meiliQuery()
+	if q == "" && searchReq.Sort == nil {
+		searchReq.Sort = []string{"parsed_date:desc"}
+	}
+	resp, err := m.index.Search(q, searchReq)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("could not search: %w", err)
+	}
+
+	result := SearchResult{
+		Hits:   make([]Hit, 0, len(resp.Hits)),
+		Total:  resp.EstimatedTotalHits,
+		Offset: req.Offset,
+		Limit:  req.Limit,
+		Query:  req.Query,
+	}
+	if len(req.Facets) > 0 {
+		b, marshalErr := json.Marshal(resp.FacetDistribution)
+		if marshalErr != nil {
+			return SearchResult{}, fmt.Errorf("could not read facets: %w", marshalErr)
+		}
+		if unmarshalErr := json.Unmarshal(b, &result.Facets); unmarshalErr != nil {
+			return SearchResult{}, fmt.Errorf("could not read facets: %w", unmarshalErr)
+		}
+	}
+	for _, raw := range resp.Hits {
+		// hits are decoded as generic maps, so round trip them to get docs
+		b, marshalErr := json.Marshal(raw)
+		if marshalErr != nil {
+			return SearchResult{}, fmt.Errorf("could not read hit: %w", marshalErr)
+		}
+		var hit struct {
+			domain.ScrapedDoc
+			Formatted map[string]interface{} `json:"_formatted"`
+		}
+		if unmarshalErr := json.Unmarshal(b, &hit); unmarshalErr != nil {
+			return SearchResult{}, fmt.Errorf("could not read hit: %w", unmarshalErr)
+		}
+		fields := make(map[string]string)
+		for _, field := range []string{"title", "description", "content", "url"} {
+			if s, ok := hit.Formatted[field].(string); ok {
+				fields[field] = s
+			}
+		}
+		hit.ScrapedDoc.Content = ""
+		result.Hits = append(result.Hits, Hit{
+			ScrapedDoc: hit.ScrapedDoc,
+			Highlights: highlighted(fields, req.HighlightPreTag),
+		})
+	}
+	return result, nil
+}
+
+// meiliFilter turns filters into a meilisearch filter expression
+func meiliFilter(f Filters) string {
+	var clauses []string
+	anyOf := func(field string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		alternatives := make([]string, 0, len(values))
+		for _, value := range values {
+			alternatives = append(alternatives, field+" = "+meiliQuote(value))
+		}
+		clauses = append(clauses, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	anyOf(DocTypeField, f.docTypes())
+	anyOf(DomainField, f.Domains)
+	anyOf(CollectionsField, f.Collections)
+	anyOf(StatusField, f.statuses())
+	for _, tag := range f.Tags {
+		clauses = append(clauses, TagsField+" = "+meiliQuote(tag))
+	}
+	if f.After != nil {
+		clauses = append(clauses, "created_at >= "+f.After.String())
+	}
+	if f.Before != nil {
+		clauses = append(clauses, "created_at < "+f.Before.String())
+	}
+	if f.Favourite != nil {
+		clauses = append(clauses, FavouriteField+" = "+strconv.FormatBool(*f.Favourite))
+	}
+	if f.Scraped != nil {
+		clauses = append(clauses, ScrapedField+" = "+strconv.FormatBool(*f.Scraped))
+	}
+	if f.Owner != "" {
+		clauses = append(clauses, VisibleFilter(f.Owner))
+	}
+	return strings.Join(clauses, " AND ")
+}
+
+// VisibleFilter is the search server filter matching the documents the
+// user with userID can see
+func VisibleFilter(userID string) string {
+	return "(" + OwnerField + " = " + meiliQuote(userID) + " OR " + SharedField + " = true)"
+}
+
+// meiliQuote quotes a filter value, using single quotes for values with
+// double quotes in them. Values with both quotes or backslashes are
+// escaped, which search servers before v0.29 don't understand, so
+// Validate rejects them.
+func meiliQuote(value string) string {
+	switch {
+	case !strings.ContainsAny(value, `"\`):
+		return `"` + value + `"`
+	case !strings.ContainsAny(value, `'\`):
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// quotable reports whether a filter value can be quoted without escaping
+func quotable(value string) bool {
+	return !strings.Contains(value, `\`) && !(strings.Contains(value, `"`) && strings.Contains(value, "'"))
+}
+
+// queryWords splits a free text query into its words, dropping punctuation
+// that search backends would otherwise treat as syntax
+func queryWords(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// queryTuning holds the search settings that backends without a search
+// server apply to the queries they run
+type queryTuning struct {
+	mu        sync.RWMutex
+	synonyms  map[string][]string
+	stopWords map[string]bool
+	typos     domain.TypoTolerance
+}
+
+func newQueryTuning() *queryTuning {
+	t := &queryTuning{}
+	t.set(domain.DefaultSearchSettings())
+	return t
+}
+
+func (t *queryTuning) set(settings domain.SearchSettings) {
+	stopWords := make(map[string]bool, len(settings.StopWords))
+	for _, word := range settings.StopWords {
+		stopWords[word] = true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.synonyms = settings.SynonymMap()
+	t.stopWords = stopWords
+	t.typos = settings.TypoTolerance
+}
+
+// isStopWord reports whether word is left out of queries
+func (t *queryTuning) isStopWord(word string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.stopWords[strings.ToLower(word)]
+}
+
+// alternatives returns the word followed by its synonyms
+func (t *queryTuning) alternatives(word string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]string{word}, t.synonyms[strings.ToLower(word)]...)
+}
+
+// fuzziness returns how many typos a word may be matched with
+func (t *queryTuning) fuzziness(word string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	size := utf8.RuneCountInString(word)
+	switch {
+	case !t.typos.Enabled || contains(t.typos.DisableOnWords, strings.ToLower(word)):
+		return 0
+	case size >= t.typos.MinWordSizeForTwoTypos:
+		return 2
+	case size >= t.typos.MinWordSizeForOneTypo:
+		return 1
+	}
+	return 0
+}
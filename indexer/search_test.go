@@ -0,0 +1,334 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"zeno/domain"
+)
+
+func TestSearchRequestValidate(t *testing.T) {
+	after := domain.Timestamp(time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC))
+	before := domain.Timestamp(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name    string
+		req     SearchRequest
+		wantErr bool
+	}{
+		{name: "defaults", req: SearchRequest{}},
+		{name: "relevance", req: SearchRequest{Sort: SortRelevance}},
+		{name: "field sort", req: SearchRequest{Sort: "created_at:desc"}},
+		{name: "unknown sort field", req: SearchRequest{Sort: "title:asc"}, wantErr: true},
+		{name: "sort without order", req: SearchRequest{Sort: "created_at"}, wantErr: true},
+		{name: "facets", req: SearchRequest{Facets: FacetFields}},
+		{name: "unknown facet", req: SearchRequest{Facets: []string{"content"}}, wantErr: true},
+		{name: "negative offset", req: SearchRequest{Offset: -1}, wantErr: true},
+		{name: "empty date range", req: SearchRequest{Filters: Filters{After: &after, Before: &before}}, wantErr: true},
+		{name: "quoted tag", req: SearchRequest{Filters: Filters{Tags: []string{`say "hi"`, "it's"}}}},
+		{name: "both quotes", req: SearchRequest{
+			Filters: Filters{Collections: []string{`a"')OR(shared=false)OR('`}},
+		}, wantErr: true},
+		{name: "backslash", req: SearchRequest{Filters: Filters{Domains: []string{`a.example\`}}}, wantErr: true},
+		{name: "types and statuses", req: SearchRequest{
+			Filters: Filters{DocTypes: []domain.DocType{domain.Pdf}, Statuses: []domain.ReadStatus{domain.Archived}},
+		}},
+		{name: "unknown type", req: SearchRequest{Filters: Filters{DocTypes: []domain.DocType{"docx"}}}, wantErr: true},
+		{name: "unknown status", req: SearchRequest{Filters: Filters{Statuses: []domain.ReadStatus{"starred"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, InvalidSearch) {
+				t.Errorf("Validate() error = %v, want InvalidSearch", err)
+			}
+		})
+	}
+}
+
+// testSearchFilters checks a backend filters, sorts and facets the same
+// way as the others
+func testSearchFilters(t *testing.T, backend Backend) {
+	day := func(d int) domain.Timestamp {
+		return domain.Timestamp(time.Date(2023, 1, d, 0, 0, 0, 0, time.UTC))
+	}
+	docs := []domain.ScrapedDoc{
+		{ID: "1", Title: "Soup recipes", URL: "https://a.example/1", Domain: "a.example", DocType: domain.Html,
+			Tags: []string{"food", "recipes"}, Collections: []string{"Dinner"}, Status: domain.Unread, CreatedAt: day(1), ParsedDate: day(5),
+			Owner: "ada", Scrape: true},
+		{ID: "2", Title: "Soup history", URL: "https://b.example/2", Domain: "b.example", DocType: domain.Pdf,
+			Tags: []string{"food", "history"}, Collections: []string{"Dinner", "Reading list"}, Status: domain.Read, CreatedAt: day(2), ParsedDate: day(4),
+			Owner: "bob", Favourite: true},
+		{ID: "3", Title: "Bread", URL: "https://a.example/3", Domain: "a.example", DocType: domain.Html,
+			Tags: []string{"food"}, Status: domain.Read, CreatedAt: day(3), ParsedDate: day(6),
+			Owner: "bob", Shared: true, Annotations: []domain.Annotation{{ID: "n1", Comment: "try a sourdough starter"}}},
+	}
+	for _, doc := range docs {
+		if err := backend.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	after, before := day(2), day(3)
+	yes, no := true, false
+
+	tests := []struct {
+		name   string
+		req    SearchRequest
+		ids    []string
+		facets map[string]map[string]int64
+	}{
+		{name: "doc type", req: SearchRequest{Filters: Filters{DocTypes: []domain.DocType{domain.Pdf}}}, ids: []string{"2"}},
+		{name: "any domain", req: SearchRequest{
+			Filters: Filters{Domains: []string{"a.example", "b.example"}},
+			Sort:    "created_at:asc",
+		}, ids: []string{"1", "2", "3"}},
+		{name: "all tags", req: SearchRequest{Filters: Filters{Tags: []string{"food", "history"}}}, ids: []string{"2"}},
+		{name: "any collection", req: SearchRequest{
+			Filters: Filters{Collections: []string{"Reading list", "Breakfast"}},
+		}, ids: []string{"2"}},
+		{name: "status and query", req: SearchRequest{
+			Query:   "soup",
+			Filters: Filters{Statuses: []domain.ReadStatus{domain.Read}},
+		}, ids: []string{"2"}},
+		{name: "favourites", req: SearchRequest{Filters: Filters{Favourite: &yes}}, ids: []string{"2"}},
+		{name: "not favourites", req: SearchRequest{
+			Filters: Filters{Favourite: &no},
+			Sort:    "created_at:asc",
+		}, ids: []string{"1", "3"}},
+		{name: "scraped", req: SearchRequest{Filters: Filters{Scraped: &yes}}, ids: []string{"1"}},
+		{name: "query operators", req: SearchRequest{Query: `site:a.example is:unread soup`}, ids: []string{"1"}},
+		{name: "phrase", req: SearchRequest{Query: `"soup history"`}, ids: []string{"2"}},
+		{name: "phrase out of order", req: SearchRequest{Query: `"history soup"`}},
+		{name: "date range", req: SearchRequest{Filters: Filters{After: &after, Before: &before}}, ids: []string{"2"}},
+		{name: "sort", req: SearchRequest{Sort: "parsed_date:desc"}, ids: []string{"3", "1", "2"}},
+		{name: "annotations", req: SearchRequest{Query: "sourdough"}, ids: []string{"3"}},
+		{name: "owner and shared", req: SearchRequest{Filters: Filters{Owner: "ada"}}, ids: []string{"1", "3"}},
+		{name: "facets", req: SearchRequest{Query: "soup", Facets: []string{TagsField, CollectionsField, DomainField}}, ids: []string{"1", "2"},
+			facets: map[string]map[string]int64{
+				TagsField:        {"food": 2, "recipes": 1, "history": 1},
+				CollectionsField: {"Dinner": 2, "Reading list": 1},
+				DomainField:      {"a.example": 1, "b.example": 1},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := backend.Search(context.Background(), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, hit := range result.Hits {
+				ids = append(ids, hit.ID)
+			}
+			if tt.req.Sort == "" {
+				// relevance ties are ordered differently by each backend
+				sort.Strings(ids)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("Search() = %v, want %v", ids, tt.ids)
+			}
+			if tt.facets != nil && !reflect.DeepEqual(result.Facets, tt.facets) {
+				t.Errorf("Search() facets = %v, want %v", result.Facets, tt.facets)
+			}
+		})
+	}
+
+	if deleter, ok := backend.(BatchDeleter); ok {
+		if err := deleter.DeleteBatch([]string{"1", "3"}); err != nil {
+			t.Fatal(err)
+		}
+		ids, err := SearchIDs(context.Background(), backend, SearchRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(ids, ",") != "2" {
+			t.Errorf("DeleteBatch() left %v, want [2]", ids)
+		}
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testSearchFilters(t, newTestSqliteIndexer(t))
+	})
+	t.Run("bleve", func(t *testing.T) {
+		b, err := NewBleveIndexer("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		testSearchFilters(t, b)
+	})
+}
+
+// settingsBackend is a backend that applies search settings to queries
+type settingsBackend interface {
+	Backend
+	SettingsApplier
+}
+
+func testSearchSettings(t *testing.T, backend settingsBackend, typos bool) {
+	docs := []domain.ScrapedDoc{
+		{ID: "1", Title: "Kubernetes deployments", URL: "https://a.example/1"},
+		{ID: "2", Title: "Soup recipes", URL: "https://b.example/2"},
+		{ID: "3", Title: "Container orchestration", URL: "https://c.example/3"},
+	}
+	for _, doc := range docs {
+		if err := backend.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tuned := domain.DefaultSearchSettings()
+	tuned.Synonyms = [][]string{{"k8s", "kubernetes", "container orchestration"}}
+	tuned.StopWords = []string{"howto"}
+	strict := tuned
+	strict.TypoTolerance.Enabled = false
+	exact := tuned
+	exact.TypoTolerance.DisableOnWords = []string{"recipies"}
+
+	tests := []struct {
+		name     string
+		settings domain.SearchSettings
+		query    string
+		ids      []string
+	}{
+		{name: "no synonyms", settings: domain.DefaultSearchSettings(), query: "k8s"},
+		{name: "synonyms", settings: tuned, query: "k8s", ids: []string{"1", "3"}},
+		{name: "synonym of a phrase", settings: tuned, query: "kubernetes", ids: []string{"1", "3"}},
+		{name: "no stop words", settings: domain.DefaultSearchSettings(), query: "howto soup"},
+		{name: "stop words", settings: tuned, query: "howto soup", ids: []string{"2"}},
+		{name: "only stop words", settings: tuned, query: "howto"},
+		{name: "typo", settings: tuned, query: "recipies soup", ids: typoIDs(typos, "2")},
+		{name: "typos disabled", settings: strict, query: "recipies soup"},
+		{name: "typos disabled on word", settings: exact, query: "recipies soup"},
+		{name: "short words are exact", settings: tuned, query: "sop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := backend.ApplySettings(tt.settings); err != nil {
+				t.Fatal(err)
+			}
+			ids, err := SearchIDs(context.Background(), backend, SearchRequest{Query: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.ids)
+			}
+		})
+	}
+}
+
+// typoIDs returns ids for backends that match words with typos
+func typoIDs(typos bool, ids ...string) []string {
+	if !typos {
+		return nil
+	}
+	return ids
+}
+
+func TestSearchSettings(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		testSearchSettings(t, newTestSqliteIndexer(t), false)
+	})
+	t.Run("bleve", func(t *testing.T) {
+		b, err := NewBleveIndexer("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+		testSearchSettings(t, b, true)
+	})
+}
+
+// pagedSearcher returns the IDs of n documents a page at a time. Like the
+// search server, it estimates the total as at most 1000.
+type pagedSearcher int
+
+func (p pagedSearcher) Search(_ context.Context, req SearchRequest) (SearchResult, error) {
+	total := int64(p)
+	if total > 1000 {
+		total = 1000
+	}
+	result := SearchResult{Total: total, Offset: req.Offset, Limit: req.Limit}
+	for i := req.Offset; i < int(p) && i < req.Offset+req.Limit; i++ {
+		result.Hits = append(result.Hits, Hit{ScrapedDoc: domain.ScrapedDoc{ID: strconv.Itoa(i)}})
+	}
+	return result, nil
+}
+
+func TestSearchIDs(t *testing.T) {
+	ids, err := SearchIDs(context.Background(), pagedSearcher(MaxSearchLimit*2+5), SearchRequest{Limit: 1, Offset: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != MaxSearchLimit*2+5 || ids[0] != "0" || ids[len(ids)-1] != strconv.Itoa(MaxSearchLimit*2+4) {
+		t.Errorf("SearchIDs() returned %d ids from %v to %v", len(ids), ids[0], ids[len(ids)-1])
+	}
+}
+
+func TestSearchIDsPastEstimatedTotal(t *testing.T) {
+	for _, n := range []int{1000, 2500} {
+		ids, err := SearchIDs(context.Background(), pagedSearcher(n), SearchRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != n {
+			t.Errorf("SearchIDs() returned %d ids, want %d", len(ids), n)
+		}
+	}
+}
+
+func TestMeiliFilter(t *testing.T) {
+	after := domain.Timestamp(time.Unix(100, 0))
+	got := meiliFilter(Filters{
+		DocTypes:    []domain.DocType{domain.Html, domain.Pdf},
+		Tags:        []string{"go", `say "hi"`},
+		Collections: []string{"Reading list"},
+		After:       &after,
+		Owner:       "ada",
+	})
+	want := `(doc_type = "html" OR doc_type = "pdf") AND (collections = "Reading list") AND tags = "go" AND tags = 'say "hi"' AND created_at >= 100` +
+		` AND (owner = "ada" OR shared = true)`
+	if got != want {
+		t.Errorf("meiliFilter() = %s, want %s", got, want)
+	}
+}
+
+func TestMeiliFilterReadState(t *testing.T) {
+	favourite := true
+	got := meiliFilter(Filters{Statuses: []domain.ReadStatus{domain.Unread}, Favourite: &favourite, Owner: "ada"})
+	want := `(status = "unread") AND favourite = true AND (owner = "ada" OR shared = true)`
+	if got != want {
+		t.Errorf("meiliFilter() = %s, want %s", got, want)
+	}
+}
+
+func TestMeiliFilterQuotes(t *testing.T) {
+	// values with both quotes are escaped instead of closing the string
+	got := meiliFilter(Filters{Tags: []string{`a"')OR(shared=false)OR('\`}, Owner: "bob"})
+	want := `tags = "a\"')OR(shared=false)OR('\\" AND (owner = "bob" OR shared = true)`
+	if got != want {
+		t.Errorf("meiliFilter() = %s, want %s", got, want)
+	}
+}
+
+func TestHitAttributes(t *testing.T) {
+	// content is cropped into snippets and trashed documents aren't indexed
+	skipped := map[string]bool{"content": true, "deleted_at": true}
+	docType := reflect.TypeOf(domain.ScrapedDoc{})
+	for i := 0; i < docType.NumField(); i++ {
+		name, _, _ := strings.Cut(docType.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" && !skipped[name] && !contains(hitAttributes, name) {
+			t.Errorf("hits are missing %s", name)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+	"zeno/domain"
+)
+
+const ftsTable = "documents_fts"
+
+// ftsSchema has a column per searchable field, plus the document without
+// its content so hits don't need another lookup. Unindexed columns still
+// take a bm25 weight.
+const ftsSchema = `CREATE VIRTUAL TABLE IF NOT EXISTS ` + ftsTable + ` USING fts5(
+	id UNINDEXED,
+	title,
+	description,
+	content,
+	url,
+	doc UNINDEXED,
+	tokenize = 'porter unicode61 remove_diacritics 2'
+)`
+
+// ftsRank weighs title matches over description, url and content matches
+const ftsRank = `bm25(` + ftsTable + `, 0, 10, 5, 1, 2, 0)`
+
+// SqliteIndexer indexes documents in a sqlite FTS5 table, so search runs
+// in the same database as the documents without a separate server
+type SqliteIndexer struct {
+	db     *gorm.DB
+	tuning *queryTuning
+}
+
+// NewSqliteIndexer creates the FTS5 table if it does not exist. sqlite has
+// to be built with FTS5, e.g. with the sqlite_fts5 build tag.
+func NewSqliteIndexer(db *gorm.DB) (SqliteIndexer, error) {
+	if db == nil {
+		panic("db field cannot be nil")
+	}
+	if err := db.Exec(ftsSchema).Error; err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return SqliteIndexer{}, fmt.Errorf("sqlite was built without FTS5, build with -tags sqlite_fts5: %w", err)
+		}
+		return SqliteIndexer{}, fmt.Errorf("could not create search table: %w", err)
+	}
+	return SqliteIndexer{db: db, tuning: newQueryTuning()}, nil
+}
+
+func (s SqliteIndexer) Index(doc domain.ScrapedDoc) error {
+	content := searchableContent(doc)
+	doc.Content = ""
+	stored, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("could not index scraped doc: %w", err)
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM "+ftsTable+" WHERE id = ?", doc.ID).Error; err != nil {
+			return err
+		}
+		return tx.Exec(
+			"INSERT INTO "+ftsTable+" (id, title, description, content, url, doc) VALUES (?, ?, ?, ?, ?, ?)",
+			doc.ID, doc.Title, doc.Description, content, doc.URL, string(stored),
+		).Error
+	})
+	if err != nil {
+		return fmt.Errorf("could not index scraped doc: %w", err)
+	}
+	log.Printf("indexed %s\n", doc.URL)
+	return nil
+}
+
+func (s SqliteIndexer) Delete(doc domain.ScrapedDoc) error {
+	if err := s.db.Exec("DELETE FROM "+ftsTable+" WHERE id = ?", doc.ID).Error; err != nil {
+		return fmt.Errorf("could not delete scraped doc: %w", err)
+	}
+	log.Printf("deleted %s\n", doc.ID)
+	return nil
+}
+
+func (s SqliteIndexer) DeleteBatch(ids []string) error {
+	if err := s.db.Exec("DELETE FROM "+ftsTable+" WHERE id IN ?", ids).Error; err != nil {
+		return fmt.Errorf("could not delete scraped docs: %w", err)
+	}
+	log.Printf("deleted %d docs\n", len(ids))
+	return nil
+}
+
+// ftsRow is a matching row with its highlighted fields
+type ftsRow struct {
+	Doc         string
+	Title       string
+	Description string
+	Content     string
+	URL         string
+}
+
+// facetCount is the number of documents with a value of a field
+type facetCount struct {
+	Value string
+	Count int64
+}
+
+func (s SqliteIndexer) Search(ctx context.Context, req SearchRequest) (SearchResult, error) {
+	req, parsed, err := req.parse()
+	if err != nil {
+		return SearchResult{}, err
+	}
+	result := SearchResult{
+		Hits:   []Hit{},
+		Offset: req.Offset,
+		Limit:  req.Limit,
+		Query:  req.Query,
+	}
+	tx := s.db.WithContext(ctx)
+
+	match := ftsMatch(parsed.Text, parsed.Phrases, s.tuning)
+	where, args := ftsWhere(match, req.Filters)
+	if err := tx.Raw("SELECT count(*) FROM "+ftsTable+" WHERE "+where, args...).Scan(&result.Total).Error; err != nil {
+		return SearchResult{}, fmt.Errorf("could not search: %w", err)
+	}
+
+	var order string
+	if field, desc := req.sortBy(); field != "" {
+		order = "json_extract(doc, '$." + field + "')"
+		if desc {
+			order += " DESC"
+		}
+		order += ", rowid DESC"
+	} else if match != "" {
+		order = ftsRank
+	} else {
+		// without terms, list the most recently indexed documents
+		order = "rowid DESC"
+	}
+
+	columns := "doc"
+	var columnArgs []interface{}
+	if match != "" {
+		pre, post := req.HighlightPreTag, req.HighlightPostTag
+		columns = `doc,
+			highlight(` + ftsTable + `, 1, ?, ?) AS title,
+			highlight(` + ftsTable + `, 2, ?, ?) AS description,
+			snippet(` + ftsTable + `, 3, ?, ?, '…', ?) AS content,
+			highlight(` + ftsTable + `, 4, ?, ?) AS url`
+		columnArgs = []interface{}{pre, post, pre, post, pre, post, snippetWords, pre, post}
+	}
+	var rows []ftsRow
+	queryArgs := append(append(columnArgs, args...), req.Limit, req.Offset)
+	err = tx.Raw(
+		"SELECT "+columns+" FROM "+ftsTable+" WHERE "+where+" ORDER BY "+order+" LIMIT ? OFFSET ?",
+		queryArgs...,
+	).Scan(&rows).Error
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("could not search: %w", err)
+	}
+
+	for _, row := range rows {
+		var doc domain.ScrapedDoc
+		if err := json.Unmarshal([]byte(row.Doc), &doc); err != nil {
+			return SearchResult{}, fmt.Errorf("could not read hit: %w", err)
+		}
+		result.Hits = append(result.Hits, Hit{
+			ScrapedDoc: doc,
+			Highlights: highlighted(map[string]string{
+				"title":       row.Title,
+				"description": row.Description,
+				"content":     row.Content,
+				"url":         row.URL,
+			}, req.HighlightPreTag),
+		})
+	}
+
+	for _, facet := range req.Facets {
+		var counts []facetCount
+		var facetQuery string
+		if contains(listFields, facet) {
+			facetQuery = "SELECT t.value AS value, count(*) AS count FROM " + ftsTable +
+				", json_each(" + ftsTable + ".doc, '$." + facet + "') AS t WHERE " + where + " GROUP BY t.value"
+		} else {
+			facetQuery = "SELECT json_extract(doc, '$." + facet + "') AS value, count(*) AS count FROM " +
+				ftsTable + " WHERE " + where + " GROUP BY value"
+		}
+		facetQuery = "SELECT value, count FROM (" + facetQuery + ") WHERE value IS NOT NULL AND value != ''" +
+			" ORDER BY count DESC, value LIMIT ?"
+		if err := tx.Raw(facetQuery, append(args, maxFacetValues)...).Scan(&counts).Error; err != nil {
+			return SearchResult{}, fmt.Errorf("could not count %s facet: %w", facet, err)
+		}
+		if result.Facets == nil {
+			result.Facets = make(map[string]map[string]int64)
+		}
+		result.Facets[facet] = make(map[string]int64, len(counts))
+		for _, count := range counts {
+			result.Facets[facet][count.Value] = count.Count
+		}
+	}
+	return result, nil
+}
+
+// ftsWhere builds the condition for rows matching an FTS5 query, which may
+// be empty, and the filters. Filters are checked against the stored doc.
+func ftsWhere(match string, f Filters) (string, []interface{}) {
+	clauses := []string{"1 = 1"}
+	var args []interface{}
+	if match != "" {
+		clauses = append(clauses, ftsTable+" MATCH ?")
+		args = append(args, match)
+	}
+	anyOf := func(field string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		clauses = append(clauses, "json_extract(doc, '$."+field+"') IN ("+placeholders+")")
+		for _, value := range values {
+			args = append(args, value)
+		}
+	}
+	anyOf(DocTypeField, f.docTypes())
+	anyOf(DomainField, f.Domains)
+	anyOf(StatusField, f.statuses())
+	for _, tag := range f.Tags {
+		clauses = append(clauses, "EXISTS (SELECT 1 FROM json_each(doc, '$.tags') WHERE json_each.value = ?)")
+		args = append(args, tag)
+	}
+	if len(f.Collections) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(f.Collections)), ", ")
+		clauses = append(clauses, "EXISTS (SELECT 1 FROM json_each(doc, '$.collections') WHERE json_each.value IN ("+placeholders+"))")
+		for _, collection := range f.Collections {
+			args = append(args, collection)
+		}
+	}
+	if f.After != nil {
+		clauses = append(clauses, "json_extract(doc, '$.created_at') >= ?")
+		args = append(args, time.Time(*f.After).Unix())
+	}
+	if f.Before != nil {
+		clauses = append(clauses, "json_extract(doc, '$.created_at') < ?")
+		args = append(args, time.Time(*f.Before).Unix())
+	}
+	if f.Favourite != nil {
+		clauses = append(clauses, "coalesce(json_extract(doc, '$."+FavouriteField+"'), 0) = ?")
+		args = append(args, *f.Favourite)
+	}
+	if f.Scraped != nil {
+		clauses = append(clauses, "json_extract(doc, '$."+ScrapedField+"') = ?")
+		args = append(args, *f.Scraped)
+	}
+	if f.Owner != "" {
+		clauses = append(clauses, "(json_extract(doc, '$."+OwnerField+"') = ? OR json_extract(doc, '$."+SharedField+"') = 1)")
+		args = append(args, f.Owner)
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// ftsMatch turns a free text query and phrases into an FTS5 query matching
+// all of the words and phrases, so punctuation in queries can't cause
+// syntax errors. The last word matches as a prefix while it is being typed.
+// Words also match their synonyms and stop words are left out, unless the
+// query only has stop words. FTS5 has no typo tolerance.
+func ftsMatch(query string, phrases []string, tuning *queryTuning) string {
+	words := queryWords(query)
+	var kept []string
+	for _, word := range words {
+		if !tuning.isStopWord(word) {
+			kept = append(kept, word)
+		}
+	}
+	if len(kept) == 0 {
+		kept = words
+	}
+	prefix := false
+	if last, _ := utf8.DecodeLastRuneInString(query); len(kept) > 0 && (unicode.IsLetter(last) || unicode.IsNumber(last)) {
+		prefix = kept[len(kept)-1] == words[len(words)-1]
+	}
+	terms := make([]string, 0, len(kept)+len(phrases))
+	for i, word := range kept {
+		alternatives := tuning.alternatives(word)
+		for j, alternative := range alternatives {
+			alternatives[j] = ftsPhrase(alternative)
+		}
+		if prefix && i == len(kept)-1 {
+			alternatives[0] += "*"
+		}
+		if len(alternatives) == 1 {
+			terms = append(terms, alternatives[0])
+		} else {
+			terms = append(terms, "("+strings.Join(alternatives, " OR ")+")")
+		}
+	}
+	for _, phrase := range phrases {
+		if phraseWords := queryWords(phrase); len(phraseWords) > 0 {
+			terms = append(terms, ftsPhrase(phrase))
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// ftsPhrase quotes the words of s as an FTS5 phrase
+func ftsPhrase(s string) string {
+	return `"` + strings.Join(queryWords(s), " ") + `"`
+}
+
+// ApplySettings uses the synonyms and stop words in queries
+func (s SqliteIndexer) ApplySettings(settings domain.SearchSettings) error {
+	s.tuning.set(settings)
+	return nil
+}
@@ -0,0 +1,98 @@
+package indexer
+
+import (
+	"context"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"zeno/domain"
+)
+
+func newTestSqliteIndexer(t *testing.T) SqliteIndexer {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSqliteIndexer(db)
+	if err != nil {
+		t.Skip("sqlite built without FTS5, run just test or go test -tags sqlite_fts5:", err)
+	}
+	return s
+}
+
+func TestSqliteIndexer(t *testing.T) {
+	s := newTestSqliteIndexer(t)
+	docs := []domain.ScrapedDoc{
+		{ID: "1", Title: "Gardening tips", Content: "Water the tomatoes every morning", URL: "https://a.example/garden"},
+		{ID: "2", Title: "Tomato soup", Description: "A simple recipe", Content: "Blend roasted tomatoes", URL: "https://b.example/soup"},
+		{ID: "3", Title: "Go generics", Content: "Type parameters in Go", URL: "https://c.example/go"},
+	}
+	for _, doc := range docs {
+		if err := s.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// reindexing replaces the document
+	docs[2].Title = "Go generics explained"
+	if err := s.Index(docs[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		ids   []string
+	}{
+		{name: "empty lists recent", query: "", ids: []string{"3", "2", "1"}},
+		{name: "title ranks first", query: "tomato", ids: []string{"2", "1"}},
+		{name: "prefix", query: "gen", ids: []string{"3"}},
+		{name: "all words", query: "roasted tomatoes", ids: []string{"2"}},
+		{name: "punctuation", query: `"go" (`, ids: []string{"3"}},
+		{name: "no match", query: "kubernetes", ids: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := s.Search(context.Background(), SearchRequest{Query: tt.query})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, hit := range result.Hits {
+				ids = append(ids, hit.ID)
+				if hit.Content != "" {
+					t.Errorf("hit %s has content", hit.ID)
+				}
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.ids, ",") {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, ids, tt.ids)
+			}
+			if result.Total != int64(len(tt.ids)) {
+				t.Errorf("Search(%q) total = %d, want %d", tt.query, result.Total, len(tt.ids))
+			}
+		})
+	}
+
+	result, err := s.Search(context.Background(), SearchRequest{Query: "roasted", HighlightPreTag: "[", HighlightPostTag: "]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Hits[0].Highlights["content"]; got != "Blend [roasted] tomatoes" {
+		t.Errorf("content highlight = %q", got)
+	}
+	if _, ok := result.Hits[0].Highlights["title"]; ok {
+		t.Error("title should not be highlighted without a match")
+	}
+
+	if err := s.Delete(docs[1]); err != nil {
+		t.Fatal(err)
+	}
+	result, err = s.Search(context.Background(), SearchRequest{Query: "soup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Hits) != 0 {
+		t.Errorf("deleted doc still found: %v", result.Hits)
+	}
+}
@@ -0,0 +1,106 @@
+package indexer
+
+import (
+	"fmt"
+	"github.com/meilisearch/meilisearch-go"
+	"sync"
+	"time"
+	"zeno/domain"
+)
+
+// SearchTokenDuration is how long a search token lasts. Tokens can't be
+// revoked, so they are kept short and the UI fetches new ones.
+const SearchTokenDuration = time.Hour
+
+// searchKeyName names the search server key tokens are signed with
+const searchKeyName = "zeno search"
+
+// SearchToken lets the browser search the documents index without the
+// key the search server was started with. An empty token means the search
+// server doesn't need one.
+type SearchToken struct {
+	Token     string            `json:"token"`
+	ExpiresAt *domain.Timestamp `json:"expires_at,omitempty"`
+}
+
+// TokenMinter mints search only tenant tokens. They are signed with a key
+// that can only search the documents index, created on the search server
+// the first time a token is minted.
+type TokenMinter struct {
+	client *meilisearch.Client
+	mu     sync.Mutex
+	key    *meilisearch.Key
+}
+
+func NewTokenMinter(client *meilisearch.Client) *TokenMinter {
+	if client == nil {
+		panic("client cannot be nil")
+	}
+	return &TokenMinter{
+		client: client,
+	}
+}
+
+// searchKey finds the key tokens are signed with, creating it if it
+// doesn't exist yet
+func (t *TokenMinter) searchKey() (meilisearch.Key, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.key != nil {
+		return *t.key, nil
+	}
+	keys, err := t.client.GetKeys(&meilisearch.KeysQuery{Limit: 1000})
+	if err != nil {
+		return meilisearch.Key{}, fmt.Errorf("could not fetch search keys: %w", err)
+	}
+	for i := range keys.Results {
+		key := keys.Results[i]
+		if key.Name == searchKeyName && key.ExpiresAt.IsZero() &&
+			len(key.Actions) == 1 && key.Actions[0] == "search" &&
+			len(key.Indexes) == 1 && key.Indexes[0] == IndexName {
+			t.key = &key
+			return key, nil
+		}
+	}
+	created, err := t.client.CreateKey(&meilisearch.Key{
+		Name:        searchKeyName,
+		Description: "signs the search tokens zeno hands to browsers",
+		Actions:     []string{"search"},
+		Indexes:     []string{IndexName},
+	})
+	if err != nil {
+		return meilisearch.Key{}, fmt.Errorf("could not create search key: %w", err)
+	}
+	t.key = created
+	return *created, nil
+}
+
+// Mint returns a token that can search the documents index until it
+// expires. If filter isn't empty every search with the token is limited
+// to the documents matching it.
+func (t *TokenMinter) Mint(filter string) (SearchToken, error) {
+	rule := map[string]interface{}{}
+	if filter != "" {
+		// check the filter here, searches with a token holding an invalid
+		// filter would all fail
+		if _, err := t.client.Index(IndexName).Search("", &meilisearch.SearchRequest{Filter: filter, Limit: 1}); err != nil {
+			return SearchToken{}, fmt.Errorf("%w: invalid filter %q: %s", InvalidSearch, filter, err)
+		}
+		rule["filter"] = filter
+	}
+	key, err := t.searchKey()
+	if err != nil {
+		return SearchToken{}, err
+	}
+	expiresAt := time.Now().Add(SearchTokenDuration).UTC().Truncate(time.Second)
+	token, err := t.client.GenerateTenantToken(
+		key.UID,
+		map[string]interface{}{IndexName: rule},
+		&meilisearch.TenantTokenOptions{APIKey: key.Key, ExpiresAt: expiresAt},
+	)
+	if err != nil {
+		return SearchToken{}, fmt.Errorf("could not generate search token: %w", err)
+	}
+	timestamp := domain.Timestamp(expiresAt)
+	return SearchToken{Token: token, ExpiresAt: &timestamp}, nil
+}
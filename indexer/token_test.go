@@ -0,0 +1,93 @@
+package indexer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTokenMinter(t *testing.T) {
+	created := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/keys":
+			_, _ = w.Write([]byte(`{"results":[{"name":"other","uid":"3c5ea2bd-3ef2-4a2f-8ad7-07e7bd8c2a77","key":"other","actions":["*"],"indexes":["*"],"expiresAt":null}]}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/keys":
+			created++
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"name":"zeno search","uid":"6062abda-a5aa-4414-ac91-ecd7944c0f8d","key":"search-key","actions":["search"],"indexes":["sites"],"expiresAt":null}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/indexes/sites/search":
+			var body struct {
+				Filter string `json:"filter"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.Filter != "domain = \"go.dev\"" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"message":"invalid filter","code":"invalid_filter","type":"invalid_request"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"hits":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	minter := NewTokenMinter(MakeMeilisearchClient(server.URL, "master"))
+
+	token, err := minter.Mint("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ExpiresAt == nil {
+		t.Error("token has no expiry")
+	}
+	claims := tokenClaims(t, token.Token)
+	if claims.ApiKeyUid != "6062abda-a5aa-4414-ac91-ecd7944c0f8d" {
+		t.Errorf("token signed by %q, want the search key", claims.ApiKeyUid)
+	}
+	if rule, ok := claims.SearchRules[IndexName]; !ok || len(rule) != 0 {
+		t.Errorf("search rules = %v, want an unrestricted rule for %s", claims.SearchRules, IndexName)
+	}
+
+	token, err = minter.Mint(`domain = "go.dev"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims = tokenClaims(t, token.Token)
+	if filter := claims.SearchRules[IndexName]["filter"]; filter != `domain = "go.dev"` {
+		t.Errorf("token filter = %v", filter)
+	}
+	if created != 1 {
+		t.Errorf("search key created %d times, want once", created)
+	}
+
+	if _, err := minter.Mint("nope = "); !errors.Is(err, InvalidSearch) {
+		t.Errorf("Mint() with invalid filter error = %v, want InvalidSearch", err)
+	}
+}
+
+type testClaims struct {
+	ApiKeyUid   string                            `json:"apiKeyUid"`
+	SearchRules map[string]map[string]interface{} `json:"searchRules"`
+}
+
+func tokenClaims(t *testing.T, token string) testClaims {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token %q is not a JWT", token)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims testClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -10,14 +12,43 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"time"
+	"zeno/auth"
+	"zeno/backup"
 	"zeno/db"
+	"zeno/files"
 	"zeno/indexer"
 	"zeno/scraper"
+	"zeno/watcher"
 )
 
+// defaultTrashRetention is how long deleted documents are kept in the
+// trash by default
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPurgeInterval is how often documents past their time in the trash
+// are purged
+const trashPurgeInterval = time.Hour
+
 func main() {
-	var searchPath, meiliDataPath, searchAddr, dsn, addr string
+	var searchPath, meiliDataPath, searchAddr, dsn, addr, filesDir, watchDirs, dumpsDir, searchBackend, blevePath, proxyAllow string
 	var dev bool
+	var trashRetention time.Duration
+	flag.StringVar(
+		&searchBackend,
+		"search",
+		indexer.MeilisearchBackend,
+		fmt.Sprintf(
+			"search backend, one of %s, %s or %s",
+			indexer.MeilisearchBackend, indexer.SqliteBackend, indexer.BleveBackend,
+		),
+	)
+	flag.StringVar(
+		&blevePath,
+		"bleve",
+		"./zeno.bleve",
+		"Where the bleve search backend stores its index",
+	)
 	flag.StringVar(
 		&searchPath,
 		"cmd",
@@ -30,6 +61,12 @@ func main() {
 		"./meili_data",
 		"Where the search binary will store data",
 	)
+	flag.StringVar(
+		&dumpsDir,
+		"dumps",
+		"./meili_dumps",
+		"Where the search binary will write dumps for backups",
+	)
 	flag.StringVar(
 		&searchAddr,
 		"search-addr",
@@ -42,12 +79,36 @@ func main() {
 		"zeno.db",
 		"dsn for the document db",
 	)
+	flag.StringVar(
+		&filesDir,
+		"files",
+		"./zeno_files",
+		"Where uploaded files are stored",
+	)
+	flag.StringVar(
+		&watchDirs,
+		"watch",
+		"",
+		"comma separated directories whose files are indexed as they change",
+	)
+	flag.StringVar(
+		&proxyAllow,
+		"proxy-allow",
+		"",
+		"comma separated extra search server routes to proxy besides search, e.g. \"GET /indexes/sites/settings,/tasks*\"",
+	)
 	flag.StringVar(
 		&addr,
 		"addr",
 		":8080",
 		"address to use to start server",
 	)
+	flag.DurationVar(
+		&trashRetention,
+		"trash-retention",
+		defaultTrashRetention,
+		"how long deleted documents stay in the trash before they are purged, 0 keeps them",
+	)
 	flag.BoolVar(
 		&dev,
 		"dev",
@@ -55,14 +116,21 @@ func main() {
 		"dev env",
 	)
 
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), usage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nflags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	_, dev = os.LookupEnv("ZENO_DEV")
 
 	log.Println("db path:", dsn)
+	log.Println("search backend:", searchBackend)
 	log.Println("meili data path:", meiliDataPath)
 	log.Println("search address:", searchAddr)
 	log.Println("search executable:", searchPath)
+	log.Println("files path:", filesDir)
 
 	if dev {
 		log.Println("starting in dev mode")
@@ -72,50 +140,275 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 
-	apiKey := os.Getenv(indexer.ZenoKeyEnv)
+	searchHost := indexer.MeiliHost()
+	command := flag.Arg(0)
+	var dumpPath string
+	if command == restoreCommandName {
+		if searchBackend == indexer.MeilisearchBackend && indexer.MakeMeilisearchClient(searchHost, "").IsHealthy() {
+			log.Printf("a search server is running at %s, stop zeno before restoring\n", searchHost)
+			os.Exit(1)
+		}
+		// the backup has to be in place before the db is opened and the
+		// search server starts
+		var restoreErr error
+		dumpPath, restoreErr = restoreCommand(flag.Args(), dsn, meiliDataPath, blevePath, filesDir, dumpsDir)
+		if restoreErr != nil {
+			log.Println("could not restore backup:", restoreErr)
+			os.Exit(1)
+		}
+	}
 
-	index, healthCheck := indexer.MakeMeilisearchIndex(indexer.SearchUrl, apiKey)
-	mIndexer := indexer.NewMeilisearchIndexer(index)
+	mux := http.NewServeMux()
+	repo := db.NewGormRepo(dsn)
+	searchSettings, settingsErr := repo.GetSearchSettings(context.Background())
+	if settingsErr != nil {
+		log.Println("could not read search settings:", settingsErr)
+		os.Exit(1)
+	}
+	store := files.NewStore(filesDir)
+	backupSources := backup.Sources{
+		DB:       repo,
+		FilesDir: store.Dir(),
+	}
 
-	spm := indexer.NewSearchProcessManager(
-		searchPath,
-		meiliDataPath,
-		searchAddr,
-		apiKey,
-		healthCheck,
-		sigChan,
-	)
-	if err := spm.Start(); err != nil {
-		log.Println("could not start search:", err)
+	var searchIndex indexer.Backend
+	var spm *indexer.SearchProcessManager
+	var healthCheck func() bool
+	var tokens *indexer.TokenMinter
+	switch searchBackend {
+	case indexer.MeilisearchBackend:
+		apiKey := os.Getenv(indexer.ZenoKeyEnv)
+		client := indexer.MakeMeilisearchClient(searchHost, apiKey)
+		healthCheck = client.IsHealthy
+		meiliIndexer := indexer.NewMeilisearchIndexer(client.Index(indexer.IndexName))
+		searchIndex = meiliIndexer
+		if apiKey != "" {
+			tokens = indexer.NewTokenMinter(client)
+		}
+		backupSources.Search = indexer.NewMeilisearchDumper(client, dumpsDir)
+		if command != "" && command != restoreCommandName {
+			// a second search server would share the data of the one zeno
+			// is serving with, so commands use that one
+			if searchCommands[command] && !client.IsHealthy() {
+				log.Printf("no search server is running at %s, start zeno or set %s\n", searchHost, indexer.MeiliHostEnv)
+				os.Exit(1)
+			}
+			break
+		}
+
+		meiliSpm := indexer.NewSearchProcessManager(
+			searchPath,
+			meiliDataPath,
+			dumpsDir,
+			searchAddr,
+			apiKey,
+			healthCheck,
+			sigChan,
+		)
+		spm = &meiliSpm
+		if dumpPath != "" {
+			spm.ImportDump(dumpPath)
+		}
+		if err := spm.Start(); err != nil {
+			log.Println("could not start search:", err)
+			os.Exit(1)
+		}
+		log.Println("started search server")
+		go func() {
+			if !waitHealthy(healthCheck, searchStartTimeout) {
+				return
+			}
+			if err := meiliIndexer.ConfigureIndex(searchSettings); err != nil {
+				log.Println("could not configure search index:", err)
+			}
+		}()
+	case indexer.SqliteBackend:
+		sqliteIndexer, err := indexer.NewSqliteIndexer(repo.DB())
+		if err != nil {
+			log.Println("could not set up search:", err)
+			os.Exit(1)
+		}
+		if err := sqliteIndexer.ApplySettings(searchSettings); err != nil {
+			log.Println("could not apply search settings:", err)
+			os.Exit(1)
+		}
+		searchIndex = sqliteIndexer
+	case indexer.BleveBackend:
+		bleveIndexer, err := indexer.NewBleveIndexer(blevePath)
+		if err != nil {
+			log.Println("could not set up search:", err)
+			os.Exit(1)
+		}
+		if err := bleveIndexer.ApplySettings(searchSettings); err != nil {
+			log.Println("could not apply search settings:", err)
+			os.Exit(1)
+		}
+		searchIndex = bleveIndexer
+	default:
+		log.Printf("unknown search backend %q\n", searchBackend)
 		os.Exit(1)
 	}
-	log.Println("started search server")
 
-	mux := http.NewServeMux()
-	repo := db.NewGormRepo(dsn)
-	collyScraper := scraper.NewCollyScraper(mIndexer, repo)
+	collyScraper := scraper.NewCollyScraper(searchIndex, repo)
 
-	MakeRoutes(collyScraper, mux, repo)
+	keyCount, countErr := repo.CountApiKeys(context.Background())
+	if countErr != nil {
+		log.Println("could not read api keys:", countErr)
+		os.Exit(1)
+	}
+	userCount, countErr := repo.CountUsers(context.Background())
+	if countErr != nil {
+		log.Println("could not read users:", countErr)
+		os.Exit(1)
+	}
+	masterKey := os.Getenv(indexer.ZenoKeyEnv)
+	authenticator := auth.NewAuthenticator(repo, masterKey, masterKey != "" || keyCount > 0 || userCount > 0)
+	if !authenticator.Required() {
+		log.Printf("no %s, api keys or users set, zeno's endpoints are open to anyone\n", indexer.ZenoKeyEnv)
+	}
+	if userCount > 0 && searchBackend == indexer.MeilisearchBackend && masterKey == "" {
+		log.Printf("without %s the search server hands every user's documents to anyone\n", indexer.ZenoKeyEnv)
+	}
 
-	searchUrl, _ := url.Parse(indexer.SearchUrl)
-	rp := httputil.NewSingleHostReverseProxy(searchUrl)
-	srv := http.Server{Addr: addr, Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		// log request
-		if request.URL.String() != "/indexes/sites/search" {
-			// avoid spamming logs with instant search client refresh requests
-			log.Printf("url: %s, method: %s, uri: %s", request.URL, request.Method, request.URL.RequestURI())
+	MakeRoutes(collyScraper, mux, repo, store, backupSources, searchIndex, searchBackend, tokens, authenticator)
+
+	var dirWatcher *watcher.DirWatcher
+	exitCode := 0
+	if command != "" {
+		timeout := searchStartTimeout
+		if command == restoreCommandName {
+			// importing a dump happens before the search server is healthy
+			timeout = dumpImportTimeout
+		}
+		if spm != nil && !waitHealthy(healthCheck, timeout) {
+			log.Println("search server did not become healthy")
+			os.Exit(1)
+		}
+		if cmdErr := RunCommand(flag.Args(), collyScraper, repo, backupSources, searchIndex, searchBackend); cmdErr != nil {
+			log.Println("command failed:", cmdErr)
+			exitCode = 1
+		}
+	} else {
+		var proxy http.Handler
+		if searchBackend == indexer.MeilisearchBackend {
+			extraRoutes, routesErr := parseProxyRoutes(proxyAllow)
+			if routesErr != nil {
+				log.Println("could not set up search proxy:", routesErr)
+				os.Exit(1)
+			}
+			searchUrl, _ := url.Parse(searchHost)
+			proxy = newSearchProxy(httputil.NewSingleHostReverseProxy(searchUrl), extraRoutes)
+		}
+		if trashRetention > 0 {
+			go func() {
+				ticker := time.NewTicker(trashPurgeInterval)
+				defer ticker.Stop()
+				for ; true; <-ticker.C {
+					purgeTrash(collyScraper, repo, store, trashRetention)
+				}
+			}()
 		}
+		dirWatcher = serve(addr, watchDirs, mux, collyScraper, repo, proxy, sigChan)
+	}
 
-		// if the request was '/' or '/scrape', serve
-		if strings.HasPrefix(request.URL.String(), "/zeno") ||
-			request.URL.RequestURI() == "/" {
-			log.Println("handling request")
-			mux.ServeHTTP(writer, request)
-		} else {
-			// otherwise proxy request to search
-			rp.ServeHTTP(writer, request)
+	if dirWatcher != nil {
+		log.Println("stopping directory watcher")
+		if err := dirWatcher.Close(); err != nil {
+			log.Printf("directory watcher shutdown: %s\n", err)
+		}
+	}
+
+	// wait to finish scraping
+	log.Println("waiting for scraper to finish")
+	collyScraper.C.Wait()
+	log.Println("scraper finished")
+
+	if closer, ok := searchIndex.(io.Closer); ok {
+		log.Println("closing search index")
+		if err := closer.Close(); err != nil {
+			log.Printf("search index shutdown: %s\n", err)
+		}
+	}
+	if spm != nil {
+		// stop the index
+		log.Println("sending stop signal to search server")
+		if err := spm.Stop(); err != nil {
+			log.Printf("search server shutdown: %s\n", err)
+		}
+		// wait on the process
+		log.Println("waiting on search server process")
+		if err := spm.Wait(); err != nil {
+			log.Printf("search server shutdown: %s\n", err)
+		}
+		log.Println("search server shutdown")
+	}
+	os.Exit(exitCode)
+}
+
+// purgeTrash permanently deletes the documents that have been in the
+// trash for longer than retention, along with the uploaded files no other
+// document is made from
+func purgeTrash(s scraper.Scraper, repo db.GormRepo, store files.Store, retention time.Duration) {
+	ids, err := repo.TrashedBefore(context.Background(), time.Now().Add(-retention))
+	if err != nil {
+		log.Println("could not list trash:", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+	if err := s.Purge(ids); err != nil {
+		log.Println("could not purge trash:", err)
+		return
+	}
+	log.Printf("purged %d docs from the trash\n", len(ids))
+
+	unused, err := repo.UnusedFiles(context.Background(), filesPath)
+	if err != nil {
+		log.Println("could not list unused files:", err)
+		return
+	}
+	for _, hash := range unused {
+		// the file goes first, so a failure leaves a description to retry
+		// with next time
+		if err := store.Remove(hash); err != nil {
+			log.Println("could not remove file:", err)
+			continue
+		}
+		if err := repo.DeleteFile(context.Background(), hash); err != nil {
+			log.Println("could not delete file:", err)
 		}
-	})}
+	}
+	if len(unused) > 0 {
+		log.Printf("removed %d unused files\n", len(unused))
+	}
+}
+
+// serve starts watching directories and serves requests until a signal is
+// received. Requests outside of zeno's routes go to proxy, if there is one.
+func serve(
+	addr, watchDirs string,
+	mux *http.ServeMux,
+	collyScraper scraper.CollyScraper,
+	repo db.GormRepo,
+	proxy http.Handler,
+	sigChan chan os.Signal,
+) *watcher.DirWatcher {
+	var dirWatcher *watcher.DirWatcher
+	if watchDirs != "" {
+		var watchErr error
+		dirWatcher, watchErr = watcher.NewDirWatcher(collyScraper, repo, strings.Split(watchDirs, ","))
+		if watchErr == nil {
+			watchErr = dirWatcher.Start()
+		}
+		if watchErr != nil {
+			log.Println("could not watch directories:", watchErr)
+			os.Exit(1)
+		}
+		log.Println("watching directories:", watchDirs)
+	}
+
+	srv := http.Server{Addr: addr, Handler: newHandler(mux, proxy)}
 
 	// start http server
 	go func() {
@@ -137,20 +430,29 @@ func main() {
 	}
 	log.Println("server shutdown")
 
-	// wait to finish scraping
-	log.Println("waiting for scraper to finish")
-	collyScraper.C.Wait()
-	log.Println("scraper finished")
+	return dirWatcher
+}
 
-	// stop the index
-	log.Println("sending stop signal to search server")
-	if err := spm.Stop(); err != nil {
-		log.Printf("search server shutdown: %s\n", err)
-	}
-	// wait on the process
-	log.Println("waiting on search server process")
-	if err := spm.Wait(); err != nil {
-		log.Printf("search server shutdown: %s\n", err)
-	}
-	log.Println("search server shutdown")
+// newHandler serves zeno's routes and pages from mux, and proxies every
+// other request to the search server
+func newHandler(mux *http.ServeMux, proxy http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// log request
+		if request.URL.String() != "/indexes/sites/search" && request.URL.Path != "/zeno/search" {
+			// avoid spamming logs with instant search client refresh requests
+			log.Printf("url: %s, method: %s, uri: %s", request.URL, request.Method, request.URL.RequestURI())
+		}
+
+		// if the request was '/' or '/scrape', serve
+		if strings.HasPrefix(request.URL.String(), "/zeno") ||
+			request.URL.RequestURI() == "/" {
+			log.Println("handling request")
+			mux.ServeHTTP(writer, request)
+		} else if proxy == nil {
+			http.NotFound(writer, request)
+		} else {
+			// otherwise proxy request to search
+			proxy.ServeHTTP(writer, request)
+		}
+	})
 }
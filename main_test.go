@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"zeno/domain"
+	"zeno/indexer"
+)
+
+func TestPurgeTrash(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	alice := server.key(t, "alice", domain.WriteScope)
+	bob := server.key(t, "bob", domain.WriteScope)
+	shared := server.upload(t, alice, "shared.txt", "text/plain", "both users uploaded this")
+	server.upload(t, bob, "shared.txt", "text/plain", "both users uploaded this")
+	own := server.upload(t, testMasterKey, "own.txt", "text/plain", "only the admin uploaded this")
+
+	ctx := context.Background()
+	trash := func(owner, hash string) {
+		docs, err := server.repo.FindByURL(ctx, filesPath+hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			if doc.Owner == owner {
+				if _, err := server.scraper.DeleteBatch([]string{doc.ID}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	trash("alice", shared)
+	trash("", own)
+	purgeTrash(server.scraper, server.repo, server.store, 0)
+
+	for hash, kept := range map[string]bool{shared: true, own: false} {
+		if _, err := server.repo.GetFile(ctx, hash); (err == nil) != kept {
+			t.Errorf("file %s description kept = %v, want %v", hash, err == nil, kept)
+		}
+		f, err := server.store.Open(hash)
+		if err == nil {
+			_ = f.Close()
+		}
+		if (err == nil) != kept {
+			t.Errorf("file %s kept = %v, want %v", hash, err == nil, kept)
+		}
+	}
+	if response := server.do(http.MethodGet, filesPath+shared, bob, ""); response.Code != http.StatusOK {
+		t.Errorf("GET the file bob still has = %d", response.Code)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"zeno/indexer"
+)
+
+// maxMultiSearchBytes limits the multi-search bodies read to check which
+// indexes they search
+const maxMultiSearchBytes = 1 << 20
+
+// proxyRoute is a request the search proxy lets through. Paths ending in
+// * match any path starting with the rest of the path, and an empty
+// method matches any method. check, if set, inspects the request further.
+type proxyRoute struct {
+	method string
+	path   string
+	check  func(*http.Request) error
+}
+
+func (r proxyRoute) matches(request *http.Request) bool {
+	if r.method != "" && r.method != request.Method {
+		return false
+	}
+	if prefix := strings.TrimSuffix(r.path, "*"); prefix != r.path {
+		return strings.HasPrefix(request.URL.Path, prefix)
+	}
+	return request.URL.Path == r.path
+}
+
+// searchRoutes are the search server routes the UI needs, searching the
+// documents index and nothing else
+var searchRoutes = []proxyRoute{
+	{method: http.MethodGet, path: "/indexes/" + indexer.IndexName + "/search", check: rewriteSearchQuery},
+	{method: http.MethodPost, path: "/indexes/" + indexer.IndexName + "/search", check: rewriteSearch},
+	{method: http.MethodPost, path: "/multi-search", check: checkMultiSearch},
+}
+
+// parseProxyRoutes reads comma separated routes such as
+// "GET /indexes/sites/settings" or "/tasks*"
+func parseProxyRoutes(s string) ([]proxyRoute, error) {
+	var routes []proxyRoute
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Fields(entry)
+		var route proxyRoute
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			route.path = fields[0]
+		case 2:
+			route.method, route.path = strings.ToUpper(fields[0]), fields[1]
+		default:
+			return nil, fmt.Errorf("invalid proxy route %q, expected [METHOD] /path", entry)
+		}
+		if !strings.HasPrefix(route.path, "/") {
+			return nil, fmt.Errorf("invalid proxy route %q, paths must start with /", entry)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// readSearchBody reads a search request's body, leaving it to be read
+// again
+func readSearchBody(request *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(request.Body, maxMultiSearchBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMultiSearchBytes {
+		return nil, fmt.Errorf("search body too large")
+	}
+	setSearchBody(request, body)
+	return body, nil
+}
+
+func setSearchBody(request *http.Request, body []byte) {
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	request.ContentLength = int64(len(body))
+	request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// checkMultiSearch only allows multi-searches of the documents index and
+// rewrites the operators in their queries
+func checkMultiSearch(request *http.Request) error {
+	body, err := readSearchBody(request)
+	if err != nil {
+		return err
+	}
+
+	var multiSearch struct {
+		Queries []map[string]interface{} `json:"queries"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&multiSearch); err != nil {
+		return fmt.Errorf("invalid multi-search: %w", err)
+	}
+	rewritten := false
+	for _, q := range multiSearch.Queries {
+		if q["indexUid"] != indexer.IndexName {
+			return fmt.Errorf("multi-search of index %q is not allowed", q["indexUid"])
+		}
+		changed, err := rewriteSearchParams(q)
+		if err != nil {
+			return err
+		}
+		rewritten = rewritten || changed
+	}
+	if !rewritten {
+		return nil
+	}
+	// other fields of the body are kept as they are
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return fmt.Errorf("invalid multi-search: %w", err)
+	}
+	if fields["queries"], err = json.Marshal(multiSearch.Queries); err != nil {
+		return err
+	}
+	if body, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	setSearchBody(request, body)
+	return nil
+}
+
+// rewriteSearch rewrites the operators in the query of a search
+func rewriteSearch(request *http.Request) error {
+	body, err := readSearchBody(request)
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return err
+	}
+	var params map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&params); err != nil {
+		return fmt.Errorf("invalid search: %w", err)
+	}
+	changed, err := rewriteSearchParams(params)
+	if !changed || err != nil {
+		return err
+	}
+	if body, err = json.Marshal(params); err != nil {
+		return err
+	}
+	setSearchBody(request, body)
+	return nil
+}
+
+// rewriteSearchQuery rewrites the operators in the query of a search made
+// with query parameters
+func rewriteSearchQuery(request *http.Request) error {
+	values := request.URL.Query()
+	q, filter, err := indexer.MeiliQuery(values.Get("q"))
+	if err != nil || (q == values.Get("q") && filter == "") {
+		return err
+	}
+	values.Set("q", q)
+	if filter != "" {
+		if existing := values.Get("filter"); existing != "" {
+			filter = "(" + existing + ") AND " + filter
+		}
+		values.Set("filter", filter)
+	}
+	request.URL.RawQuery = values.Encode()
+	return nil
+}
+
+// rewriteSearchParams turns the operators in the q of search params into
+// a filter the search server understands, reporting whether it changed
+// the params. Filters given as a string are combined with the operators'
+// filter, filters given as an array get it as another element.
+func rewriteSearchParams(params map[string]interface{}) (bool, error) {
+	original, _ := params["q"].(string)
+	q, filter, err := indexer.MeiliQuery(original)
+	if err != nil || (q == original && filter == "") {
+		return false, err
+	}
+	params["q"] = q
+	if filter == "" {
+		return true, nil
+	}
+	switch existing := params["filter"].(type) {
+	case nil:
+		params["filter"] = filter
+	case string:
+		if existing == "" {
+			params["filter"] = filter
+		} else {
+			params["filter"] = "(" + existing + ") AND " + filter
+		}
+	case []interface{}:
+		params["filter"] = append(existing, filter)
+	default:
+		return false, fmt.Errorf("invalid search filter %v", existing)
+	}
+	return true, nil
+}
+
+// searchProxy forwards allowed requests to the search server and rejects
+// the rest, so settings, keys, documents and dumps aren't exposed
+type searchProxy struct {
+	routes []proxyRoute
+	next   http.Handler
+}
+
+// newSearchProxy allows the search routes and extraRoutes. extraRoutes
+// come first, so they can also allow search requests the checks reject.
+func newSearchProxy(next http.Handler, extraRoutes []proxyRoute) searchProxy {
+	return searchProxy{
+		routes: append(append([]proxyRoute{}, extraRoutes...), searchRoutes...),
+		next:   next,
+	}
+}
+
+func (p searchProxy) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	// paths are forwarded as is, so don't let dot segments climb out of an
+	// allowed path
+	if path.Clean(request.URL.Path) != request.URL.Path {
+		writer.WriteHeader(http.StatusBadRequest)
+		if _, err := writer.Write([]byte("invalid path")); err != nil {
+			log.Println("found error writing response bytes:", err)
+		}
+		return
+	}
+	for _, route := range p.routes {
+		if !route.matches(request) {
+			continue
+		}
+		if route.check != nil {
+			if checkErr := route.check(request); checkErr != nil {
+				log.Printf("rejected search request %s %s: %s\n", request.Method, request.URL.Path, checkErr)
+				status := http.StatusForbidden
+				if errors.Is(checkErr, indexer.InvalidSearch) {
+					status = http.StatusBadRequest
+				}
+				writer.WriteHeader(status)
+				if _, err := writer.Write([]byte(checkErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		}
+		p.next.ServeHTTP(writer, request)
+		return
+	}
+	log.Printf("rejected search request %s %s\n", request.Method, request.URL.Path)
+	writer.WriteHeader(http.StatusForbidden)
+	if _, err := writer.Write([]byte("route not allowed")); err != nil {
+		log.Println("found error writing response bytes:", err)
+	}
+}
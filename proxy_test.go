@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchProxy(t *testing.T) {
+	extra, err := parseProxyRoutes("GET /indexes/sites/settings, /tasks*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forwardedBody string
+	p := newSearchProxy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		forwardedBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}), extra)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		// forwarded is the body sent on when it is rewritten
+		forwarded string
+	}{
+		{name: "search", method: http.MethodPost, path: "/indexes/sites/search", body: `{"q":"go"}`, want: http.StatusOK},
+		{name: "search get", method: http.MethodGet, path: "/indexes/sites/search", want: http.StatusOK},
+		{name: "other index", method: http.MethodPost, path: "/indexes/other/search", want: http.StatusForbidden},
+		{name: "documents", method: http.MethodPost, path: "/indexes/sites/documents", want: http.StatusForbidden},
+		{name: "delete index", method: http.MethodDelete, path: "/indexes/sites", want: http.StatusForbidden},
+		{name: "keys", method: http.MethodGet, path: "/keys", want: http.StatusForbidden},
+		{name: "dumps", method: http.MethodPost, path: "/dumps", want: http.StatusForbidden},
+		{name: "dot segments", method: http.MethodGet, path: "/indexes/sites/search/../../../keys", want: http.StatusBadRequest},
+		{name: "multi search", method: http.MethodPost, path: "/multi-search",
+			body: `{"queries":[{"indexUid":"sites","q":"go"}]}`, want: http.StatusOK},
+		{name: "multi search other index", method: http.MethodPost, path: "/multi-search",
+			body: `{"queries":[{"indexUid":"sites"},{"indexUid":"secrets"}]}`, want: http.StatusForbidden},
+		{name: "search operators", method: http.MethodPost, path: "/indexes/sites/search",
+			body: `{"q":"go site:example.com","filter":["tags = go"],"limit":10}`, want: http.StatusOK,
+			forwarded: `{"filter":["tags = go","(domain = \"example.com\")"],"limit":10,"q":"go"}`},
+		{name: "search bad operator", method: http.MethodPost, path: "/indexes/sites/search",
+			body: `{"q":"type:docx"}`, want: http.StatusBadRequest},
+		{name: "multi search operators", method: http.MethodPost, path: "/multi-search",
+			body: `{"queries":[{"indexUid":"sites","q":"\"error handling\" is:unread","filter":"tags = go"}]}`, want: http.StatusOK,
+			forwarded: `{"queries":[{"filter":"(tags = go) AND (status = \"unread\")","indexUid":"sites","q":"\"error handling\""}]}`},
+		{name: "extra route", method: http.MethodGet, path: "/indexes/sites/settings", want: http.StatusOK},
+		{name: "extra route other method", method: http.MethodPatch, path: "/indexes/sites/settings", want: http.StatusForbidden},
+		{name: "extra prefix route", method: http.MethodGet, path: "/tasks/12", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwardedBody = ""
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			recorder := httptest.NewRecorder()
+			p.ServeHTTP(recorder, request)
+			if recorder.Code != tt.want {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, recorder.Code, tt.want)
+			}
+			want := tt.body
+			if tt.forwarded != "" {
+				want = tt.forwarded
+			}
+			if tt.want == http.StatusOK && forwardedBody != want {
+				t.Errorf("forwarded body = %q, want %q", forwardedBody, want)
+			}
+		})
+	}
+}
+
+func TestParseProxyRoutes(t *testing.T) {
+	for _, invalid := range []string{"GET indexes", "GET /a extra", "keys"} {
+		if _, err := parseProxyRoutes(invalid); err == nil {
+			t.Errorf("parseProxyRoutes(%q) should fail", invalid)
+		}
+	}
+	routes, err := parseProxyRoutes("")
+	if err != nil || len(routes) != 0 {
+		t.Errorf("parseProxyRoutes(\"\") = %v, %v", routes, err)
+	}
+}
@@ -1,17 +1,65 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
+	"zeno/auth"
+	"zeno/backup"
 	"zeno/db"
 	"zeno/domain"
+	"zeno/exporter"
+	"zeno/files"
+	"zeno/importer"
+	"zeno/indexer"
 	"zeno/scraper"
 )
 
-func MakeRoutes(s scraper.Scraper, mux *http.ServeMux, repo db.GormRepo) {
-	mux.HandleFunc("/zeno/scrape", func(writer http.ResponseWriter, request *http.Request) {
+// maxIngestBytes limits the size of pages pushed to /zeno/ingest
+const maxIngestBytes = 32 << 20
+
+// maxUploadBytes limits the size of files uploaded to /zeno/upload
+const maxUploadBytes = 128 << 20
+
+const filesPath = "/zeno/files/"
+
+// inlineFileTypes are the types of uploaded files shown in the browser
+// instead of downloaded
+var inlineFileTypes = map[string]bool{
+	"application/pdf": true,
+	"text/plain":      true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+}
+
+func MakeRoutes(
+	s scraper.Scraper,
+	mux *http.ServeMux,
+	repo db.GormRepo,
+	store files.Store,
+	backupSources backup.Sources,
+	searcher indexer.Searcher,
+	searchBackend string,
+	tokens *indexer.TokenMinter,
+	authenticator auth.Authenticator,
+) {
+	handle := func(pattern string, scope domain.Scope, handler http.HandlerFunc) {
+		mux.Handle(pattern, authenticator.Require(scope, handler))
+	}
+
+	handle("/zeno/scrape", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
 		log.Println("scraping doc")
 		if request.Method != http.MethodGet {
 			writer.WriteHeader(http.StatusMethodNotAllowed)
@@ -23,6 +71,7 @@ func MakeRoutes(s scraper.Scraper, mux *http.ServeMux, repo db.GormRepo) {
 		titleStr := query.Get("title")
 		scrapeStr := query.Get("scrape")
 		scrape, _ := strconv.ParseBool(scrapeStr)
+		shared, _ := strconv.ParseBool(query.Get("shared"))
 		descriptionStr := query.Get("description")
 		parsedUrl, parseErr := url.Parse(urlStr)
 		if parseErr != nil {
@@ -38,7 +87,11 @@ func MakeRoutes(s scraper.Scraper, mux *http.ServeMux, repo db.GormRepo) {
 			URL:         parsedUrl.String(),
 			Title:       titleStr,
 			Description: descriptionStr,
+			Tags:        query["tag"],
+			Collections: query["collection"],
 			Scrape:      scrape,
+			Owner:       auth.IdentityOf(request.Context()).UserID,
+			Shared:      shared,
 		}
 
 		if visitErr := s.Scrape(doc); visitErr != nil {
@@ -52,7 +105,7 @@ func MakeRoutes(s scraper.Scraper, mux *http.ServeMux, repo db.GormRepo) {
 		writer.WriteHeader(http.StatusAccepted)
 	})
 
-	mux.HandleFunc("/zeno/delete", func(writer http.ResponseWriter, request *http.Request) {
+	handle("/zeno/delete", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
 		log.Println("deleting doc")
 		if request.Method != http.MethodGet {
 			writer.WriteHeader(http.StatusMethodNotAllowed)
@@ -67,6 +120,15 @@ func MakeRoutes(s scraper.Scraper, mux *http.ServeMux, repo db.GormRepo) {
 		doc := domain.ScrapedDoc{
 			ID: idStr,
 		}
+		if userID := auth.IdentityOf(request.Context()).UserID; userID != "" {
+			if owned, getErr := repo.Get(request.Context(), doc); getErr != nil || !owned.EditableBy(userID) {
+				writer.WriteHeader(http.StatusNotFound)
+				if _, err := writer.Write([]byte("document not found")); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		}
 
 		if visitErr := s.Delete(doc); visitErr != nil {
 			writer.WriteHeader(http.StatusBadRequest)
@@ -79,5 +141,1229 @@ func MakeRoutes(s scraper.Scraper, mux *http.ServeMux, repo db.GormRepo) {
 		writer.WriteHeader(http.StatusOK)
 	})
 
+	handle("/zeno/delete/bulk", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("deleting docs")
+		query := request.URL.Query()
+		dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+		// only dry runs are safe to follow as links
+		if request.Method != http.MethodPost && request.Method != http.MethodDelete &&
+			!(request.Method == http.MethodGet && dryRun) {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		searchReq, parseErr := searchRequestFromQuery(query)
+		if parseErr == nil && searchReq.Query == "" && searchReq.Filters.Empty() {
+			parseErr = errors.New("q or a filter is required to delete documents")
+		}
+		if parseErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(parseErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		userID := auth.IdentityOf(request.Context()).UserID
+		docs, searchErr := editableDocs(request.Context(), repo, searcher, searchReq, userID)
+		if searchErr == nil {
+			// hidden duplicates aren't in the index, so they are found by
+			// the documents they duplicate
+			var duplicates []domain.ScrapedDoc
+			duplicates, searchErr = repo.DuplicatesOf(request.Context(), docIDs(docs))
+			for _, duplicate := range duplicates {
+				if duplicate.EditableBy(userID) {
+					docs = append(docs, duplicate)
+				}
+			}
+		}
+		if searchErr != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(searchErr, indexer.InvalidSearch) {
+				status = http.StatusBadRequest
+			}
+			writer.WriteHeader(status)
+			if _, err := writer.Write([]byte(searchErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		result := bulkDeleteResult{DryRun: dryRun, Total: len(docs), Documents: make([]deletedDoc, 0, len(docs))}
+		ids := docIDs(docs)
+		if !dryRun {
+			var deleteErr error
+			if result.Deleted, deleteErr = s.DeleteBatch(ids); deleteErr != nil {
+				log.Printf("deleted %d of %d docs: %s\n", result.Deleted, len(ids), deleteErr)
+				result.Error = deleteErr.Error()
+			}
+			docs = docs[:result.Deleted]
+		}
+		for _, doc := range docs {
+			result.Documents = append(result.Documents, deletedDoc{ID: doc.ID, URL: doc.URL, Title: doc.Title})
+		}
+		log.Printf("dry run: %v, matched: %d, deleted: %d\n", dryRun, result.Total, result.Deleted)
+		status := http.StatusOK
+		if result.Error != "" {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(writer, status, result)
+	})
+
+	handle("/zeno/trash", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		docs, listErr := repo.ListTrash(request.Context(), auth.IdentityOf(request.Context()).UserID)
+		if listErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(listErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		writeJSON(writer, http.StatusOK, docs)
+	})
+
+	handle("/zeno/trash/restore", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("restoring doc from trash")
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := request.URL.Query().Get("id")
+		log.Printf("id: %s\n", id)
+		doc, getErr := repo.GetTrashed(request.Context(), id)
+		if getErr != nil || !doc.EditableBy(auth.IdentityOf(request.Context()).UserID) {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("document not found in trash")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		restored, restoreErr := s.Untrash(doc.ID)
+		if restoreErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(restoreErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		writeJSON(writer, http.StatusOK, restored)
+	})
+
+	handle("/zeno/ingest", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("ingesting doc")
+		// there are no CORS headers, the bookmarklet hands pages to a zeno
+		// window that pushes them from zeno's own origin
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		request.Body = http.MaxBytesReader(writer, request.Body, maxIngestBytes)
+		if parseErr := request.ParseMultipartForm(maxIngestBytes); parseErr != nil &&
+			parseErr != http.ErrNotMultipart {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(parseErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		urlStr := request.FormValue("url")
+		htmlStr := request.FormValue("html")
+		log.Printf("ingest values: url: %s, html bytes: %d\n", urlStr, len(htmlStr))
+		if urlStr == "" || htmlStr == "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte("url and html are required")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		shared, _ := strconv.ParseBool(request.FormValue("shared"))
+		doc := domain.ScrapedDoc{
+			URL:         urlStr,
+			Title:       request.FormValue("title"),
+			Description: request.FormValue("description"),
+			Tags:        request.Form["tag"],
+			Collections: request.Form["collection"],
+			Owner:       auth.IdentityOf(request.Context()).UserID,
+			Shared:      shared,
+		}
+
+		if ingestErr := s.Ingest(doc, []byte(htmlStr)); ingestErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(ingestErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		writer.WriteHeader(http.StatusCreated)
+	})
+
+	handle("/zeno/upload", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("uploading file")
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		request.Body = http.MaxBytesReader(writer, request.Body, maxUploadBytes)
+		file, header, formErr := request.FormFile("file")
+		if formErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(formErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		defer file.Close()
+		log.Printf("file: %s, size: %d\n", header.Filename, header.Size)
+
+		if _, ok := scraper.DocTypeOfFile(header.Filename); !ok {
+			writer.WriteHeader(http.StatusUnsupportedMediaType)
+			if _, err := writer.Write([]byte(fmt.Sprintf("unsupported file type: %s", header.Filename))); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		body, readErr := io.ReadAll(file)
+		if readErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(readErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		// files uploaded before are kept when this upload fails
+		var uploaded bool
+		hash, putErr := store.Put(bytes.NewReader(body))
+		if putErr == nil {
+			_, getErr := repo.GetFile(request.Context(), hash)
+			uploaded = getErr != nil
+			putErr = repo.SaveFile(request.Context(), domain.StoredFile{
+				ID:          hash,
+				Name:        header.Filename,
+				ContentType: header.Header.Get("Content-Type"),
+				Size:        int64(len(body)),
+			})
+		}
+		if putErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(putErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		shared, _ := strconv.ParseBool(request.FormValue("shared"))
+		doc := domain.ScrapedDoc{
+			ID:          hash,
+			URL:         filesPath + hash,
+			Title:       request.FormValue("title"),
+			Description: request.FormValue("description"),
+			Tags:        request.Form["tag"],
+			Collections: request.Form["collection"],
+			Owner:       auth.IdentityOf(request.Context()).UserID,
+			Shared:      shared,
+		}
+		if doc.Owner != "" {
+			// users uploading the same file get documents of their own
+			doc.ID, _ = scraper.IdFor(doc.Owner, doc.URL)
+		}
+		if ingestErr := s.IngestFile(doc, header.Filename, body); ingestErr != nil {
+			if uploaded {
+				if err := store.Remove(hash); err != nil {
+					log.Println("could not remove file:", err)
+				} else if err := repo.DeleteFile(request.Context(), hash); err != nil {
+					log.Println("could not delete file:", err)
+				}
+			}
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(ingestErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		writeJSON(writer, http.StatusCreated, map[string]string{"id": doc.ID})
+	})
+
+	handle(filesPath, domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(request.URL.Path, filesPath)
+		meta, getErr := repo.GetFile(request.Context(), id)
+		if getErr == nil && !fileVisibleTo(request.Context(), repo, id, auth.IdentityOf(request.Context()).UserID) {
+			getErr = db.NotFound
+		}
+		if getErr != nil {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f, openErr := store.Open(id)
+		if openErr != nil {
+			log.Println("could not open stored file:", openErr)
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+
+		// the content type comes from the uploader, so only types that
+		// can't run scripts on zeno's origin are shown in the browser
+		disposition := "attachment"
+		contentType := "application/octet-stream"
+		if mediaType, _, err := mime.ParseMediaType(meta.ContentType); err == nil && inlineFileTypes[mediaType] {
+			disposition = "inline"
+			contentType = meta.ContentType
+		}
+		writer.Header().Set("Content-Type", contentType)
+		writer.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": meta.Name}))
+		writer.Header().Set("X-Content-Type-Options", "nosniff")
+		writer.Header().Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'unsafe-inline'")
+		http.ServeContent(writer, request, meta.Name, time.Time(meta.CreatedAt), f)
+	})
+
+	importDocs := func(defaultFormat string) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			log.Println("importing docs")
+			if request.Method != http.MethodPost {
+				writer.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			request.Body = http.MaxBytesReader(writer, request.Body, maxUploadBytes)
+			file, _, formErr := request.FormFile("file")
+			if formErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte(formErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+			defer file.Close()
+			format := request.FormValue("format")
+			if format == "" {
+				format = defaultFormat
+			}
+			scrape := true
+			if scrapeStr := request.FormValue("scrape"); scrapeStr != "" {
+				scrape, _ = strconv.ParseBool(scrapeStr)
+			}
+			dryRun, _ := strconv.ParseBool(request.FormValue("dry_run"))
+
+			parse, formatErr := importer.ParserFor(format)
+			var docs []domain.ScrapedDoc
+			parseErr := formatErr
+			if parseErr == nil {
+				docs, parseErr = parse(file)
+			}
+			if parseErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte(parseErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+			log.Printf("parsed %d docs, format: %s, scrape: %v, dry run: %v\n", len(docs), format, scrape, dryRun)
+			ownDocs(docs, auth.IdentityOf(request.Context()).UserID)
+
+			if dryRun {
+				writeJSON(writer, http.StatusOK, importer.Summarize(request.Context(), repo, docs))
+				return
+			}
+			writeJSON(writer, http.StatusAccepted, importer.Import(s, format, docs, scrape))
+		}
+	}
+	handle("/zeno/import", domain.WriteScope, importDocs(""))
+	handle("/zeno/import/bookmarks", domain.WriteScope, importDocs("netscape"))
+
+	handle("/zeno/export", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("exporting docs")
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := request.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = "jsonl"
+		}
+		withContent, _ := strconv.ParseBool(query.Get("content"))
+		log.Printf("format: %s, content: %v\n", format, withContent)
+
+		contentType, ext, formatErr := exporter.ContentType(format)
+		if formatErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(formatErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		writer.Header().Set("Content-Type", contentType)
+		writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="zeno-export.%s"`, ext))
+		docs := ownedDocs{docs: repo, userID: auth.IdentityOf(request.Context()).UserID}
+		if exportErr := exporter.Export(request.Context(), writer, docs, format, withContent); exportErr != nil {
+			// the status has already been sent, so the export is cut short
+			log.Println("could not export documents:", exportErr)
+		}
+	})
+
+	handle("/zeno/backup", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("backing up")
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if auth.IdentityOf(request.Context()).UserID != "" {
+			// backups hold every user's documents
+			writer.WriteHeader(http.StatusForbidden)
+			if _, err := writer.Write([]byte("only the admin can back up")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/gzip")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf(
+			`attachment; filename="zeno-backup-%s.tar.gz"`,
+			time.Now().Format("20060102-150405"),
+		))
+		cw := &countingWriter{w: writer}
+		if backupErr := backup.Write(request.Context(), cw, backupSources); backupErr != nil {
+			log.Println("could not write backup:", backupErr)
+			if cw.n > 0 {
+				// the status has already been sent, so the archive is cut short
+				return
+			}
+			writer.Header().Del("Content-Disposition")
+			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(backupErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+		}
+	})
+
+	mux.HandleFunc("/zeno/login", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var token string
+		var session domain.Session
+		var loginErr error
+		if name := request.FormValue("username"); name != "" {
+			token, session, loginErr = authenticator.LoginUser(request.Context(), name, request.FormValue("password"))
+		} else {
+			token, session, loginErr = authenticator.Login(request.Context(), request.FormValue("key"))
+		}
+		if loginErr != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(loginErr, auth.InvalidKey) || errors.Is(loginErr, auth.InvalidPassword) {
+				status = http.StatusUnauthorized
+			}
+			writer.WriteHeader(status)
+			if _, err := writer.Write([]byte(loginErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		auth.SetSessionCookie(writer, request, token, time.Time(session.ExpiresAt))
+		writeJSON(writer, http.StatusOK, session)
+	})
+
+	mux.HandleFunc("/zeno/logout", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if cookie, cookieErr := request.Cookie(auth.SessionCookie); cookieErr == nil {
+			if logoutErr := authenticator.Logout(request.Context(), cookie.Value); logoutErr != nil {
+				log.Println("could not log out:", logoutErr)
+			}
+		}
+		auth.ClearSessionCookie(writer)
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("/zeno/session", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		response := map[string]interface{}{
+			"required": authenticator.Required(),
+		}
+		if identity, authErr := authenticator.Authenticate(request); authErr == nil {
+			response["scope"] = identity.Scope
+			if identity.UserID != "" {
+				response["user_id"] = identity.UserID
+			}
+		}
+		writeJSON(writer, http.StatusOK, response)
+	})
+
+	mux.HandleFunc("/zeno/config", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]interface{}{
+			"search_backend": searchBackend,
+		})
+	})
+
+	handle("/zeno/search", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		var searchReq indexer.SearchRequest
+		switch request.Method {
+		case http.MethodGet:
+			var parseErr error
+			if searchReq, parseErr = searchRequestFromQuery(request.URL.Query()); parseErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte(parseErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		case http.MethodPost:
+			decoder := json.NewDecoder(request.Body)
+			decoder.DisallowUnknownFields()
+			if decodeErr := decoder.Decode(&searchReq); decodeErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte("invalid search request: " + decodeErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		searchReq.Filters.Owner = auth.IdentityOf(request.Context()).UserID
+		result, searchErr := searcher.Search(request.Context(), searchReq)
+		if searchErr != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(searchErr, indexer.InvalidSearch) {
+				status = http.StatusBadRequest
+			}
+			writer.WriteHeader(status)
+			if _, err := writer.Write([]byte(searchErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		writeJSON(writer, http.StatusOK, result)
+	})
+
+	handle("/zeno/search-token", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if searchBackend != indexer.MeilisearchBackend {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("search tokens are only used by the meilisearch backend")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		if tokens == nil {
+			// the search server was started without a key
+			writeJSON(writer, http.StatusOK, indexer.SearchToken{})
+			return
+		}
+
+		filter := request.URL.Query().Get("filter")
+		if userID := auth.IdentityOf(request.Context()).UserID; userID != "" {
+			// a filter could close the brackets around the one limiting
+			// users to their own documents and shared ones
+			if filter != "" {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte("only the admin can mint search tokens with a filter")); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+			filter = indexer.VisibleFilter(userID)
+		}
+		token, mintErr := tokens.Mint(filter)
+		if mintErr != nil {
+			log.Println("could not mint search token:", mintErr)
+			status := http.StatusInternalServerError
+			if errors.Is(mintErr, indexer.InvalidSearch) {
+				status = http.StatusBadRequest
+			}
+			writer.WriteHeader(status)
+			if _, err := writer.Write([]byte(mintErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		writeJSON(writer, http.StatusOK, token)
+	})
+
+	handle("/zeno/search-settings", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			settings, getErr := repo.GetSearchSettings(request.Context())
+			if getErr != nil {
+				log.Println("could not read search settings:", getErr)
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			writeJSON(writer, http.StatusOK, settings)
+			return
+		case http.MethodPut:
+		default:
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		identity := auth.IdentityOf(request.Context())
+		if !identity.Scope.Allows(domain.WriteScope) || identity.UserID != "" {
+			// the settings change every user's searches
+			writer.WriteHeader(http.StatusForbidden)
+			if _, err := writer.Write([]byte("only the admin can change search settings")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		var settings domain.SearchSettings
+		decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxIngestBytes))
+		decoder.DisallowUnknownFields()
+		decodeErr := decoder.Decode(&settings)
+		if decodeErr == nil {
+			settings, decodeErr = settings.Normalize()
+		}
+		if decodeErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte("invalid search settings: " + decodeErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		// saved first, so settings the index can't take now are applied
+		// at the next start
+		if err := repo.SaveSearchSettings(request.Context(), settings); err != nil {
+			log.Println("could not save search settings:", err)
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if applier, ok := searcher.(indexer.SettingsApplier); ok {
+			if applyErr := applier.ApplySettings(settings); applyErr != nil {
+				log.Println("could not apply search settings:", applyErr)
+				writer.WriteHeader(http.StatusInternalServerError)
+				if _, err := writer.Write([]byte("saved the search settings, but could not apply them to the index")); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		}
+		log.Println("updated search settings")
+		writeJSON(writer, http.StatusOK, settings)
+	})
+
+	// updateDoc changes the metadata of the document with the id, if the user
+	// can edit it, and writes the updated document
+	updateDoc := func(writer http.ResponseWriter, request *http.Request, id string, update domain.DocUpdate) {
+		doc, getErr := repo.Get(request.Context(), domain.ScrapedDoc{ID: id})
+		if getErr != nil || !doc.EditableBy(auth.IdentityOf(request.Context()).UserID) {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("document not found")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		if update.Shared != nil && !*update.Shared && doc.Owner == "" {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte("documents without an owner are always shared")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		updated, updateErr := s.Update(doc, update)
+		if updateErr != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(updateErr, scraper.Unscrapable) {
+				status = http.StatusBadRequest
+			}
+			writer.WriteHeader(status)
+			if _, err := writer.Write([]byte(updateErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		updated.Content = ""
+		writeJSON(writer, http.StatusOK, updated)
+	}
+
+	handle("/zeno/share", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("sharing doc")
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := request.URL.Query()
+		shared, parseErr := strconv.ParseBool(query.Get("shared"))
+		if parseErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte("shared must be true or false")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		log.Printf("id: %s, shared: %v\n", query.Get("id"), shared)
+		updateDoc(writer, request, query.Get("id"), domain.DocUpdate{Shared: &shared})
+	})
+
+	handle("/zeno/update", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("updating doc")
+		if request.Method != http.MethodPatch {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var update domain.DocUpdate
+		decoder := json.NewDecoder(http.MaxBytesReader(writer, request.Body, maxIngestBytes))
+		decoder.DisallowUnknownFields()
+		decodeErr := decoder.Decode(&update)
+		if decodeErr == nil {
+			decodeErr = update.Validate()
+		}
+		if decodeErr != nil || update.Empty() {
+			message := "expected at least one of title, description, tags, collections, scraped, shared, status, favourite or progress"
+			if decodeErr != nil {
+				message = "invalid update: " + decodeErr.Error()
+			}
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(message)); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		updateDoc(writer, request, request.URL.Query().Get("id"), update)
+	})
+
+	// updateReading changes what a user has read of a document, with the
+	// update parse makes from the query
+	updateReading := func(parse func(query url.Values) (domain.DocUpdate, error)) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodGet {
+				writer.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			query := request.URL.Query()
+			update, parseErr := parse(query)
+			if parseErr == nil {
+				parseErr = update.Validate()
+			}
+			if parseErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte(parseErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+			log.Printf("id: %s, query: %s\n", query.Get("id"), request.URL.RawQuery)
+			updateDoc(writer, request, query.Get("id"), update)
+		}
+	}
+
+	handle("/zeno/status", domain.WriteScope, updateReading(func(query url.Values) (domain.DocUpdate, error) {
+		status, err := domain.ParseReadStatus(query.Get("status"))
+		return domain.DocUpdate{Status: &status}, err
+	}))
+	handle("/zeno/favourite", domain.WriteScope, updateReading(func(query url.Values) (domain.DocUpdate, error) {
+		favourite, err := strconv.ParseBool(query.Get("favourite"))
+		if err != nil {
+			return domain.DocUpdate{}, errors.New("favourite must be true or false")
+		}
+		return domain.DocUpdate{Favourite: &favourite}, nil
+	}))
+	handle("/zeno/progress", domain.WriteScope, updateReading(func(query url.Values) (domain.DocUpdate, error) {
+		progress, err := strconv.ParseFloat(query.Get("progress"), 64)
+		if err != nil {
+			return domain.DocUpdate{}, errors.New("progress must be a number from 0 to 1")
+		}
+		return domain.DocUpdate{Progress: &progress}, nil
+	}))
+
+	handle("/zeno/document", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		doc, getErr := repo.Get(request.Context(), domain.ScrapedDoc{ID: request.URL.Query().Get("id")})
+		if getErr != nil || !doc.VisibleTo(auth.IdentityOf(request.Context()).UserID) {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("document not found")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		content, contentErr := repo.GetContent(request.Context(), doc.ID)
+		if contentErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(contentErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		doc.Content = content
+		writeJSON(writer, http.StatusOK, doc)
+	})
+
+	handle("/zeno/annotate", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("annotating doc")
+		if request.Method != http.MethodPost {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		request.Body = http.MaxBytesReader(writer, request.Body, maxIngestBytes)
+		userID := auth.IdentityOf(request.Context()).UserID
+		// everyone who can read a document can annotate it
+		doc, getErr := repo.Get(request.Context(), domain.ScrapedDoc{ID: request.FormValue("id")})
+		if getErr != nil || !doc.VisibleTo(userID) {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("document not found")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		annotation, annotationErr := domain.NewAnnotation(userID, request.FormValue("quote"), request.FormValue("comment"))
+		if annotationErr != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			if _, err := writer.Write([]byte(annotationErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		log.Printf("id: %s, annotation: %s\n", doc.ID, annotation.ID)
+
+		annotations := append(doc.Annotations, annotation)
+		if _, updateErr := s.Update(doc, domain.DocUpdate{Annotations: &annotations}); updateErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(updateErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		writeJSON(writer, http.StatusCreated, annotation)
+	})
+
+	handle("/zeno/annotation/delete", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("deleting annotation")
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := request.URL.Query()
+		userID := auth.IdentityOf(request.Context()).UserID
+		doc, getErr := repo.Get(request.Context(), domain.ScrapedDoc{ID: query.Get("id")})
+		if getErr != nil || !doc.VisibleTo(userID) {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("document not found")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		// annotations can be deleted by their author and by whoever can
+		// edit the document
+		annotations := make([]domain.Annotation, 0, len(doc.Annotations))
+		found := false
+		for _, annotation := range doc.Annotations {
+			if annotation.ID == query.Get("annotation") &&
+				(annotation.Author == userID || doc.EditableBy(userID)) {
+				found = true
+				continue
+			}
+			annotations = append(annotations, annotation)
+		}
+		if !found {
+			writer.WriteHeader(http.StatusNotFound)
+			if _, err := writer.Write([]byte("annotation not found")); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		log.Printf("id: %s, annotation: %s\n", doc.ID, query.Get("annotation"))
+
+		if _, updateErr := s.Update(doc, domain.DocUpdate{Annotations: &annotations}); updateErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(updateErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+		writer.WriteHeader(http.StatusOK)
+	})
+
+	handle("/zeno/rescrape", domain.WriteScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("rescraping docs")
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := request.URL.Query()
+		userID := auth.IdentityOf(request.Context()).UserID
+		var docs []domain.ScrapedDoc
+		if id := query.Get("id"); id != "" {
+			doc, getErr := repo.Get(request.Context(), domain.ScrapedDoc{ID: id})
+			if getErr != nil || !doc.EditableBy(userID) {
+				writer.WriteHeader(http.StatusNotFound)
+				if _, err := writer.Write([]byte("document not found")); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+			docs = append(docs, doc)
+		} else {
+			searchReq, parseErr := searchRequestFromQuery(query)
+			if parseErr == nil && searchReq.Query == "" && len(searchReq.Filters.Domains) == 0 {
+				parseErr = errors.New("id, domain or q is required")
+			}
+			if parseErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte(parseErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+			var searchErr error
+			if docs, searchErr = editableDocs(request.Context(), repo, searcher, searchReq, userID); searchErr != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(searchErr, indexer.InvalidSearch) {
+					status = http.StatusBadRequest
+				}
+				writer.WriteHeader(status)
+				if _, err := writer.Write([]byte(searchErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		}
+		log.Printf("rescraping %d docs\n", len(docs))
+
+		// report each document as it is done, one JSON object per line
+		writer.Header().Set("Content-Type", "application/x-ndjson")
+		writer.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(writer)
+		flusher, _ := writer.(http.Flusher)
+		s.Rescrape(request.Context(), docs, func(result scraper.RescrapeResult) {
+			if err := encoder.Encode(result); err != nil {
+				log.Println("found error writing response bytes:", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+	})
+
+	handle("/zeno/duplicates", domain.ReadScope, func(writer http.ResponseWriter, request *http.Request) {
+		log.Println("listing duplicates")
+		if request.Method != http.MethodGet {
+			writer.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		distance := domain.DuplicateDistance
+		if distanceStr := request.URL.Query().Get("distance"); distanceStr != "" {
+			var parseErr error
+			distance, parseErr = strconv.Atoi(distanceStr)
+			if parseErr != nil || distance < 0 || distance > 64 {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte("distance must be between 0 and 64")); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+		}
+
+		all, getErr := repo.GetAll(request.Context())
+		if getErr != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			if _, err := writer.Write([]byte(getErr.Error())); err != nil {
+				log.Println("found error writing response bytes:", err)
+			}
+			return
+		}
+
+		// users can only resolve duplicates in their own library
+		userID := auth.IdentityOf(request.Context()).UserID
+		docs := make([]domain.ScrapedDoc, 0, len(all))
+		for _, doc := range all {
+			if doc.EditableBy(userID) {
+				docs = append(docs, doc)
+			}
+		}
+		clusters := domain.ClusterDuplicates(docs, distance)
+		if clusters == nil {
+			clusters = [][]domain.ScrapedDoc{}
+		}
+		writeJSON(writer, http.StatusOK, map[string]interface{}{
+			"distance": distance,
+			"clusters": clusters,
+		})
+	})
+
+	resolveDuplicates := func(resolve func(keepId string, ids []string) error) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodGet {
+				writer.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+
+			query := request.URL.Query()
+			keepId := query.Get("keep")
+			ids := query["id"]
+			log.Printf("keep: %s, ids: %v\n", keepId, ids)
+			if keepId == "" || len(ids) == 0 {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte("keep and id are required")); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+
+			if userID := auth.IdentityOf(request.Context()).UserID; userID != "" {
+				for _, id := range append([]string{keepId}, ids...) {
+					doc, getErr := repo.Get(request.Context(), domain.ScrapedDoc{ID: id})
+					if getErr != nil || !doc.EditableBy(userID) {
+						writer.WriteHeader(http.StatusNotFound)
+						if _, err := writer.Write([]byte(fmt.Sprintf("document %s not found", id))); err != nil {
+							log.Println("found error writing response bytes:", err)
+						}
+						return
+					}
+				}
+			}
+
+			if resolveErr := resolve(keepId, ids); resolveErr != nil {
+				writer.WriteHeader(http.StatusBadRequest)
+				if _, err := writer.Write([]byte(resolveErr.Error())); err != nil {
+					log.Println("found error writing response bytes:", err)
+				}
+				return
+			}
+
+			writer.WriteHeader(http.StatusOK)
+		}
+	}
+	handle("/zeno/duplicates/merge", domain.WriteScope, resolveDuplicates(s.MergeDuplicates))
+	handle("/zeno/duplicates/hide", domain.WriteScope, resolveDuplicates(s.HideDuplicates))
+
+	// the bookmarklet's window is under /zeno/, as only zeno's routes and
+	// the index page are served without going to the search server
+	mux.HandleFunc("/zeno/save.html", func(writer http.ResponseWriter, request *http.Request) {
+		http.ServeFile(writer, request, "./static/save.html")
+	})
+
 	mux.Handle("/", http.FileServer(http.Dir("./static")))
 }
+
+func writeJSON(writer http.ResponseWriter, status int, v interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		log.Println("found error writing response bytes:", err)
+	}
+}
+
+// ownDocs puts documents a user imports in their own library. Exported
+// ids, owners and duplicates refer to other libraries, so they are dropped.
+func ownDocs(docs []domain.ScrapedDoc, userID string) {
+	if userID == "" {
+		return
+	}
+	for i := range docs {
+		docs[i].ID = ""
+		docs[i].Owner = userID
+		docs[i].DuplicateOf = ""
+		// annotation IDs are unique across the instance, so imported
+		// annotations get new ones
+		for j := range docs[i].Annotations {
+			id, err := domain.NewAnnotationID()
+			if err != nil {
+				docs[i].Annotations = nil
+				break
+			}
+			docs[i].Annotations[j].ID = id
+			docs[i].Annotations[j].Author = userID
+		}
+	}
+}
+
+// editableDocs returns the documents matching a search that the user with
+// userID can change. Shared documents turn up in searches, but only their
+// owner can change them.
+func editableDocs(
+	ctx context.Context,
+	repo db.GormRepo,
+	searcher indexer.Searcher,
+	searchReq indexer.SearchRequest,
+	userID string,
+) ([]domain.ScrapedDoc, error) {
+	searchReq.Filters.Owner = userID
+	ids, err := indexer.SearchIDs(ctx, searcher, searchReq)
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]domain.ScrapedDoc, 0, len(ids))
+	for _, id := range ids {
+		if doc, getErr := repo.Get(ctx, domain.ScrapedDoc{ID: id}); getErr == nil && doc.EditableBy(userID) {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func docIDs(docs []domain.ScrapedDoc) []string {
+	ids := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
+
+// bulkDeleteResult reports the documents a bulk delete removed, or would
+// remove on a dry run. Documents only lists those deleted when it fails
+// part way.
+type bulkDeleteResult struct {
+	DryRun    bool         `json:"dry_run"`
+	Total     int          `json:"total"`
+	Deleted   int          `json:"deleted"`
+	Documents []deletedDoc `json:"documents"`
+	Error     string       `json:"error,omitempty"`
+}
+
+type deletedDoc struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+// ownedDocs iterates the documents in a user's library, or every
+// document for the admin
+type ownedDocs struct {
+	docs   exporter.DocIterator
+	userID string
+}
+
+func (o ownedDocs) Each(ctx context.Context, withContent bool, fn func(domain.ScrapedDoc) error) error {
+	return o.docs.Each(ctx, withContent, func(doc domain.ScrapedDoc) error {
+		if !doc.EditableBy(o.userID) {
+			return nil
+		}
+		return fn(doc)
+	})
+}
+
+// countingWriter counts the bytes written through it, to tell whether a
+// response has started
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// searchRequestFromQuery reads a search request from query parameters.
+// Filters and facets with several values are given by repeating them.
+func searchRequestFromQuery(query url.Values) (indexer.SearchRequest, error) {
+	searchReq := indexer.SearchRequest{
+		Query:            query.Get("q"),
+		Sort:             query.Get("sort"),
+		Facets:           query["facet"],
+		HighlightPreTag:  query.Get("highlight_pre_tag"),
+		HighlightPostTag: query.Get("highlight_post_tag"),
+		Filters: indexer.Filters{
+			Domains:     query["domain"],
+			Tags:        query["tag"],
+			Collections: query["collection"],
+		},
+	}
+	for _, docType := range query["doc_type"] {
+		searchReq.Filters.DocTypes = append(searchReq.Filters.DocTypes, domain.DocType(docType))
+	}
+	for _, status := range query["status"] {
+		searchReq.Filters.Statuses = append(searchReq.Filters.Statuses, domain.ReadStatus(status))
+	}
+	for name, field := range map[string]**bool{
+		"favourite": &searchReq.Filters.Favourite,
+		"scraped":   &searchReq.Filters.Scraped,
+	} {
+		if value := query.Get(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return indexer.SearchRequest{}, fmt.Errorf("%s must be true or false", name)
+			}
+			*field = &b
+		}
+	}
+	for name, field := range map[string]*int{"offset": &searchReq.Offset, "limit": &searchReq.Limit} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return indexer.SearchRequest{}, fmt.Errorf("%s must be a positive number", name)
+			}
+			*field = n
+		}
+	}
+	for name, field := range map[string]**domain.Timestamp{
+		"after":  &searchReq.Filters.After,
+		"before": &searchReq.Filters.Before,
+	} {
+		if value := query.Get(name); value != "" {
+			t, err := domain.ParseTimestamp(value)
+			if err != nil {
+				return indexer.SearchRequest{}, fmt.Errorf("%s: %w", name, err)
+			}
+			*field = &t
+		}
+	}
+	return searchReq, nil
+}
+
+// fileVisibleTo reports whether the user with userID can see a document
+// made from the uploaded file with hash
+func fileVisibleTo(ctx context.Context, repo db.GormRepo, hash, userID string) bool {
+	if userID == "" {
+		return true
+	}
+	docs, err := repo.FindByURL(ctx, filesPath+hash)
+	if err != nil {
+		log.Println("could not find documents of file:", err)
+		return false
+	}
+	for _, doc := range docs {
+		if doc.VisibleTo(userID) {
+			return true
+		}
+	}
+	return false
+}
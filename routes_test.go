@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"zeno/auth"
+	"zeno/backup"
+	"zeno/db"
+	"zeno/domain"
+	"zeno/files"
+	"zeno/indexer"
+	"zeno/scraper"
+)
+
+const testMasterKey = "master"
+
+// testServer serves zeno's routes with a bleve index in memory
+type testServer struct {
+	mux     *http.ServeMux
+	repo    db.GormRepo
+	store   files.Store
+	index   indexer.Backend
+	scraper scraper.CollyScraper
+}
+
+func newTestServer(t *testing.T, searchBackend string, tokens *indexer.TokenMinter) testServer {
+	dir := t.TempDir()
+	repo := db.NewGormRepo(filepath.Join(dir, "test.db"))
+	store := files.NewStore(filepath.Join(dir, "files"))
+	index, err := indexer.NewBleveIndexer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = index.Close() })
+	mux := http.NewServeMux()
+	collyScraper := scraper.NewCollyScraper(index, repo)
+	MakeRoutes(
+		collyScraper,
+		mux,
+		repo,
+		store,
+		backup.Sources{DB: repo, FilesDir: store.Dir()},
+		index,
+		searchBackend,
+		tokens,
+		auth.NewAuthenticator(repo, testMasterKey, true),
+	)
+	return testServer{mux: mux, repo: repo, store: store, index: index, scraper: collyScraper}
+}
+
+// key returns a new API key acting as the user with userID
+func (s testServer) key(t *testing.T, userID string, scope domain.Scope) string {
+	apiKey, key, hash, err := auth.NewKey(userID, scope, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.repo.SaveApiKey(context.Background(), apiKey, hash); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// do sends a request with the key and returns the response
+func (s testServer) do(method, path, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSearchToken(t *testing.T) {
+	meili := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/keys":
+			_, _ = w.Write([]byte(`{"results":[{"name":"zeno search","uid":"6062abda-a5aa-4414-ac91-ecd7944c0f8d","key":"search-key","actions":["search"],"indexes":["sites"],"expiresAt":null}]}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/indexes/sites/search":
+			// filters are checked by searching with them
+			_, _ = w.Write([]byte(`{"hits":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer meili.Close()
+	server := newTestServer(t, indexer.MeilisearchBackend, indexer.NewTokenMinter(indexer.MakeMeilisearchClient(meili.URL, "master")))
+	userKey := server.key(t, "bob", domain.ReadScope)
+
+	tests := []struct {
+		name   string
+		key    string
+		filter string
+		want   int
+	}{
+		{name: "admin", key: testMasterKey, want: http.StatusOK},
+		{name: "admin filter", key: testMasterKey, filter: "tags = go", want: http.StatusOK},
+		{name: "user", key: userKey, want: http.StatusOK},
+		{name: "user filter", key: userKey, filter: "tags = go", want: http.StatusBadRequest},
+		{name: "user bracket escape", key: userKey, filter: "shared = true) OR (shared = false", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/zeno/search-token"
+			if tt.filter != "" {
+				path += "?" + url.Values{"filter": {tt.filter}}.Encode()
+			}
+			response := server.do(http.MethodGet, path, tt.key, "")
+			if response.Code != tt.want {
+				body, _ := io.ReadAll(response.Body)
+				t.Errorf("GET %s = %d %s, want %d", path, response.Code, body, tt.want)
+			}
+		})
+	}
+}
+
+// upload uploads a file with the key and returns the hash it is stored by
+func (s testServer) upload(t *testing.T, key, name, contentType, content string) string {
+	if recorder := s.postFile(t, key, name, contentType, content); recorder.Code != http.StatusCreated {
+		t.Fatalf("upload %s = %d %s", name, recorder.Code, recorder.Body)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// postFile posts a file to /zeno/upload and returns the response
+func (s testServer) postFile(t *testing.T, key, name, contentType, content string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, name))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write([]byte(content))
+	_ = form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/zeno/upload", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("Authorization", "Bearer "+key)
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestFiles(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	html := server.upload(t, testMasterKey, "page.html", "text/html", "<html><body><script>alert(1)</script></body></html>")
+	text := server.upload(t, testMasterKey, "notes.txt", "text/plain; charset=utf-8", "some notes")
+
+	tests := []struct {
+		name        string
+		hash        string
+		contentType string
+		disposition string
+	}{
+		{name: "html", hash: html, contentType: "application/octet-stream", disposition: `attachment; filename=page.html`},
+		{name: "text", hash: text, contentType: "text/plain; charset=utf-8", disposition: `inline; filename=notes.txt`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := server.do(http.MethodGet, filesPath+tt.hash, testMasterKey, "")
+			if response.Code != http.StatusOK {
+				t.Fatalf("GET %s = %d", tt.hash, response.Code)
+			}
+			if got := response.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %s, want %s", got, tt.contentType)
+			}
+			if got := response.Header().Get("Content-Disposition"); got != tt.disposition {
+				t.Errorf("Content-Disposition = %s, want %s", got, tt.disposition)
+			}
+			if got := response.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+				t.Errorf("X-Content-Type-Options = %s, want nosniff", got)
+			}
+			if response.Header().Get("Content-Security-Policy") == "" {
+				t.Error("no Content-Security-Policy")
+			}
+		})
+	}
+}
+
+func TestFilesOfOtherUsers(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	alice := server.key(t, "alice", domain.WriteScope)
+	bob := server.key(t, "bob", domain.ReadScope)
+	private := server.upload(t, alice, "private.txt", "text/plain", "alice's notes")
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{name: "owner", key: alice, want: http.StatusOK},
+		{name: "admin", key: testMasterKey, want: http.StatusOK},
+		{name: "other user", key: bob, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if response := server.do(http.MethodGet, filesPath+private, tt.key, ""); response.Code != tt.want {
+				t.Errorf("GET %s = %d, want %d", private, response.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIngestCORS(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	for _, method := range []string{http.MethodOptions, http.MethodPost} {
+		response := server.do(method, "/zeno/ingest", "", "")
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("%s /zeno/ingest without a key = %d, want %d", method, response.Code, http.StatusUnauthorized)
+		}
+		if origin := response.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+			t.Errorf("%s /zeno/ingest allows origin %s", method, origin)
+		}
+	}
+}
+
+// save saves and indexes docs, leaving hidden duplicates out of the index
+func (s testServer) save(t *testing.T, docs ...domain.ScrapedDoc) {
+	for _, doc := range docs {
+		if err := s.repo.Save(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+		if doc.DuplicateOf != "" {
+			continue
+		}
+		if err := s.index.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBulkDelete(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	server.save(t,
+		domain.ScrapedDoc{ID: "a", URL: "https://x.example/a", Domain: "x.example", Title: "a"},
+		domain.ScrapedDoc{ID: "dup", URL: "https://y.example/a", Domain: "y.example", Title: "a", DuplicateOf: "a"},
+		domain.ScrapedDoc{ID: "b", URL: "https://z.example/b", Domain: "z.example", Title: "b"},
+	)
+
+	if response := server.do(http.MethodGet, "/zeno/delete/bulk?domain=x.example", testMasterKey, ""); response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /zeno/delete/bulk = %d, want %d", response.Code, http.StatusMethodNotAllowed)
+	}
+	response := server.do(http.MethodGet, "/zeno/delete/bulk?domain=x.example&dry_run=true", testMasterKey, "")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"total":2`) {
+		t.Errorf("dry run = %d %s, want the document and its duplicate", response.Code, response.Body)
+	}
+	if _, err := server.repo.Get(context.Background(), domain.ScrapedDoc{ID: "a"}); err != nil {
+		t.Errorf("dry run deleted a: %v", err)
+	}
+
+	response = server.do(http.MethodPost, "/zeno/delete/bulk?domain=x.example", testMasterKey, "")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"deleted":2`) {
+		t.Fatalf("POST /zeno/delete/bulk = %d %s", response.Code, response.Body)
+	}
+	for id, kept := range map[string]bool{"a": false, "dup": false, "b": true} {
+		if _, err := server.repo.Get(context.Background(), domain.ScrapedDoc{ID: id}); (err == nil) != kept {
+			t.Errorf("after deleting, %s kept = %v, want %v", id, err == nil, kept)
+		}
+	}
+}
+
+func TestSavePage(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	proxy := newSearchProxy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("%s was sent to the search server", r.URL.Path)
+	}), nil)
+	request := httptest.NewRequest(http.MethodGet, "/zeno/save.html", nil)
+	recorder := httptest.NewRecorder()
+	newHandler(server.mux, proxy).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "Save to Zeno") {
+		t.Errorf("GET /zeno/save.html = %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestHiddenDuplicatesAreNotListed(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	fingerprint := domain.SimHashOf("the same article about go scheduling, posted on two sites")
+	server.save(t,
+		domain.ScrapedDoc{ID: "a", URL: "https://x.example/a", Domain: "x.example", Title: "a", Fingerprint: fingerprint},
+		domain.ScrapedDoc{ID: "b", URL: "https://y.example/a", Domain: "y.example", Title: "a", Fingerprint: fingerprint},
+	)
+
+	response := server.do(http.MethodGet, "/zeno/duplicates", testMasterKey, "")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"id":"b"`) {
+		t.Fatalf("GET /zeno/duplicates = %d %s, want a and b", response.Code, response.Body)
+	}
+	if response := server.do(http.MethodGet, "/zeno/duplicates/hide?keep=a&id=b", testMasterKey, ""); response.Code != http.StatusOK {
+		t.Fatalf("GET /zeno/duplicates/hide = %d %s", response.Code, response.Body)
+	}
+	response = server.do(http.MethodGet, "/zeno/duplicates", testMasterKey, "")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"clusters":[]`) {
+		t.Errorf("GET /zeno/duplicates after hiding = %d %s, want no clusters", response.Code, response.Body)
+	}
+}
+
+func TestShare(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	server.save(t,
+		domain.ScrapedDoc{ID: "a", URL: "https://x.example/a", Title: "a", Owner: "ada"},
+		domain.ScrapedDoc{ID: "team", URL: "https://x.example/team", Title: "team", Shared: true},
+	)
+	adaKey := server.key(t, "ada", domain.WriteScope)
+	bobKey := server.key(t, "bob", domain.WriteScope)
+
+	if response := server.do(http.MethodGet, "/zeno/share?id=a&shared=true", adaKey, ""); response.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /zeno/share = %d, want %d", response.Code, http.StatusMethodNotAllowed)
+	}
+	if response := server.do(http.MethodPost, "/zeno/share?id=a&shared=true", bobKey, ""); response.Code != http.StatusNotFound {
+		t.Errorf("sharing another user's document = %d, want %d", response.Code, http.StatusNotFound)
+	}
+	if response := server.do(http.MethodPatch, "/zeno/update?id=team", testMasterKey, `{"shared":false}`); response.Code != http.StatusBadRequest {
+		t.Errorf("unsharing a document without an owner = %d, want %d", response.Code, http.StatusBadRequest)
+	}
+
+	response := server.do(http.MethodPost, "/zeno/share?id=a&shared=true", adaKey, "")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"shared":true`) {
+		t.Fatalf("POST /zeno/share = %d %s", response.Code, response.Body)
+	}
+	if response := server.do(http.MethodGet, "/zeno/document?id=a", bobKey, ""); response.Code != http.StatusOK {
+		t.Errorf("GET /zeno/document of a shared document = %d, want %d", response.Code, http.StatusOK)
+	}
+	if response := server.do(http.MethodPatch, "/zeno/update?id=a", adaKey, `{"shared":false}`); response.Code != http.StatusOK {
+		t.Fatalf("PATCH /zeno/update = %d %s", response.Code, response.Body)
+	}
+	if response := server.do(http.MethodGet, "/zeno/document?id=a", bobKey, ""); response.Code != http.StatusNotFound {
+		t.Errorf("GET /zeno/document of an unshared document = %d, want %d", response.Code, http.StatusNotFound)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{query: "doc_type=pdf&status=unread", want: http.StatusOK},
+		{query: "doc_type=docx", want: http.StatusBadRequest},
+		{query: "status=starred", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if response := server.do(http.MethodGet, "/zeno/search?"+tt.query, testMasterKey, ""); response.Code != tt.want {
+				t.Errorf("GET /zeno/search?%s = %d %s, want %d", tt.query, response.Code, response.Body, tt.want)
+			}
+		})
+	}
+}
+
+// importFile posts an export to /zeno/import
+func (s testServer) importFile(t *testing.T, key, format, content string) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	_ = form.WriteField("format", format)
+	part, err := form.CreateFormFile("file", "zeno-export."+format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write([]byte(content))
+	_ = form.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/zeno/import", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("Authorization", "Bearer "+key)
+	recorder := httptest.NewRecorder()
+	s.mux.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusAccepted || !strings.Contains(recorder.Body.String(), `"failed":[]`) {
+		t.Fatalf("import = %d %s", recorder.Code, recorder.Body)
+	}
+	s.scraper.C.Wait()
+}
+
+func TestExportImportContent(t *testing.T) {
+	var fetched int32
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		_, _ = w.Write([]byte(`<html><head><title>Page title</title></head><body><main><p>page text</p></main></body></html>`))
+	}))
+	defer page.Close()
+	source := newTestServer(t, indexer.BleveBackend, nil)
+	source.save(t,
+		domain.ScrapedDoc{ID: "a", URL: page.URL + "/a", Title: "My title", Scrape: true, Content: "page text "},
+		domain.ScrapedDoc{ID: "b", URL: page.URL + "/b", Title: "Bookmark"},
+	)
+
+	response := source.do(http.MethodGet, "/zeno/export", testMasterKey, "")
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "page text") {
+		t.Fatalf("GET /zeno/export = %d %s, want documents without content", response.Code, response.Body)
+	}
+	export := response.Body.String()
+
+	// a library that has the content keeps it
+	source.importFile(t, testMasterKey, "jsonl", export)
+	if content, err := source.repo.GetContent(context.Background(), "a"); err != nil || content != "page text " || atomic.LoadInt32(&fetched) != 0 {
+		t.Errorf("import into the exported library kept content %q, %v after %d fetches", content, err, atomic.LoadInt32(&fetched))
+	}
+
+	// other libraries scrape scraped documents again
+	target := newTestServer(t, indexer.BleveBackend, nil)
+	target.importFile(t, testMasterKey, "jsonl", export)
+	doc, err := target.repo.Get(context.Background(), domain.ScrapedDoc{ID: "a"})
+	if err != nil || doc.Title != "My title" {
+		t.Fatalf("import restored %s, %v", doc, err)
+	}
+	if content, err := target.repo.GetContent(context.Background(), "a"); err != nil || content != "page text " {
+		t.Errorf("import restored content %q, %v, want the page scraped again", content, err)
+	}
+	if content, _ := target.repo.GetContent(context.Background(), "b"); content != "" || atomic.LoadInt32(&fetched) != 1 {
+		t.Errorf("import of a bookmark restored content %q after %d fetches, want it left unscraped", content, atomic.LoadInt32(&fetched))
+	}
+}
+
+func TestFailedUpload(t *testing.T) {
+	server := newTestServer(t, indexer.BleveBackend, nil)
+	server.upload(t, testMasterKey, "notes.txt", "text/plain", "some notes")
+
+	tests := []struct {
+		name       string
+		content    string
+		wantStored bool
+	}{
+		{name: "new file", content: "not a pdf"},
+		{name: "file uploaded before", content: "some notes", wantStored: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the content can't be parsed as a pdf
+			if response := server.postFile(t, testMasterKey, "broken.pdf", "application/pdf", tt.content); response.Code != http.StatusBadRequest {
+				t.Fatalf("upload broken.pdf = %d %s, want %d", response.Code, response.Body, http.StatusBadRequest)
+			}
+			sum := sha256.Sum256([]byte(tt.content))
+			hash := hex.EncodeToString(sum[:])
+			_, getErr := server.repo.GetFile(context.Background(), hash)
+			file, openErr := server.store.Open(hash)
+			if openErr == nil {
+				_ = file.Close()
+			}
+			if stored := getErr == nil && openErr == nil; stored != tt.wantStored {
+				t.Errorf("failed upload left the file stored = %v, want %v: %v, %v", stored, tt.wantStored, getErr, openErr)
+			}
+		})
+	}
+}
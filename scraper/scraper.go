@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
 	"os"
@@ -24,16 +25,61 @@ import (
 
 const DocCtxKey = "doc"
 
+// doneCtxKey holds a channel told when a request has been handled, for
+// callers waiting on it
+const doneCtxKey = "done"
+
+// savedCtxKey marks requests for saved documents, which keep their ID and
+// URL even when the page redirects elsewhere
+const savedCtxKey = "saved"
+
+// maxParallelScrapes limits how many pages are fetched at the same time
+const maxParallelScrapes = 8
+
+// deleteBatchSize is how many documents DeleteBatch deletes at once
+const deleteBatchSize = 100
+
+// Unscrapable is returned for documents that are not web pages, which
+// cannot be fetched again
+var Unscrapable = errors.New("only web pages can be scraped again")
+
 type UrlRepo interface {
 	Save(ctx context.Context, scrapedDoc domain.ScrapedDoc) error
 	Get(ctx context.Context, scrapedDoc domain.ScrapedDoc) (domain.ScrapedDoc, error)
+	GetContent(ctx context.Context, id string) (string, error)
+	DeleteContent(ctx context.Context, id string) error
 	GetAll(ctx context.Context) ([]domain.ScrapedDoc, error)
 	Delete(ctx context.Context, scrapedDoc domain.ScrapedDoc) error
+	DeleteBatch(ctx context.Context, ids []string) error
+	Trash(ctx context.Context, ids []string) error
+	Untrash(ctx context.Context, id string) (domain.ScrapedDoc, error)
+	MarkDuplicates(ctx context.Context, keepId string, ids []string) error
+	DuplicatesOf(ctx context.Context, ids []string) ([]domain.ScrapedDoc, error)
 }
 
 type Scraper interface {
 	Scrape(doc domain.ScrapedDoc) error
 	Delete(doc domain.ScrapedDoc) error
+	DeleteBatch(ids []string) (int, error)
+	Untrash(id string) (domain.ScrapedDoc, error)
+	Purge(ids []string) error
+	MergeDuplicates(keepId string, ids []string) error
+	HideDuplicates(keepId string, ids []string) error
+	Ingest(doc domain.ScrapedDoc, body []byte) error
+	IngestFile(doc domain.ScrapedDoc, fileName string, body []byte) error
+	Restore(doc domain.ScrapedDoc) error
+	Update(doc domain.ScrapedDoc, update domain.DocUpdate) (domain.ScrapedDoc, error)
+	Rescrape(ctx context.Context, docs []domain.ScrapedDoc, progress func(RescrapeResult))
+}
+
+// RescrapeResult reports a document that has been fetched again. Done and
+// Total count the documents of the rescrape it is part of.
+type RescrapeResult struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Error string `json:"error,omitempty"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
 }
 
 type CollyScraper struct {
@@ -53,18 +99,188 @@ func NewCollyScraper(indexer indexer.Indexer, db UrlRepo) CollyScraper {
 	}
 }
 
+// Delete removes a document from the index and moves it to the trash,
+// where it can be restored from until it is purged
 func (c CollyScraper) Delete(doc domain.ScrapedDoc) error {
+	if releaseErr := c.releaseDuplicates("", []string{doc.ID}); releaseErr != nil {
+		return releaseErr
+	}
+	return c.trash(doc)
+}
+
+// trash removes a document from the index and moves it to the trash,
+// leaving the documents hidden as its duplicates as they are
+func (c CollyScraper) trash(doc domain.ScrapedDoc) error {
 	if dIndexErr := c.indexer.Delete(doc); dIndexErr != nil {
 		return fmt.Errorf("cannot delete from index: %w", dIndexErr)
 	}
 
-	if deleteErr := c.db.Delete(context.TODO(), doc); deleteErr != nil {
-		return fmt.Errorf("cannot delete from db: %w", deleteErr)
+	if trashErr := c.db.Trash(context.TODO(), []string{doc.ID}); trashErr != nil {
+		return fmt.Errorf("cannot move to trash: %w", trashErr)
 	}
 
 	return nil
 }
 
+// Untrash takes the document with id out of the trash and indexes it again
+func (c CollyScraper) Untrash(id string) (domain.ScrapedDoc, error) {
+	doc, err := c.db.Untrash(context.TODO(), id)
+	if err != nil {
+		return domain.ScrapedDoc{}, err
+	}
+	if doc.DuplicateOf != "" {
+		return doc, nil
+	}
+	if indexErr := c.indexSaved(doc); indexErr != nil {
+		return domain.ScrapedDoc{}, indexErr
+	}
+	return doc, nil
+}
+
+// indexSaved indexes a document saved in the db along with its content
+func (c CollyScraper) indexSaved(doc domain.ScrapedDoc) error {
+	content, contentErr := c.db.GetContent(context.TODO(), doc.ID)
+	if contentErr != nil {
+		return fmt.Errorf("cannot get content of %s: %w", doc.ID, contentErr)
+	}
+	doc.Content = content
+	if indexErr := c.indexer.Index(doc); indexErr != nil {
+		return fmt.Errorf("could not index: %w", indexErr)
+	}
+	return nil
+}
+
+// releaseDuplicates points the documents hidden as duplicates of the
+// documents with ids at the document with keepId instead. Without a
+// document to keep, or when it is one of them, they are shown in search
+// again. Documents in ids are left as they are, since they are going too.
+func (c CollyScraper) releaseDuplicates(keepId string, ids []string) error {
+	duplicates, err := c.db.DuplicatesOf(context.TODO(), ids)
+	if err != nil {
+		return err
+	}
+	going := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		going[id] = true
+	}
+	var moved []string
+	for _, duplicate := range duplicates {
+		if going[duplicate.ID] {
+			continue
+		}
+		if keepId != "" && duplicate.ID != keepId {
+			moved = append(moved, duplicate.ID)
+			continue
+		}
+		duplicate.DuplicateOf = ""
+		if saveErr := c.db.Save(context.TODO(), duplicate); saveErr != nil {
+			return fmt.Errorf("cannot show duplicate %s: %w", duplicate.ID, saveErr)
+		}
+		if indexErr := c.indexSaved(duplicate); indexErr != nil {
+			return indexErr
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	return c.db.MarkDuplicates(context.TODO(), keepId, moved)
+}
+
+// Purge permanently deletes documents in the trash, a batch at a time
+func (c CollyScraper) Purge(ids []string) error {
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		if deleteErr := c.db.DeleteBatch(context.TODO(), ids[start:end]); deleteErr != nil {
+			return fmt.Errorf("cannot delete from db: %w", deleteErr)
+		}
+	}
+	return nil
+}
+
+// DeleteBatch removes the documents with ids from the index and moves them
+// to the trash, a batch at a time. It returns how many were deleted, which
+// is fewer than asked for when it fails part way.
+func (c CollyScraper) DeleteBatch(ids []string) (int, error) {
+	if releaseErr := c.releaseDuplicates("", ids); releaseErr != nil {
+		return 0, releaseErr
+	}
+	deleted := 0
+	for start := 0; start < len(ids); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+		if batchDeleter, ok := c.indexer.(indexer.BatchDeleter); ok {
+			if dIndexErr := batchDeleter.DeleteBatch(batch); dIndexErr != nil {
+				return deleted, fmt.Errorf("cannot delete from index: %w", dIndexErr)
+			}
+		} else {
+			for _, id := range batch {
+				if dIndexErr := c.indexer.Delete(domain.ScrapedDoc{ID: id}); dIndexErr != nil {
+					return deleted, fmt.Errorf("cannot delete from index: %w", dIndexErr)
+				}
+			}
+		}
+		if trashErr := c.db.Trash(context.TODO(), batch); trashErr != nil {
+			return deleted, fmt.Errorf("cannot move to trash: %w", trashErr)
+		}
+		deleted += len(batch)
+	}
+	return deleted, nil
+}
+
+// MergeDuplicates keeps the document with keepId and deletes the
+// documents in ids. Documents hidden as their duplicates become duplicates
+// of the document kept.
+func (c CollyScraper) MergeDuplicates(keepId string, ids []string) error {
+	if _, err := c.db.Get(context.TODO(), domain.ScrapedDoc{ID: keepId}); err != nil {
+		return fmt.Errorf("cannot find document to keep: %w", err)
+	}
+	if releaseErr := c.releaseDuplicates(keepId, ids); releaseErr != nil {
+		return releaseErr
+	}
+	for _, id := range ids {
+		if id == keepId {
+			continue
+		}
+		if err := c.trash(domain.ScrapedDoc{ID: id}); err != nil {
+			return fmt.Errorf("cannot merge %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// HideDuplicates keeps the documents in ids in the db as duplicates of
+// the document with keepId, but removes them from the index. Documents
+// hidden as their duplicates become duplicates of the document kept.
+func (c CollyScraper) HideDuplicates(keepId string, ids []string) error {
+	if _, err := c.db.Get(context.TODO(), domain.ScrapedDoc{ID: keepId}); err != nil {
+		return fmt.Errorf("cannot find document to keep: %w", err)
+	}
+	var hide []string
+	for _, id := range ids {
+		if id != keepId {
+			hide = append(hide, id)
+		}
+	}
+	if releaseErr := c.releaseDuplicates(keepId, hide); releaseErr != nil {
+		return releaseErr
+	}
+	if markErr := c.db.MarkDuplicates(context.TODO(), keepId, hide); markErr != nil {
+		return markErr
+	}
+	for _, id := range hide {
+		if dIndexErr := c.indexer.Delete(domain.ScrapedDoc{ID: id}); dIndexErr != nil {
+			return fmt.Errorf("cannot delete from index: %w", dIndexErr)
+		}
+	}
+	return nil
+}
+
 func (c CollyScraper) Scrape(doc domain.ScrapedDoc) error {
 	_, err := url.Parse(doc.URL)
 	if err != nil {
@@ -75,6 +291,220 @@ func (c CollyScraper) Scrape(doc domain.ScrapedDoc) error {
 	return c.C.Request(http.MethodGet, doc.URL, nil, ctx, nil)
 }
 
+// Rescrape fetches saved documents again, replacing their extracted
+// content while keeping the title, description, tags and collections they
+// were given. progress is called as each document is done, in the order
+// they finish.
+func (c CollyScraper) Rescrape(ctx context.Context, docs []domain.ScrapedDoc, progress func(RescrapeResult)) {
+	results := make(chan RescrapeResult)
+	for _, doc := range docs {
+		go func(doc domain.ScrapedDoc) {
+			result := RescrapeResult{ID: doc.ID, URL: doc.URL}
+			if err := c.rescrape(ctx, doc); err != nil {
+				log.Printf("could not rescrape %s: %s\n", doc.URL, err)
+				result.Error = err.Error()
+			}
+			results <- result
+		}(doc)
+	}
+	for done := 1; done <= len(docs); done++ {
+		result := <-results
+		result.Done, result.Total = done, len(docs)
+		progress(result)
+	}
+}
+
+// rescrape fetches a document again and waits until it has been saved
+func (c CollyScraper) rescrape(ctx context.Context, doc domain.ScrapedDoc) error {
+	done := make(chan error, 1)
+	if err := c.scrapeSaved(doc, done); err != nil {
+		return err
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// scrapeSaved queues a saved document to be fetched again, replacing its
+// content. done, if not nil, is sent the outcome once it has been saved.
+func (c CollyScraper) scrapeSaved(doc domain.ScrapedDoc, done chan error) error {
+	if !webPage(doc.URL) {
+		return Unscrapable
+	}
+	doc.Content = ""
+	doc.Scrape = true
+	collyCtx := colly.NewContext()
+	collyCtx.Put(DocCtxKey, doc)
+	if done != nil {
+		collyCtx.Put(doneCtxKey, done)
+	}
+	collyCtx.Put(savedCtxKey, true)
+	return c.C.Request(http.MethodGet, doc.URL, nil, collyCtx, nil)
+}
+
+// webPage reports whether rawUrl is a page the scraper can fetch
+func webPage(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// finish tells whoever waits on a request that it has been handled. Only
+// the first outcome is kept, as errors parsing a response are reported
+// after it has been handled.
+func finish(ctx *colly.Context, err error) {
+	if done, ok := ctx.GetAny(doneCtxKey).(chan error); ok {
+		select {
+		case done <- err:
+		default:
+		}
+	}
+}
+
+// Ingest indexes a html page supplied by the caller instead of fetching
+// doc.URL, for pages that the scraper cannot access itself
+func (c CollyScraper) Ingest(doc domain.ScrapedDoc, body []byte) error {
+	parsedUrl, err := url.Parse(doc.URL)
+	if err != nil {
+		return err
+	}
+	if !parsedUrl.IsAbs() {
+		return fmt.Errorf("url %s is not absolute", doc.URL)
+	}
+	doc.Scrape = true
+	if handleErr := HandleHtmlDoc(makeResponse(parsedUrl, doc, body), &doc); handleErr != nil {
+		return fmt.Errorf("could not parse ingested page: %w", handleErr)
+	}
+	return SaveAndIndex(doc, c.indexer, c.db)
+}
+
+// IngestFile indexes a file that has no URL of its own. doc.ID must be
+// set to the stable ID of the file, and doc.URL to where it can be
+// downloaded from.
+func (c CollyScraper) IngestFile(doc domain.ScrapedDoc, fileName string, body []byte) error {
+	if doc.ID == "" {
+		return errors.New("file document needs an id")
+	}
+	parsedUrl, err := url.Parse(doc.URL)
+	if err != nil {
+		return err
+	}
+	t, ok := DocTypeOfFile(fileName)
+	if !ok {
+		return fmt.Errorf("unsupported file type: %s", fileName)
+	}
+	id := doc.ID
+	doc.Scrape = true
+	if doc.Title == "" && t == domain.Pdf {
+		doc.Title = strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	}
+	if handleErr := HandleDoc(t, makeResponse(parsedUrl, doc, body), &doc); handleErr != nil {
+		return fmt.Errorf("could not parse file %s: %w", fileName, handleErr)
+	}
+	doc.ID = id
+	return SaveAndIndex(doc, c.indexer, c.db)
+}
+
+// Restore saves and indexes a previously exported document as is. Scraped
+// documents exported without their content keep the content already
+// saved for them, or web pages are scraped again.
+func (c CollyScraper) Restore(doc domain.ScrapedDoc) error {
+	if doc.ID == "" {
+		var idErr error
+		if doc.ID, idErr = IdFor(doc.Owner, doc.URL); idErr != nil {
+			return idErr
+		}
+	}
+	doc.Domain = domain.DomainOf(doc.URL)
+	if doc.Owner == "" {
+		doc.Shared = true
+	}
+	if doc.Scrape && doc.Content == "" {
+		if content, contentErr := c.db.GetContent(context.TODO(), doc.ID); contentErr == nil {
+			doc.Content = content
+		}
+	}
+	if saveErr := c.db.Save(context.TODO(), doc); saveErr != nil {
+		return fmt.Errorf("error on saving doc entry %s: %w", doc.URL, saveErr)
+	}
+	if doc.DuplicateOf == "" {
+		if indexErr := c.indexer.Index(doc); indexErr != nil {
+			return fmt.Errorf("could not index: %w", indexErr)
+		}
+	}
+	if doc.Scrape && doc.Content == "" && webPage(doc.URL) {
+		if scrapeErr := c.scrapeSaved(doc, nil); scrapeErr != nil {
+			return fmt.Errorf("could not scrape %s: %w", doc.URL, scrapeErr)
+		}
+	}
+	return nil
+}
+
+// Update changes the metadata of a saved document and returns it. Indexers
+// that can update part of a document are only sent the changed fields,
+// others index the whole document again.
+func (c CollyScraper) Update(doc domain.ScrapedDoc, update domain.DocUpdate) (domain.ScrapedDoc, error) {
+	// turning scraping on fetches the page once the update is saved, turning
+	// it off forgets what was scraped
+	scrape := update.Scrape != nil && *update.Scrape && !doc.Scrape
+	unscrape := update.Scrape != nil && !*update.Scrape
+	if scrape && !webPage(doc.URL) {
+		return domain.ScrapedDoc{}, Unscrapable
+	}
+	doc = update.Apply(doc)
+	updater, partial := c.indexer.(indexer.Updater)
+	if !partial && doc.DuplicateOf == "" && !unscrape {
+		content, contentErr := c.db.GetContent(context.TODO(), doc.ID)
+		if contentErr != nil {
+			return domain.ScrapedDoc{}, fmt.Errorf("cannot get content of %s: %w", doc.ID, contentErr)
+		}
+		doc.Content = content
+	}
+	if saveErr := c.db.Save(context.TODO(), doc); saveErr != nil {
+		return domain.ScrapedDoc{}, fmt.Errorf("error on saving doc entry %s: %w", doc.URL, saveErr)
+	}
+	if unscrape {
+		if deleteErr := c.db.DeleteContent(context.TODO(), doc.ID); deleteErr != nil {
+			return domain.ScrapedDoc{}, deleteErr
+		}
+	}
+	// duplicates aren't in the index, a partial update would add them
+	if doc.DuplicateOf == "" {
+		if partial {
+			if updateErr := updater.Update(doc.ID, update.Fields(doc)); updateErr != nil {
+				return domain.ScrapedDoc{}, fmt.Errorf("could not update index: %w", updateErr)
+			}
+		} else if indexErr := c.indexer.Index(doc); indexErr != nil {
+			return domain.ScrapedDoc{}, fmt.Errorf("could not index: %w", indexErr)
+		}
+	}
+	if scrape {
+		if scrapeErr := c.scrapeSaved(doc, nil); scrapeErr != nil {
+			return domain.ScrapedDoc{}, fmt.Errorf("could not scrape %s: %w", doc.URL, scrapeErr)
+		}
+	}
+	return doc, nil
+}
+
+// makeResponse wraps a body obtained outside the collector so it can be
+// passed to the document handlers
+func makeResponse(u *url.URL, doc domain.ScrapedDoc, body []byte) *colly.Response {
+	ctx := colly.NewContext()
+	ctx.Put(DocCtxKey, doc)
+	return &colly.Response{
+		StatusCode: http.StatusOK,
+		Body:       body,
+		Ctx:        ctx,
+		Request: &colly.Request{
+			URL:    u,
+			Method: http.MethodGet,
+			Ctx:    ctx,
+		},
+	}
+}
+
 const roleKey = "role"
 
 func ignoreElement(tagName string) bool {
@@ -138,6 +568,15 @@ func parseTitle(root *html.Node) string {
 	return ""
 }
 
+// ExtractText returns the readable text of a html document
+func ExtractText(body []byte) (string, error) {
+	rootNode, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return "", errors.New("could not parse html")
+	}
+	return parseContent(rootNode), nil
+}
+
 // http://corpus.tools/wiki/Justext/Algorithm
 func HandleHtmlDoc(response *colly.Response, parsedDoc *domain.ScrapedDoc) error {
 	rootNode, err := html.Parse(bytes.NewReader(response.Body))
@@ -151,7 +590,7 @@ func HandleHtmlDoc(response *colly.Response, parsedDoc *domain.ScrapedDoc) error
 		parsedDoc.Title = parseTitle(rootNode)
 	}
 	parsedDoc.URL = response.Request.URL.String()
-	parsedDoc.ID, err = IdFromUrl(parsedDoc.URL)
+	parsedDoc.ID, err = IdFor(parsedDoc.Owner, parsedDoc.URL)
 	if err != nil {
 		return err
 	}
@@ -169,6 +608,16 @@ func IdFromUrl(url string) (string, error) {
 	return sb.String(), nil
 }
 
+// IdFor returns the ID of the document for url in owner's library, so
+// users saving the same page get documents of their own. Documents without
+// an owner keep the IDs they had before there were users.
+func IdFor(owner, url string) (string, error) {
+	if owner == "" {
+		return IdFromUrl(url)
+	}
+	return IdFromUrl(owner + " " + url)
+}
+
 func HandlePdfDoc(response *colly.Response, s *domain.ScrapedDoc) error {
 	fileName := fmt.Sprintf(
 		"%s-%d.pdf",
@@ -194,7 +643,7 @@ func HandlePdfDoc(response *colly.Response, s *domain.ScrapedDoc) error {
 			return fmt.Errorf("could not run pdftotext cmd: %w", err)
 		}
 		s.Content = buffer.String()
-		log.Println("parsed content is", s.Content[:50])
+		log.Println("parsed content is", s.Content[:int(math.Min(float64(len(s.Content)), 50))])
 	}
 	s.DocType = domain.Pdf
 	s.URL = response.Request.URL.String()
@@ -203,13 +652,66 @@ func HandlePdfDoc(response *colly.Response, s *domain.ScrapedDoc) error {
 	}
 	log.Println("parsed title is", s.Title)
 	var err error
-	s.ID, err = IdFromUrl(s.URL)
+	s.ID, err = IdFor(s.Owner, s.URL)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// HandleTextDoc handles plain text and markdown documents
+func HandleTextDoc(response *colly.Response, s *domain.ScrapedDoc) error {
+	if s.Scrape {
+		s.Content = string(response.Body)
+	}
+	s.URL = response.Request.URL.String()
+	if s.Title == "" {
+		for _, line := range strings.Split(string(response.Body), "\n") {
+			line = strings.TrimSpace(strings.TrimLeft(line, "# "))
+			if line != "" {
+				s.Title = line
+				break
+			}
+		}
+	}
+	if s.DocType == "" {
+		s.DocType = domain.Text
+	}
+	var err error
+	s.ID, err = IdFor(s.Owner, s.URL)
+	return err
+}
+
+// HandleDoc parses response with the handler for the document type t
+func HandleDoc(t domain.DocType, response *colly.Response, s *domain.ScrapedDoc) error {
+	switch t {
+	case domain.Html:
+		return HandleHtmlDoc(response, s)
+	case domain.Pdf:
+		return HandlePdfDoc(response, s)
+	case domain.Text, domain.Markdown:
+		s.DocType = t
+		return HandleTextDoc(response, s)
+	}
+	return fmt.Errorf("unknown document type %s", t)
+}
+
+// DocTypeOfFile returns the document type for a file name, and whether
+// the file can be handled at all
+func DocTypeOfFile(name string) (domain.DocType, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".pdf":
+		return domain.Pdf, true
+	case ".html", ".htm":
+		return domain.Html, true
+	case ".md", ".markdown":
+		return domain.Markdown, true
+	case ".txt", ".text", ".rst", ".org", ".csv", ".log":
+		return domain.Text, true
+	}
+	return "", false
+}
+
 func DocTypeOf(request *colly.Request) domain.DocType {
 	ext := filepath.Ext(strings.TrimPrefix(request.URL.Path, "/"))
 	if ext == ".pdf" {
@@ -223,19 +725,69 @@ func DocTypeOf(request *colly.Request) domain.DocType {
 }
 
 func SaveAndIndex(s domain.ScrapedDoc, indexer indexer.Indexer, db UrlRepo) error {
-	s.ParsedDate = domain.Timestamp(time.Now())
+	// timestamps are exported in seconds, so only keep seconds
+	s.ParsedDate = domain.Timestamp(time.Now().Truncate(time.Second))
 	if s.ID == "" {
 		var idErr error
-		s.ID, idErr = IdFromUrl(s.URL)
+		s.ID, idErr = IdFor(s.Owner, s.URL)
 		if idErr != nil {
 			return idErr
 		}
 	}
 
+	if s.Content != "" {
+		s.Fingerprint = domain.SimHashOf(s.Content)
+	}
+	// keep what the user organised when a document is scraped again
+	if existing, getErr := db.Get(context.Background(), s); getErr == nil {
+		s.DuplicateOf = existing.DuplicateOf
+		if s.Tags == nil {
+			s.Tags = existing.Tags
+		}
+		if s.Collections == nil {
+			s.Collections = existing.Collections
+		}
+		if s.Annotations == nil {
+			s.Annotations = existing.Annotations
+		}
+		if time.Time(s.CreatedAt).IsZero() {
+			s.CreatedAt = existing.CreatedAt
+		}
+		if s.Status == "" {
+			s.Status = existing.Status
+		}
+		if s.ReadAt == nil {
+			s.ReadAt = existing.ReadAt
+		}
+		if s.Progress == 0 {
+			s.Progress = existing.Progress
+		}
+		s.Favourite = s.Favourite || existing.Favourite
+		s.Shared = s.Shared || existing.Shared
+	}
+	if s.Owner == "" {
+		// documents without an owner belong to the team
+		s.Shared = true
+	}
+	if s.Status == "" {
+		s.Status = domain.Unread
+	}
+	if time.Time(s.CreatedAt).IsZero() {
+		s.CreatedAt = s.ParsedDate
+	}
+	s.Tags = domain.NormalizeTags(s.Tags)
+	s.Collections = domain.NormalizeCollections(s.Collections)
+	s.Domain = domain.DomainOf(s.URL)
+
 	if saveErr := db.Save(context.Background(), s); saveErr != nil {
 		return fmt.Errorf("error on saving doc entry %s: %w", s.URL, saveErr)
 	}
 
+	if s.DuplicateOf != "" {
+		log.Printf("not indexing %s, duplicate of %s\n", s.ID, s.DuplicateOf)
+		return nil
+	}
+
 	if indexErr := indexer.Index(s); indexErr != nil {
 		return fmt.Errorf("could not index: %w", indexErr)
 	}
@@ -253,6 +805,11 @@ func MakeCollector(indexer indexer.Indexer, db UrlRepo) *colly.Collector {
 		colly.AllowURLRevisit(),
 	)
 
+	// bulk imports queue many requests at once
+	if err := c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: maxParallelScrapes}); err != nil {
+		panic(fmt.Sprintf("invalid limit rule: %s", err))
+	}
+
 	c.WithTransport(&http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 	})
@@ -269,13 +826,15 @@ func MakeCollector(indexer indexer.Indexer, db UrlRepo) *colly.Collector {
 
 		s.DocType = DocTypeOf(request)
 
-		if siErr := SaveAndIndex(s, indexer, db); siErr != nil {
+		siErr := SaveAndIndex(s, indexer, db)
+		if siErr != nil {
 			log.Printf(
 				"error on saving and indexing doc entry %s: %s\n",
 				s.URL,
 				siErr,
 			)
 		}
+		finish(request.Ctx, siErr)
 	})
 
 	// On every element which has href attribute call callback
@@ -290,32 +849,30 @@ func MakeCollector(indexer indexer.Indexer, db UrlRepo) *colly.Collector {
 	c.OnResponse(func(response *colly.Response) {
 		t := DocTypeOf(response.Request)
 		s := response.Ctx.GetAny(DocCtxKey).(domain.ScrapedDoc)
-		var err error
-		switch t {
-		case domain.Html:
-			err = HandleHtmlDoc(response, &s)
-		case domain.Pdf:
-			err = HandlePdfDoc(response, &s)
-		default:
-			log.Println("unknown document type for url", response.Request.URL)
-			return
-		}
-		if err != nil {
+		id, savedUrl := s.ID, s.URL
+		if err := HandleDoc(t, response, &s); err != nil {
 			log.Println("could scrape document:", err)
+			finish(response.Ctx, err)
 			return
 		}
+		if saved, _ := response.Ctx.GetAny(savedCtxKey).(bool); saved {
+			s.ID, s.URL = id, savedUrl
+		}
 
-		if siErr := SaveAndIndex(s, indexer, db); siErr != nil {
+		siErr := SaveAndIndex(s, indexer, db)
+		if siErr != nil {
 			log.Printf(
 				"error on saving and indexing doc entry %s: %s\n",
 				s.URL,
 				siErr,
 			)
 		}
+		finish(response.Ctx, siErr)
 	})
 
 	c.OnError(func(response *colly.Response, err error) {
 		log.Printf("error on scraping url %s: %s\n", response.Request.URL, err)
+		finish(response.Ctx, err)
 	})
 
 	return c
@@ -1,9 +1,17 @@
 package scraper
 
 import (
+	"context"
+	"errors"
 	"golang.org/x/net/html"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+	"zeno/domain"
 )
 
 func MustParse(doc string) *html.Node {
@@ -75,3 +83,422 @@ func TestParseContent(t *testing.T) {
 		})
 	}
 }
+
+type memRepo map[string]domain.ScrapedDoc
+
+func (m memRepo) Save(_ context.Context, doc domain.ScrapedDoc) error {
+	m[doc.ID] = doc
+	return nil
+}
+
+func (m memRepo) Get(_ context.Context, doc domain.ScrapedDoc) (domain.ScrapedDoc, error) {
+	d, ok := m[doc.ID]
+	if !ok || d.DeletedAt != nil {
+		return domain.ScrapedDoc{}, errors.New("not found")
+	}
+	return d, nil
+}
+
+func (m memRepo) GetContent(_ context.Context, id string) (string, error) {
+	d, ok := m[id]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return d.Content, nil
+}
+
+func (m memRepo) DeleteContent(_ context.Context, id string) error {
+	if d, ok := m[id]; ok {
+		d.Content = ""
+		m[id] = d
+	}
+	return nil
+}
+
+func (m memRepo) GetAll(_ context.Context) ([]domain.ScrapedDoc, error) {
+	var docs []domain.ScrapedDoc
+	for _, d := range m {
+		docs = append(docs, d)
+	}
+	return docs, nil
+}
+
+func (m memRepo) Delete(_ context.Context, doc domain.ScrapedDoc) error {
+	delete(m, doc.ID)
+	return nil
+}
+
+func (m memRepo) DeleteBatch(_ context.Context, ids []string) error {
+	for _, id := range ids {
+		delete(m, id)
+	}
+	return nil
+}
+
+func (m memRepo) Trash(_ context.Context, ids []string) error {
+	now := domain.Timestamp(time.Now())
+	for _, id := range ids {
+		if d, ok := m[id]; ok {
+			d.DeletedAt = &now
+			m[id] = d
+		}
+	}
+	return nil
+}
+
+func (m memRepo) Untrash(_ context.Context, id string) (domain.ScrapedDoc, error) {
+	d, ok := m[id]
+	if !ok || d.DeletedAt == nil {
+		return domain.ScrapedDoc{}, errors.New("not found")
+	}
+	d.DeletedAt = nil
+	m[id] = d
+	return d, nil
+}
+
+func (m memRepo) MarkDuplicates(_ context.Context, keepId string, ids []string) error {
+	for _, id := range ids {
+		d := m[id]
+		d.DuplicateOf = keepId
+		m[id] = d
+	}
+	return nil
+}
+
+func (m memRepo) DuplicatesOf(_ context.Context, ids []string) ([]domain.ScrapedDoc, error) {
+	var docs []domain.ScrapedDoc
+	for _, d := range m {
+		for _, id := range ids {
+			if d.DuplicateOf == id && d.DeletedAt == nil {
+				docs = append(docs, d)
+			}
+		}
+	}
+	return docs, nil
+}
+
+type memIndexer map[string]domain.ScrapedDoc
+
+func (m memIndexer) Index(doc domain.ScrapedDoc) error {
+	m[doc.ID] = doc
+	return nil
+}
+
+func (m memIndexer) Delete(doc domain.ScrapedDoc) error {
+	delete(m, doc.ID)
+	return nil
+}
+
+// memUpdater is an indexer that can update part of a document
+type memUpdater struct {
+	memIndexer
+	updates map[string]map[string]interface{}
+}
+
+func (m memUpdater) Update(id string, fields map[string]interface{}) error {
+	m.updates[id] = fields
+	return nil
+}
+
+func TestUpdate(t *testing.T) {
+	title, tags := "Better title", []string{"Go", "go", "db"}
+	update := domain.DocUpdate{Title: &title, Tags: &tags}
+	saved := domain.ScrapedDoc{ID: "1", URL: "https://a.example", Title: "Wrong", Description: "kept", Content: "text"}
+
+	repo, index := memRepo{"1": saved}, memIndexer{}
+	updated, err := NewCollyScraper(index, repo).Update(saved, update)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Title != title || updated.Description != "kept" || !reflect.DeepEqual(updated.Tags, []string{"go", "db"}) {
+		t.Errorf("Update() = %s with tags %v", updated, updated.Tags)
+	}
+	if repo["1"].Title != title || index["1"].Content != "text" {
+		t.Errorf("Update() saved %s and indexed %s", repo["1"], index["1"])
+	}
+
+	repo, updater := memRepo{"1": saved}, memUpdater{memIndexer: memIndexer{}, updates: map[string]map[string]interface{}{}}
+	if _, err := NewCollyScraper(updater, repo).Update(saved, update); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	want := map[string]interface{}{"title": title, "tags": []string{"go", "db"}}
+	if !reflect.DeepEqual(updater.updates["1"], want) || len(updater.memIndexer) != 0 {
+		t.Errorf("Update() sent %v to the index, want only %v", updater.updates["1"], want)
+	}
+
+	duplicate := saved
+	duplicate.DuplicateOf = "2"
+	repo, updater = memRepo{"1": duplicate}, memUpdater{memIndexer: memIndexer{}, updates: map[string]map[string]interface{}{}}
+	if _, err := NewCollyScraper(updater, repo).Update(duplicate, update); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if len(updater.updates) != 0 {
+		t.Error("Update() should not index duplicates")
+	}
+
+	status := domain.Read
+	repo, index = memRepo{"1": saved}, memIndexer{}
+	read, err := NewCollyScraper(index, repo).Update(saved, domain.DocUpdate{Status: &status})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if read.Status != domain.Read || read.ReadAt == nil {
+		t.Errorf("Update() to read = status %s, read at %v", read.Status, read.ReadAt)
+	}
+}
+
+func TestUpdateScrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html><head><title>Page title</title></head><body><main><p>new text</p></main></body></html>`))
+	}))
+	defer server.Close()
+	on, off := true, false
+
+	bookmark := domain.ScrapedDoc{ID: "1", URL: server.URL + "/page", Title: "My title"}
+	repo, index := memRepo{"1": bookmark}, memIndexer{}
+	c := NewCollyScraper(index, repo)
+	if _, err := c.Update(bookmark, domain.DocUpdate{Scrape: &on}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	c.C.Wait()
+	if got := index["1"]; got.Content != "new text " || got.Title != "My title" || !got.Scrape || got.Fingerprint == 0 {
+		t.Errorf("Update() to scraped indexed %s with fingerprint %v", got, got.Fingerprint)
+	}
+
+	file := domain.ScrapedDoc{ID: "3", URL: "/zeno/files/3"}
+	if _, err := NewCollyScraper(memIndexer{}, memRepo{"3": file}).Update(file, domain.DocUpdate{Scrape: &on}); !errors.Is(err, Unscrapable) {
+		t.Errorf("Update() to scraped of a file error = %v, want %v", err, Unscrapable)
+	}
+
+	scraped := domain.ScrapedDoc{ID: "1", URL: server.URL + "/page", Scrape: true, Content: "text", Fingerprint: domain.SimHashOf("text")}
+	repo, index = memRepo{"1": scraped}, memIndexer{}
+	updated, err := NewCollyScraper(index, repo).Update(scraped, domain.DocUpdate{Scrape: &off})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Fingerprint != 0 || repo["1"].Content != "" || repo["1"].Fingerprint != 0 || index["1"].Content != "" {
+		t.Errorf("Update() to not scraped saved %s and indexed %s", repo["1"], index["1"])
+	}
+
+	repo, updater := memRepo{"1": scraped}, memUpdater{memIndexer: memIndexer{}, updates: map[string]map[string]interface{}{}}
+	if _, err := NewCollyScraper(updater, repo).Update(scraped, domain.DocUpdate{Scrape: &off}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	want := map[string]interface{}{"scraped": false, "content": "", "fingerprint": domain.SimHash(0)}
+	if !reflect.DeepEqual(updater.updates["1"], want) {
+		t.Errorf("Update() sent %v to the index, want %v", updater.updates["1"], want)
+	}
+}
+
+func TestSaveAndIndexKeepsAnnotations(t *testing.T) {
+	annotations := []domain.Annotation{{ID: "n1", Quote: "text", Comment: "note"}}
+	saved := domain.ScrapedDoc{ID: "1", URL: "https://a.example", Content: "text", Annotations: annotations}
+	repo, index := memRepo{"1": saved}, memIndexer{}
+
+	if err := SaveAndIndex(domain.ScrapedDoc{ID: "1", URL: "https://a.example", Content: "new text"}, index, repo); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(repo["1"].Annotations, annotations) || !reflect.DeepEqual(index["1"].Annotations, annotations) {
+		t.Errorf("SaveAndIndex() saved annotations %v, indexed %v", repo["1"].Annotations, index["1"].Annotations)
+	}
+}
+
+func TestSaveAndIndexKeepsReadState(t *testing.T) {
+	readAt := domain.Timestamp(time.Unix(1700000000, 0))
+	saved := domain.ScrapedDoc{ID: "1", URL: "https://a.example", Content: "text",
+		Status: domain.Read, ReadAt: &readAt, Progress: 0.5, Favourite: true}
+	repo, index := memRepo{"1": saved}, memIndexer{}
+
+	if err := SaveAndIndex(domain.ScrapedDoc{ID: "1", URL: "https://a.example", Content: "new text"}, index, repo); err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string]domain.ScrapedDoc{"saved": repo["1"], "indexed": index["1"]} {
+		if got.Status != domain.Read || got.ReadAt == nil || *got.ReadAt != readAt || got.Progress != 0.5 || !got.Favourite {
+			t.Errorf("SaveAndIndex() %s status %s, read at %v, progress %v, favourite %v",
+				name, got.Status, got.ReadAt, got.Progress, got.Favourite)
+		}
+	}
+}
+
+func TestDeleteBatch(t *testing.T) {
+	repo, index := memRepo{}, memIndexer{}
+	var ids []string
+	for i := 0; i < deleteBatchSize+10; i++ {
+		doc := domain.ScrapedDoc{ID: strconv.Itoa(i)}
+		repo[doc.ID], index[doc.ID] = doc, doc
+		ids = append(ids, doc.ID)
+	}
+	repo["kept"], index["kept"] = domain.ScrapedDoc{ID: "kept"}, domain.ScrapedDoc{ID: "kept"}
+
+	c := NewCollyScraper(index, repo)
+	deleted, err := c.DeleteBatch(ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != len(ids) || len(index) != 1 {
+		t.Errorf("DeleteBatch() deleted %d, left %d indexed", deleted, len(index))
+	}
+	if _, err := repo.Get(context.Background(), domain.ScrapedDoc{ID: "0"}); err == nil || repo["0"].DeletedAt == nil {
+		t.Error("deleted doc should be in the trash")
+	}
+
+	// restoring indexes the document again
+	if _, err := c.Untrash("0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := index["0"]; !ok || repo["0"].DeletedAt != nil {
+		t.Error("restored doc should be indexed and out of the trash")
+	}
+	if _, err := c.Untrash("kept"); err == nil {
+		t.Error("Untrash() of a doc outside the trash should fail")
+	}
+
+	if err := c.Purge(ids[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo) != 2 {
+		t.Errorf("Purge() left %d docs, want 2", len(repo))
+	}
+}
+
+func TestDuplicatesOfRemovedDocs(t *testing.T) {
+	docs := func() (memRepo, memIndexer) {
+		return memRepo{
+			"a":  {ID: "a", Content: "a"},
+			"b":  {ID: "b", Content: "b"},
+			"b1": {ID: "b1", Content: "b1", DuplicateOf: "b"},
+		}, memIndexer{
+			"a": {ID: "a", Content: "a"},
+			"b": {ID: "b", Content: "b"},
+		}
+	}
+	tests := []struct {
+		name        string
+		remove      func(c CollyScraper) error
+		duplicateOf string
+	}{
+		{name: "merge", remove: func(c CollyScraper) error { return c.MergeDuplicates("a", []string{"b"}) }, duplicateOf: "a"},
+		{name: "hide", remove: func(c CollyScraper) error { return c.HideDuplicates("a", []string{"b"}) }, duplicateOf: "a"},
+		{name: "delete", remove: func(c CollyScraper) error { return c.Delete(domain.ScrapedDoc{ID: "b"}) }},
+		{name: "delete batch", remove: func(c CollyScraper) error {
+			_, err := c.DeleteBatch([]string{"b"})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, index := docs()
+			if err := tt.remove(NewCollyScraper(index, repo)); err != nil {
+				t.Fatal(err)
+			}
+			if got := repo["b1"].DuplicateOf; got != tt.duplicateOf {
+				t.Errorf("duplicate of b is now a duplicate of %q, want %q", got, tt.duplicateOf)
+			}
+			// duplicates of nothing are shown in search again
+			if _, indexed := index["b1"]; indexed != (tt.duplicateOf == "") {
+				t.Errorf("duplicate of b indexed = %v", indexed)
+			}
+		})
+	}
+}
+
+func TestRescrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/page" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`<html><head><title>Page title</title></head><body><main><p>new text</p></main></body></html>`))
+	}))
+	defer server.Close()
+	docs := []domain.ScrapedDoc{
+		{ID: "1", URL: server.URL + "/page", Title: "My title", Tags: []string{"mine"}, Content: "old text"},
+		{ID: "2", URL: server.URL + "/gone", Content: "old text"},
+		{ID: "3", URL: "/zeno/files/3", Content: "uploaded"},
+	}
+	repo, index := memRepo{}, memIndexer{}
+	for _, doc := range docs {
+		repo[doc.ID] = doc
+	}
+	c := NewCollyScraper(index, repo)
+
+	results := make(map[string]RescrapeResult)
+	c.Rescrape(context.Background(), docs, func(result RescrapeResult) {
+		results[result.ID] = result
+		if result.Total != len(docs) || result.Done != len(results) {
+			t.Errorf("progress %d/%d after %d documents", result.Done, result.Total, len(results))
+		}
+	})
+	if results["1"].Error != "" || results["2"].Error == "" || results["3"].Error == "" {
+		t.Errorf("Rescrape() results = %v", results)
+	}
+	got := index["1"]
+	if got.Content != "new text " || got.Title != "My title" || !reflect.DeepEqual(got.Tags, []string{"mine"}) {
+		t.Errorf("Rescrape() indexed %s with tags %v", got, got.Tags)
+	}
+	if repo["2"].Content != "old text" {
+		t.Error("failed rescrape should keep the saved content")
+	}
+}
+
+func TestIngest(t *testing.T) {
+	repo, index := memRepo{}, memIndexer{}
+	c := NewCollyScraper(index, repo)
+	page := `<html><head><title>Team Wiki</title></head><body><main><p>internal notes</p></main></body></html>`
+
+	if err := c.Ingest(domain.ScrapedDoc{URL: "relative/page"}, []byte(page)); err == nil {
+		t.Error("Ingest() with relative url should fail")
+	}
+
+	if err := c.Ingest(domain.ScrapedDoc{URL: "https://wiki.example/page"}, []byte(page)); err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+	id, _ := IdFromUrl("https://wiki.example/page")
+	doc, ok := index[id]
+	if !ok {
+		t.Fatal("ingested doc was not indexed")
+	}
+	if doc.Title != "Team Wiki" || doc.Content != "internal notes " || doc.DocType != domain.Html {
+		t.Errorf("Ingest() indexed %s", doc)
+	}
+	if _, ok := repo[id]; !ok {
+		t.Error("ingested doc was not saved")
+	}
+	if !doc.Shared {
+		t.Error("doc without an owner should be shared")
+	}
+
+	// users ingesting the same page get documents of their own
+	if err := c.Ingest(domain.ScrapedDoc{URL: "https://wiki.example/page", Owner: "ada"}, []byte(page)); err != nil {
+		t.Fatalf("Ingest() error = %v", err)
+	}
+	ownedId, _ := IdFor("ada", "https://wiki.example/page")
+	owned, ok := repo[ownedId]
+	if ownedId == id || !ok {
+		t.Fatal("owned doc was not saved under an id of its own")
+	}
+	if owned.Owner != "ada" || owned.Shared {
+		t.Errorf("Ingest() saved owner %q, shared %v", owned.Owner, owned.Shared)
+	}
+}
+
+func TestIngestFile(t *testing.T) {
+	repo, index := memRepo{}, memIndexer{}
+	c := NewCollyScraper(index, repo)
+	doc := domain.ScrapedDoc{ID: "abc123", URL: "/zeno/files/abc123"}
+
+	if err := c.IngestFile(doc, "notes.exe", []byte("binary")); err == nil {
+		t.Error("IngestFile() with unsupported type should fail")
+	}
+
+	if err := c.IngestFile(doc, "spec.md", []byte("\n# Design Spec\n\nsome notes")); err != nil {
+		t.Fatalf("IngestFile() error = %v", err)
+	}
+	got, ok := index["abc123"]
+	if !ok {
+		t.Fatal("file was not indexed under its id")
+	}
+	if got.Title != "Design Spec" || got.DocType != domain.Markdown || got.URL != "/zeno/files/abc123" {
+		t.Errorf("IngestFile() indexed %s", got)
+	}
+}
@@ -0,0 +1,316 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"zeno/domain"
+	"zeno/scraper"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay is how long a file has to go without changes before it
+// is indexed, so editors saving in several writes trigger one scrape
+const settleDelay = 500 * time.Millisecond
+
+type FileIngester interface {
+	IngestFile(doc domain.ScrapedDoc, fileName string, body []byte) error
+	Delete(doc domain.ScrapedDoc) error
+}
+
+type DocRepo interface {
+	Get(ctx context.Context, scrapedDoc domain.ScrapedDoc) (domain.ScrapedDoc, error)
+	FindByURLPrefix(ctx context.Context, prefix string) ([]domain.ScrapedDoc, error)
+}
+
+// DirWatcher keeps the documents of files under a set of directories in
+// sync with the files on disk
+type DirWatcher struct {
+	dirs    []string
+	s       FileIngester
+	db      DocRepo
+	watcher *fsnotify.Watcher
+
+	mu      sync.Mutex
+	pending map[string]*time.Timer
+	closed  bool
+
+	closeOnce sync.Once
+	closeErr  error
+	done      chan struct{}
+}
+
+func NewDirWatcher(s FileIngester, db DocRepo, dirs []string) (*DirWatcher, error) {
+	if s == nil {
+		panic("ingester cannot be nil")
+	}
+	var absDirs []string
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch dir %s: %w", dir, err)
+		}
+		absDirs = append(absDirs, abs)
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("could not create watcher: %w", err)
+	}
+	return &DirWatcher{
+		dirs:    absDirs,
+		s:       s,
+		db:      db,
+		watcher: w,
+		pending: make(map[string]*time.Timer),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// FileUrl returns the file:// url used for the document of path
+func FileUrl(path string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(path)}
+	return u.String()
+}
+
+// dirUrl returns the prefix of the file:// urls of the files under dir
+func dirUrl(dir string) string {
+	return strings.TrimSuffix(FileUrl(dir), "/") + "/"
+}
+
+// Start indexes new and changed files, removes documents of files that
+// were deleted while zeno was not running, and starts watching for changes
+func (d *DirWatcher) Start() error {
+	for _, dir := range d.dirs {
+		if err := d.addTree(dir); err != nil {
+			return err
+		}
+	}
+	d.removeMissing()
+	go d.run()
+	return nil
+}
+
+// Close stops watching and drops the files waiting to be synced. Closing
+// again does nothing.
+func (d *DirWatcher) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+		d.mu.Lock()
+		d.closed = true
+		for path, t := range d.pending {
+			t.Stop()
+			delete(d.pending, path)
+		}
+		d.mu.Unlock()
+		d.closeErr = d.watcher.Close()
+	})
+	return d.closeErr
+}
+
+func (d *DirWatcher) run() {
+	for {
+		select {
+		case <-d.done:
+			return
+		case event, ok := <-d.watcher.Events:
+			if !ok {
+				return
+			}
+			if ignored(event.Name) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if addErr := d.addTree(event.Name); addErr != nil {
+						log.Println("could not watch new dir:", addErr)
+					}
+					continue
+				}
+			}
+			d.schedule(event.Name)
+		case err, ok := <-d.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("watcher error:", err)
+		}
+	}
+}
+
+// addTree watches dir and every directory below it, and syncs the files
+// found in them
+func (d *DirWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && ignored(path) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			if watchErr := d.watcher.Add(path); watchErr != nil {
+				return fmt.Errorf("could not watch %s: %w", path, watchErr)
+			}
+			return nil
+		}
+		if info, infoErr := entry.Info(); infoErr == nil && d.upToDate(path, info) {
+			return nil
+		}
+		d.schedule(path)
+		return nil
+	})
+}
+
+// upToDate reports whether the document of path was parsed after the
+// file was last modified
+func (d *DirWatcher) upToDate(path string, info fs.FileInfo) bool {
+	id, err := scraper.IdFromUrl(FileUrl(path))
+	if err != nil {
+		return false
+	}
+	doc, getErr := d.db.Get(context.Background(), domain.ScrapedDoc{ID: id})
+	if getErr != nil {
+		return false
+	}
+	return !time.Time(doc.ParsedDate).Before(info.ModTime())
+}
+
+// removeMissing deletes the documents of watched files that no longer exist
+func (d *DirWatcher) removeMissing() {
+	for _, dir := range d.dirs {
+		docs, err := d.db.FindByURLPrefix(context.Background(), dirUrl(dir))
+		if err != nil {
+			log.Println("could not check for deleted files:", err)
+			return
+		}
+		for _, doc := range docs {
+			path, ok := d.pathOf(doc)
+			if !ok {
+				continue
+			}
+			if _, statErr := os.Stat(path); errors.Is(statErr, os.ErrNotExist) {
+				d.delete(doc, path)
+			}
+		}
+	}
+}
+
+// pathOf returns the local path of a document created by the watcher
+func (d *DirWatcher) pathOf(doc domain.ScrapedDoc) (string, bool) {
+	u, err := url.Parse(doc.URL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	path := filepath.FromSlash(u.Path)
+	for _, dir := range d.dirs {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+func (d *DirWatcher) schedule(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	if t, ok := d.pending[path]; ok {
+		t.Reset(settleDelay)
+		return
+	}
+	d.pending[path] = time.AfterFunc(settleDelay, func() {
+		d.mu.Lock()
+		delete(d.pending, path)
+		// the timer may have fired just as the watcher was closed
+		closed := d.closed
+		d.mu.Unlock()
+		if !closed {
+			d.sync(path)
+		}
+	})
+}
+
+// sync indexes path if it exists, and otherwise removes its document
+// along with the documents of any files below it
+func (d *DirWatcher) sync(path string) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		d.remove(path)
+		return
+	}
+	if err != nil {
+		log.Printf("could not stat %s: %s\n", path, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		return
+	}
+	if _, ok := scraper.DocTypeOfFile(path); !ok {
+		return
+	}
+
+	body, readErr := os.ReadFile(path)
+	if readErr != nil {
+		log.Printf("could not read %s: %s\n", path, readErr)
+		return
+	}
+	fileUrl := FileUrl(path)
+	id, idErr := scraper.IdFromUrl(fileUrl)
+	if idErr != nil {
+		log.Println(idErr)
+		return
+	}
+	log.Println("indexing watched file", path)
+	if ingestErr := d.s.IngestFile(domain.ScrapedDoc{ID: id, URL: fileUrl}, path, body); ingestErr != nil {
+		log.Printf("could not index %s: %s\n", path, ingestErr)
+	}
+}
+
+// remove deletes the document of path, or the documents of the files
+// below it when path was a directory
+func (d *DirWatcher) remove(path string) {
+	if id, idErr := scraper.IdFromUrl(FileUrl(path)); idErr == nil {
+		if doc, getErr := d.db.Get(context.Background(), domain.ScrapedDoc{ID: id}); getErr == nil {
+			d.delete(doc, path)
+			return
+		}
+	}
+	docs, err := d.db.FindByURLPrefix(context.Background(), dirUrl(path))
+	if err != nil {
+		log.Println("could not fetch documents:", err)
+		return
+	}
+	for _, doc := range docs {
+		if docPath, ok := d.pathOf(doc); ok {
+			d.delete(doc, docPath)
+		}
+	}
+}
+
+// delete deletes the document of the watched file at path
+func (d *DirWatcher) delete(doc domain.ScrapedDoc, path string) {
+	log.Println("removing watched file", path)
+	if deleteErr := d.s.Delete(doc); deleteErr != nil {
+		log.Printf("could not remove %s: %s\n", path, deleteErr)
+	}
+}
+
+// ignored reports whether path is a hidden file or an editor backup
+func ignored(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~")
+}
@@ -0,0 +1,169 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"zeno/domain"
+)
+
+type memLibrary struct {
+	mu   sync.Mutex
+	docs map[string]domain.ScrapedDoc
+}
+
+func (m *memLibrary) IngestFile(doc domain.ScrapedDoc, _ string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc.Content = string(body)
+	doc.ParsedDate = domain.Timestamp(time.Now())
+	m.docs[doc.ID] = doc
+	return nil
+}
+
+func (m *memLibrary) Delete(doc domain.ScrapedDoc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, doc.ID)
+	return nil
+}
+
+func (m *memLibrary) Get(_ context.Context, doc domain.ScrapedDoc) (domain.ScrapedDoc, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.docs[doc.ID]
+	if !ok {
+		return domain.ScrapedDoc{}, errors.New("not found")
+	}
+	return d, nil
+}
+
+func (m *memLibrary) FindByURLPrefix(_ context.Context, prefix string) ([]domain.ScrapedDoc, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var docs []domain.ScrapedDoc
+	for _, d := range m.docs {
+		if strings.HasPrefix(d.URL, prefix) {
+			docs = append(docs, d)
+		}
+	}
+	return docs, nil
+}
+
+func (m *memLibrary) contents() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := make(map[string]string)
+	for _, d := range m.docs {
+		c[d.URL] = d.Content
+	}
+	return c
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestDirWatcher(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.md")
+	if err := os.WriteFile(existing, []byte("# existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := &memLibrary{docs: map[string]domain.ScrapedDoc{}}
+	// a document for a file deleted while zeno was not running
+	gone := FileUrl(filepath.Join(dir, "gone.txt"))
+	lib.docs["gone"] = domain.ScrapedDoc{ID: "gone", URL: gone}
+	// and one for a file that is not watched, in a dir named like the
+	// watched one
+	other := FileUrl(filepath.Join(dir+"-other", "gone.txt"))
+	lib.docs["other"] = domain.ScrapedDoc{ID: "other", URL: other}
+
+	w, err := NewDirWatcher(lib, lib, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	waitFor(t, "initial scan", func() bool {
+		c := lib.contents()
+		_, stale := c[gone]
+		return c[FileUrl(existing)] == "# existing" && !stale
+	})
+
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	nested := filepath.Join(sub, "notes.txt")
+	// give the watcher a moment to watch the new directory
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(nested, []byte("draft"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "ignored.bin"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "new file", func() bool { return lib.contents()[FileUrl(nested)] == "draft" })
+
+	if err := os.WriteFile(nested, []byte("final"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "changed file", func() bool { return lib.contents()[FileUrl(nested)] == "final" })
+
+	if err := os.RemoveAll(sub); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "deleted dir", func() bool {
+		_, ok := lib.contents()[FileUrl(nested)]
+		return !ok
+	})
+	if c := lib.contents(); len(c) != 2 || c[other] != "" {
+		t.Errorf("expected only existing.md and the unwatched file to remain, got %v", c)
+	}
+}
+
+func TestDirWatcherClose(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "note.md")
+	if err := os.WriteFile(path, []byte("# note"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	lib := &memLibrary{docs: map[string]domain.ScrapedDoc{}}
+	w, err := NewDirWatcher(lib, lib, []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file is waiting to be synced when the watcher is closed
+	if err := w.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+	w.schedule(path)
+
+	time.Sleep(2 * settleDelay)
+	if docs := lib.contents(); len(docs) != 0 {
+		t.Errorf("closed watcher synced %v", docs)
+	}
+}